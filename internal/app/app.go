@@ -1,16 +1,30 @@
 package app
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
-	"path/filepath"
+	"io"
+	"os"
+	"path"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
 	"github.com/ktr0731/go-fuzzyfinder"
+	"github.com/upamune/airule/internal/builtin"
 	"github.com/upamune/airule/internal/cli"
 	"github.com/upamune/airule/internal/copier"
 	"github.com/upamune/airule/internal/finder"
+	"github.com/upamune/airule/internal/frontmatter"
+	"github.com/upamune/airule/internal/ignore"
+	"github.com/upamune/airule/internal/linkfs"
+	"github.com/upamune/airule/internal/pattern"
 	"github.com/upamune/airule/internal/preview"
+	"github.com/upamune/airule/internal/source"
 )
 
 // App represents the main application
@@ -27,89 +41,601 @@ func NewApp(cliArgs cli.CLI) *App {
 
 // matchesAnyPattern checks if a file path matches any of the provided patterns
 func matchesAnyPattern(filePath string, patterns []string) bool {
-	for _, pattern := range patterns {
-		// Match against the full path or just the basename if the pattern doesn't contain a separator
-		base := filepath.Base(filePath)
-		matchPath, _ := filepath.Match(pattern, filePath)
-		matchBase := false
-		if !strings.Contains(pattern, string(filepath.Separator)) {
-			matchBase, _ = filepath.Match(pattern, base)
-		}
-		if matchPath || matchBase {
-			return true
-		}
-
-		// Handle directory patterns specifically (e.g., "dir/*" or "dir/**")
-		if strings.HasSuffix(pattern, "/*") || strings.HasSuffix(pattern, "/**") {
-			dirPattern := strings.TrimSuffix(strings.TrimSuffix(pattern, "*"), "/")
-			// Ensure dirPattern is not empty and path actually starts with it + separator
-			if dirPattern != "" && strings.HasPrefix(filePath, dirPattern+string(filepath.Separator)) {
-				return true
+	return pattern.MatchAny(filePath, patterns)
+}
+
+// findOptions builds the finder options from the CLI arguments.
+// Rules from --rules-file are evaluated before the rules given with --rule.
+func (a *App) findOptions() (finder.Options, error) {
+	opts := finder.Options{
+		Includes: a.cliArgs.Include,
+		Excludes: a.cliArgs.Exclude,
+	}
+	if !a.cliArgs.NoIgnore {
+		opts.IgnoreFiles = ignore.DefaultFiles
+	}
+
+	if a.cliArgs.RulesFile != "" {
+		rules, err := pattern.LoadRules(a.cliArgs.RulesFile)
+		if err != nil {
+			return opts, err
+		}
+		opts.Rules = append(opts.Rules, rules...)
+	}
+
+	rules, err := pattern.ParseRules(a.cliArgs.Rules)
+	if err != nil {
+		return opts, err
+	}
+	opts.Rules = append(opts.Rules, rules...)
+
+	opts.Where, err = frontmatter.ParseConditions(a.cliArgs.Where)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Content, err = a.cliArgs.ContentMatcher()
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Attrs, err = a.cliArgs.AttrFilter()
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Symlinks, err = linkfs.ParsePolicy(a.cliArgs.Symlinks)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Order.Key, err = finder.ParseSortKey(a.cliArgs.Sort)
+	if err != nil {
+		return opts, err
+	}
+	opts.Order.Group, err = finder.ParseGrouping(a.cliArgs.Group)
+	if err != nil {
+		return opts, err
+	}
+	opts.Order.Descending = a.cliArgs.SortDesc
+	opts.Order.DirsFirst = a.cliArgs.DirsFirst
+
+	opts.Entries, err = finder.ParseEntryMode(a.cliArgs.Entries)
+	if err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+// listBuiltin prints the built-in rule sets, their files and the library version
+func listBuiltin(w io.Writer) error {
+	sets, err := builtin.Sets()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Built-in rules %s\n", builtin.Version())
+	for _, set := range sets {
+		fmt.Fprintf(w, "\n%s%s (%d file(s))\n", builtin.Scheme, set.Name, len(set.Files))
+		for _, file := range set.Files {
+			fmt.Fprintf(w, "  %s\n", file)
+		}
+	}
+	return nil
+}
+
+// checkWarnings fails with every warning listed when strict is set
+func checkWarnings(warnings []string, strict bool) error {
+	if !strict || len(warnings) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d problem(s) while reading the sources (--strict):\n  %s", len(warnings), strings.Join(warnings, "\n  "))
+}
+
+// streamGrace is how long the picker waits for the search to finish, so that the entries of
+// all but very large sources are listed completely and sorted from the start
+const streamGrace = 200 * time.Millisecond
+
+// pickerList is the list of entries shown by the picker. When the search finishes within the
+// grace period it is the sorted result; otherwise it is the list of the running search, in the
+// order entries are found, which the picker reloads while it grows. Sorting it then would
+// move entries the user has already selected, so only the selection is sorted (see pickedEntries).
+type pickerList struct {
+	entries *[]finder.Entry
+	// lock must be held while reading entries
+	lock sync.Locker
+	// at returns the entry at index i without holding lock
+	at func(i int) finder.Entry
+	// stream is the running search listed in the order entries are found, or nil when the list is sorted
+	stream *finder.Stream
+}
+
+// newPickerList waits up to grace for the search to finish and returns the list to show
+func newPickerList(stream *finder.Stream, grace time.Duration) (pickerList, error) {
+	select {
+	case <-stream.Done():
+	case <-time.After(grace):
+		return pickerList{entries: stream.Entries(), lock: stream.RLocker(), at: stream.Entry, stream: stream}, nil
+	}
+
+	entries, err := stream.Wait()
+	if err != nil {
+		return pickerList{}, err
+	}
+	return pickerList{
+		entries: &entries,
+		lock:    &sync.Mutex{},
+		at:      func(i int) finder.Entry { return entries[i] },
+	}, nil
+}
+
+// pickedEntries returns the entries picked at indices in the requested order, whatever
+// order the list shows them in
+func pickedEntries(list pickerList, indices []int, order finder.Order) []finder.Entry {
+	entries := make([]finder.Entry, len(indices))
+	for i, idx := range indices {
+		entries[i] = list.at(idx)
+	}
+	finder.SortEntries(entries, order)
+	return entries
+}
+
+// resolvedWarnings returns the warnings raised while resolving the sources, such as skipped archive entries
+func resolvedWarnings(sources []*source.Source) []string {
+	var warnings []string
+	for _, src := range sources {
+		for _, warning := range src.Warnings {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", src.Name, warning))
+		}
+	}
+	return warnings
+}
+
+// sortWarnings sorts the warnings after the first n, which were raised while resolving the sources.
+// The others are raised by the search in the order directories happen to be read.
+func sortWarnings(warnings []string, n int) []string {
+	sort.Strings(warnings[n:])
+	return warnings
+}
+
+// reportWarnings prints every warning
+func reportWarnings(w io.Writer, warnings []string) {
+	for _, warning := range warnings {
+		fmt.Fprintf(w, "Warning: %s\n", warning)
+	}
+}
+
+// pickerHeader returns the picker header line, summarizing the warnings so that skipped paths are noticed.
+// The full list is printed to stderr.
+func pickerHeader(warnings []string) string {
+	header := "airule - Rule File Selector"
+	switch len(warnings) {
+	case 0:
+		return header
+	case 1:
+		return fmt.Sprintf("%s  ⚠ 1 warning: %s", header, warnings[0])
+	default:
+		return fmt.Sprintf("%s  ⚠ %d warnings (first: %s)", header, len(warnings), warnings[0])
+	}
+}
+
+// finderSources converts resolved sources into finder sources, keeping the declaration order
+func finderSources(sources []*source.Source) []finder.Source {
+	result := make([]finder.Source, len(sources))
+	for i, src := range sources {
+		result[i] = src.Finder()
+	}
+	return result
+}
+
+// revisionSummary returns one line per git or built-in source describing the commit or library version it was resolved to
+func revisionSummary(sources []*source.Source) []string {
+	var lines []string
+	for _, src := range sources {
+		switch {
+		case src.Commit != "":
+			lines = append(lines, fmt.Sprintf("%s @ %s", src.Spec, src.Commit))
+		case src.Version != "":
+			lines = append(lines, fmt.Sprintf("%s @ %s", src.Spec, src.Version))
+		}
+	}
+	return lines
+}
+
+// entryLabel returns the picker line for an entry, annotated with its source when there are several
+// and followed by the description from its front-matter. A file overrides the same path in other
+// sources, while the content of a directory is merged with them file by file.
+func entryLabel(entry finder.Entry, multipleSources bool) string {
+	label := entry.Path
+	if multipleSources {
+		label = fmt.Sprintf("%s  [%s]", entry.Path, entry.Source.Name)
+		if len(entry.Shadowed) > 0 {
+			names := make([]string, len(entry.Shadowed))
+			for i, src := range entry.Shadowed {
+				names[i] = src.Name
+			}
+			relation := "overrides"
+			if entry.IsDir {
+				relation = "merged with"
+			}
+			label += fmt.Sprintf(" (%s %s)", relation, strings.Join(names, ", "))
+		}
+	}
+	if description := entry.Meta.Description(); description != "" {
+		label += "  — " + description
+	}
+	return label
+}
+
+// preselected reports whether an entry is pre-selected: it matches a --pre-select pattern,
+// or it is a file satisfying every --pre-select-where condition
+func preselected(entry finder.Entry, patterns []string, where []frontmatter.Condition) bool {
+	if matchesAnyPattern(entry.Path, patterns) {
+		return true
+	}
+	return len(where) > 0 && !entry.IsDir && frontmatter.MatchAll(entry.Meta, where)
+}
+
+// expandEntries replaces every selected directory by the files found below it, so that a
+// directory is copied through the same rules as the listed files. Each path is kept once,
+// in the order it is first selected, whether it is selected directly or through a directory.
+func expandEntries(entries []finder.Entry, filesBelow func(dir string) []finder.Entry) []finder.Entry {
+	var files []finder.Entry
+	seen := make(map[string]bool)
+	add := func(entry finder.Entry) {
+		if !seen[entry.Path] {
+			seen[entry.Path] = true
+			files = append(files, entry)
+		}
+	}
+	for _, entry := range entries {
+		if !entry.IsDir {
+			add(entry)
+			continue
+		}
+		for _, file := range filesBelow(entry.Path) {
+			add(file)
+		}
+	}
+	return files
+}
+
+// writeDestructiveChanges lists the destination paths the plan removes, with the paths inside
+// a removed directory indented below it, and the files it overwrites with different content.
+// They can only be restored with 'airule undo' when the plan keeps backups (--keep-backups above 0).
+func writeDestructiveChanges(w io.Writer, plan *copier.Plan) {
+	removeStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("203"))
+	overwriteStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+
+	for _, group := range []struct {
+		title   string
+		style   lipgloss.Style
+		actions []copier.Action
+	}{
+		{title: "Will remove %d path(s) from the destination:", style: removeStyle, actions: plan.ActionsOf(copier.ActionRemove)},
+		{title: "Will overwrite %d file(s) with different content:", style: overwriteStyle, actions: plan.ActionsOf(copier.ActionOverwrite)},
+	} {
+		if len(group.actions) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s\n", group.style.Bold(true).Render(fmt.Sprintf(group.title, len(group.actions))))
+		var dirs []string // The removed directories holding the current path, outermost first
+		for i, action := range group.actions {
+			for len(dirs) > 0 && !strings.HasPrefix(action.Path, dirs[len(dirs)-1]+"/") {
+				dirs = dirs[:len(dirs)-1]
+			}
+			indent := strings.Repeat("  ", len(dirs))
+			name := action.Path
+			if action.Type == copier.TypeDir {
+				name += fmt.Sprintf("/ (%d file(s) inside)", filesInside(group.actions[i+1:], action.Path))
+				dirs = append(dirs, action.Path)
 			}
-			// Also handle case where the pattern *is* the directory path itself
-			if filePath == dirPattern {
-				return true
+			if note := action.Note(); note != "" {
+				name += " (" + note + ")"
 			}
+			fmt.Fprintf(w, "%s%s%s\n", indent, group.style.Render("  - "), name)
 		}
 	}
-	return false
+}
+
+// filesInside returns the number of files and links among the actions following the removal
+// of dir that are inside it. The plan lists them right after the directory.
+func filesInside(actions []copier.Action, dir string) int {
+	n := 0
+	for _, action := range actions {
+		if !strings.HasPrefix(action.Path, dir+"/") {
+			break
+		}
+		if action.Type != copier.TypeDir {
+			n++
+		}
+	}
+	return n
+}
+
+// confirm asks whether to carry out the plan and reads the answer from r.
+// When the plan removes anything, "yes" must be typed out instead of "y".
+func confirm(r io.Reader, w io.Writer, plan *copier.Plan) bool {
+	removals := plan.Count(copier.ActionRemove)
+	prompt := "Proceed with copy? (y/n): "
+	if removals > 0 {
+		prompt = fmt.Sprintf("This deletes %d path(s) from the destination. Type 'yes' to proceed: ", removals)
+	}
+	if plan.KeepsBackups() && (removals > 0 || plan.Count(copier.ActionOverwrite) > 0) {
+		prompt = "Removed and overwritten paths can be restored with 'airule undo'. " + prompt
+	}
+	fmt.Fprint(w, "\n"+prompt)
+
+	answer, _ := bufio.NewReader(r).ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	if removals > 0 {
+		return strings.EqualFold(answer, "yes")
+	}
+	return answer == "y" || answer == "Y"
+}
+
+// askEdit returns a function asking how to resolve each file edited in the destination since
+// it was installed, reading the answers from r. Keeping the file is the default.
+func askEdit(r *bufio.Reader, w io.Writer) func(name string) copier.EditPolicy {
+	return func(name string) copier.EditPolicy {
+		fmt.Fprintf(w, "%s was edited since it was installed. Keep it (k), overwrite it (o), save it as %s%s and overwrite it (s) or merge the edits (m)? [K/o/s/m]: ",
+			name, path.Base(name), copier.OrigSuffix)
+		answer, _ := r.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "o":
+			return copier.EditOverwrite
+		case "s":
+			return copier.EditOrig
+		case "m":
+			return copier.EditMerge
+		default:
+			return copier.EditKeep
+		}
+	}
+}
+
+// askConflict returns a function showing the diff of each existing file that would be replaced
+// with different content and asking what to do with it, reading the answers from r.
+// Skipping the file is the default; a capital letter applies the answer to all remaining files.
+func askConflict(r *bufio.Reader, w io.Writer) func(c copier.Conflict) copier.ConflictPolicy {
+	removedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("203"))
+	addedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	hunkStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("39"))
+
+	var all *copier.ConflictPolicy
+	return func(c copier.Conflict) copier.ConflictPolicy {
+		if all != nil {
+			return *all
+		}
+
+		fmt.Fprintf(w, "\n%s already exists with different content:\n", c.Path)
+		diff, err := c.Diff()
+		if err != nil {
+			fmt.Fprintf(w, "  (%v)\n", err)
+		}
+		for _, line := range strings.SplitAfter(string(diff), "\n") {
+			switch {
+			case strings.HasPrefix(line, "@@"):
+				line = hunkStyle.Render(strings.TrimSuffix(line, "\n")) + "\n"
+			case strings.HasPrefix(line, "-"):
+				line = removedStyle.Render(strings.TrimSuffix(line, "\n")) + "\n"
+			case strings.HasPrefix(line, "+"):
+				line = addedStyle.Render(strings.TrimSuffix(line, "\n")) + "\n"
+			}
+			fmt.Fprint(w, line)
+		}
+		fmt.Fprintf(w, "Overwrite it (o), skip it (s), save it as %s%s and overwrite it (b) or overwrite it if the source is newer (n)? Capitals apply to all remaining files. [o/S/b/n]: ",
+			path.Base(c.Path), copier.BackupSuffix)
+
+		answer, _ := r.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		policy := copier.ConflictSkip
+		switch strings.ToLower(answer) {
+		case "o":
+			policy = copier.ConflictOverwrite
+		case "b":
+			policy = copier.ConflictBackup
+		case "n":
+			policy = copier.ConflictNewer
+		}
+		if answer != "" && answer != strings.ToLower(answer) {
+			all = &policy
+		}
+		return policy
+	}
+}
+
+// writePlanJSON writes the plan as indented JSON
+func writePlanJSON(w io.Writer, plan *copier.Plan) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(plan); err != nil {
+		return fmt.Errorf("failed to write plan: %w", err)
+	}
+	return nil
+}
+
+// undo lists or undoes the runs recorded in the backups of the destination directory
+func undo(w io.Writer, toDir string, cmd cli.UndoCmd) error {
+	if cmd.List {
+		runs, err := copier.Runs(toDir)
+		if err != nil {
+			return err
+		}
+		if len(runs) == 0 {
+			fmt.Fprintf(w, "No runs to undo in %s\n", toDir)
+			return nil
+		}
+		// Latest first, since undoing a run undoes every run above it
+		for i := len(runs) - 1; i >= 0; i-- {
+			fmt.Fprintf(w, "%s  %s\n", runs[i].ID, runSummary(runs[i]))
+			names := make([]string, 0, len(runs[i].Decisions))
+			for name := range runs[i].Decisions {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Fprintf(w, "  %s: %s\n", name, runs[i].Decisions[name])
+			}
+		}
+		return nil
+	}
+
+	undone, err := copier.Undo(toDir, cmd.RunID)
+	for _, run := range undone {
+		fmt.Fprintf(w, "Undid run %s (%s)\n", run.ID, runSummary(run))
+	}
+	if err != nil {
+		return fmt.Errorf("error undoing: %w", err)
+	}
+	return nil
+}
+
+// runSummary describes the changes a run made to the destination
+func runSummary(run *copier.Run) string {
+	return fmt.Sprintf("%s, %d created, %d removed or overwritten",
+		run.Time.Local().Format(time.DateTime), len(run.Created), len(run.Saved))
+}
+
+// copyItems converts the files returned by expandEntries into copier items, each copied
+// from the source it was found in
+func copyItems(files []finder.Entry) []copier.Item {
+	items := make([]copier.Item, len(files))
+	for i, file := range files {
+		items[i] = copier.Item{Root: file.Source.Root, Path: file.Path, FS: file.Source.FS}
+	}
+	return items
 }
 
 // Run executes the application
 func (a *App) Run() error {
-	// Find files based on include/exclude patterns
-	files, err := finder.FindFiles(a.cliArgs.From, a.cliArgs.Include, a.cliArgs.Exclude)
+	if a.cliArgs.ListBuiltin {
+		return listBuiltin(os.Stdout)
+	}
+	if a.cliArgs.Command == cli.CommandUndo {
+		return undo(os.Stdout, a.cliArgs.To, a.cliArgs.Undo)
+	}
+
+	opts, err := a.findOptions()
 	if err != nil {
-		return fmt.Errorf("error finding files: %w", err)
+		return fmt.Errorf("error loading rules: %w", err)
 	}
 
-	if len(files) == 0 {
-		return fmt.Errorf("no files found matching the criteria")
+	// Resolve the sources (cloning or fetching git sources as needed)
+	resolved, err := source.ResolveAll(a.cliArgs.From, source.Options{CacheDir: a.cliArgs.CacheDir})
+	if err != nil {
+		return fmt.Errorf("error resolving sources: %w", err)
+	}
+	warnings := resolvedWarnings(resolved)
+	resolvedCount := len(warnings)
+
+	// Find files based on include/exclude patterns and rules, merging all sources.
+	// The search runs in the background, so the picker opens as soon as something is found
+	// and fills in while the rest of the sources are walked.
+	sources := finderSources(resolved)
+	var warningsMu sync.Mutex
+	opts.OnWarning = func(w finder.Warning) {
+		warningsMu.Lock()
+		defer warningsMu.Unlock()
+		warnings = append(warnings, w.String())
+	}
+	// currentWarnings returns the warnings raised so far, those of the search sorted by path
+	currentWarnings := func() []string {
+		warningsMu.Lock()
+		defer warningsMu.Unlock()
+		return sortWarnings(append([]string(nil), warnings...), resolvedCount)
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := finder.StreamSources(ctx, sources, opts)
 
-	// Create preselected indices based on SelectAll flag and PreSelect patterns
-	var preselectedIndices []int
-	if a.cliArgs.SelectAll {
-		// If SelectAll is true, preselect all files
-		for i := range files {
-			preselectedIndices = append(preselectedIndices, i)
+	// Paths that could not be read fail the run in strict mode, so the search has to finish first
+	if a.cliArgs.Strict {
+		if _, err := stream.Wait(); err != nil {
+			return fmt.Errorf("error finding files: %w", err)
 		}
-	} else if len(a.cliArgs.PreSelect) > 0 {
-		// If PreSelect patterns are provided, preselect matching files
-		for i, file := range files {
-			if matchesAnyPattern(file, a.cliArgs.PreSelect) {
-				preselectedIndices = append(preselectedIndices, i)
-			}
+		if err := checkWarnings(currentWarnings(), true); err != nil {
+			return err
 		}
 	}
+	multipleSources := len(sources) > 1
 
-	// Create a map for quick lookup of preselected indices
+	list, err := newPickerList(stream, streamGrace)
+	if err != nil {
+		return fmt.Errorf("error finding files: %w", err)
+	}
+	<-stream.Ready()
+	if stream.Len() == 0 {
+		// Entries may still be listed between the check and the end of the search
+		entries, err := stream.Wait()
+		if err != nil {
+			return fmt.Errorf("error finding files: %w", err)
+		}
+		if len(entries) == 0 {
+			reportWarnings(os.Stderr, currentWarnings())
+			return fmt.Errorf("no files found matching the criteria")
+		}
+	}
+
+	// Decide which entries are preselected based on the SelectAll flag and PreSelect patterns
+	where, err := frontmatter.ParseConditions(a.cliArgs.PreSelectWhere)
+	if err != nil {
+		return fmt.Errorf("error parsing --pre-select-where: %w", err)
+	}
+	selectAll := a.cliArgs.SelectAll
+	preselect := len(a.cliArgs.PreSelect) > 0 || len(where) > 0
+
+	// Highlight the lines that matched --contains so it is clear why the file was listed
+	previewOpts := preview.Options{Highlights: opts.Content.Highlights(), Symlinks: opts.Symlinks}
+
+	// The picker asks whether an entry is preselected right after labelling it, possibly
+	// while the list is locked for reading, so the answer is recorded when labelling
+	var preselectedMu sync.Mutex
 	preselectedMap := make(map[int]bool)
-	for _, idx := range preselectedIndices {
-		preselectedMap[idx] = true
+
+	header := pickerHeader(currentWarnings())
+	if list.stream != nil {
+		header += "  (searching, entries are listed as they are found)"
 	}
 
-	// Use go-fuzzyfinder to select files
+	// Use go-fuzzyfinder to select files. The list is reloaded while it grows.
+	entries := list.entries
 	indices, err := fuzzyfinder.FindMulti(
-		files,
+		entries,
 		func(i int) string {
-			return files[i]
+			// Called by the picker while holding the list's lock
+			entry := (*entries)[i]
+			if selectAll || preselect {
+				preselectedMu.Lock()
+				preselectedMap[i] = selectAll || preselected(entry, a.cliArgs.PreSelect, where)
+				preselectedMu.Unlock()
+			}
+			return entryLabel(entry, multipleSources)
 		},
+		fuzzyfinder.WithHotReloadLock(list.lock),
 		fuzzyfinder.WithPreviewWindow(func(i, width, height int) string {
 			if i == -1 {
 				return "Select a file to preview its contents"
 			}
 			// Use the preview package to generate preview content
-			previewContent, err := preview.GeneratePreview(a.cliArgs.From, files[i], width, height)
+			entry := list.at(i)
+			header := ""
+			if multipleSources {
+				header = fmt.Sprintf("Source: %s\n\n", entry.Source.Name)
+				height -= 2
+			}
+			previewContent, err := preview.GeneratePreviewWithOptions(entry.Source.Files(), entry.Path, width, height, previewOpts)
 			if err != nil {
-				return fmt.Sprintf("Error loading preview: %v", err)
+				return fmt.Sprintf("%sError loading preview: %v", header, err)
 			}
-			return previewContent
+			return header + previewContent
 		}),
 		fuzzyfinder.WithPromptString("Select files to copy (Tab to select, Enter to confirm): "),
-		fuzzyfinder.WithHeader("airule - Rule File Selector"),
+		fuzzyfinder.WithHeader(header),
 		fuzzyfinder.WithCursorPosition(fuzzyfinder.CursorPositionTop),
 		fuzzyfinder.WithPreselected(func(i int) bool {
+			preselectedMu.Lock()
+			defer preselectedMu.Unlock()
 			return preselectedMap[i]
 		}),
 	)
@@ -123,16 +649,72 @@ func (a *App) Run() error {
 		return fmt.Errorf("error selecting files: %w", err)
 	}
 
+	// Let the search finish before copying, so that no later source overrides a selected path
+	if _, err := stream.Wait(); err != nil {
+		return fmt.Errorf("error finding files: %w", err)
+	}
+	reportWarnings(os.Stderr, currentWarnings())
+
 	// No files selected
 	if len(indices) == 0 {
 		fmt.Println("No files selected")
 		return nil
 	}
 
-	// Get the selected files
-	selectedFiles := make([]string, len(indices))
-	for i, idx := range indices {
-		selectedFiles[i] = files[idx]
+	// Get the selected files, in the same order whatever order they were found in
+	selectedEntries := pickedEntries(list, indices, opts.Order)
+
+	// Work out every change to the destination before making any.
+	// Selected directories are copied file by file, so that excluded files stay behind
+	// and a file selected on its own as well is copied once.
+	files := expandEntries(selectedEntries, stream.FilesBelow)
+	cleanStrategy, err := copier.ParseCleanStrategy(a.cliArgs.CleanStrategy)
+	if err != nil {
+		return err
+	}
+	copyOpts := copier.Options{
+		Clean:         a.cliArgs.Clean,
+		CleanExclude:  a.cliArgs.CleanExclude,
+		CleanStrategy: cleanStrategy,
+		Symlinks:      opts.Symlinks,
+		KeepBackups:   a.cliArgs.KeepBackups,
+		Checksum:      a.cliArgs.Checksum,
+		Manifest:      a.cliArgs.Manifest,
+	}
+	if copyOpts.Mode, err = copier.ParseInstallMode(a.cliArgs.Mode); err != nil {
+		return err
+	}
+
+	// Answers are read from a single reader, so that none is lost to buffering
+	in := bufio.NewReader(os.Stdin)
+	switch {
+	case a.cliArgs.LocalEdits != "prompt":
+		if copyOpts.LocalEdits, err = copier.ParseEditPolicy(a.cliArgs.LocalEdits); err != nil {
+			return err
+		}
+	case a.cliArgs.DryRun:
+		copyOpts.LocalEdits = copier.EditKeep // Nothing is asked without a copy
+	default:
+		copyOpts.ResolveEdit = askEdit(in, os.Stdout)
+	}
+	switch {
+	case a.cliArgs.OnConflict != "prompt":
+		if copyOpts.OnConflict, err = copier.ParseConflictPolicy(a.cliArgs.OnConflict); err != nil {
+			return err
+		}
+	case a.cliArgs.DryRun:
+		copyOpts.OnConflict = copier.ConflictSkip
+	default:
+		copyOpts.ResolveConflict = askConflict(in, os.Stdout)
+	}
+	plan, err := copier.PlanItems(copyItems(files), a.cliArgs.To, copyOpts)
+	if err != nil {
+		return fmt.Errorf("error planning copy: %w", err)
+	}
+
+	// A JSON plan is the only output, so that it can be processed by other tools
+	if a.cliArgs.DryRun && a.cliArgs.Format == "json" {
+		return writePlanJSON(os.Stdout, plan)
 	}
 
 	// Define styles for output
@@ -145,12 +727,13 @@ func (a *App) Run() error {
 		Foreground(lipgloss.Color("63"))
 
 	// Display selected files with styling
-	title := titleStyle.Render(fmt.Sprintf("Selected %d file(s):", len(selectedFiles)))
+	title := titleStyle.Render(fmt.Sprintf("Selected %d file(s):", len(selectedEntries)))
 	fmt.Println(title)
 
-	for _, file := range selectedFiles {
+	// Sorted entries are in picker order, which shows the last one at the top
+	for i := len(selectedEntries) - 1; i >= 0; i-- {
 		bullet := bulletStyle.Render("  • ")
-		fmt.Printf("%s%s\n", bullet, file)
+		fmt.Printf("%s%s\n", bullet, entryLabel(selectedEntries[i], multipleSources))
 	}
 
 	// Define path style
@@ -158,15 +741,22 @@ func (a *App) Run() error {
 		Foreground(lipgloss.Color("39")).
 		Italic(true)
 
+	if a.cliArgs.DryRun {
+		fmt.Printf("\nPlan for %s:\n", pathStyle.Render(a.cliArgs.To))
+		if err := plan.WriteText(os.Stdout); err != nil {
+			return err
+		}
+		fmt.Println("Dry run: nothing was changed")
+		return nil
+	}
+
 	// Confirm copy operation with styling
 	fmt.Printf("\nCopying from %s to %s\n",
-		pathStyle.Render(a.cliArgs.From),
+		pathStyle.Render(strings.Join(a.cliArgs.From, ", ")),
 		pathStyle.Render(a.cliArgs.To))
+	writeDestructiveChanges(os.Stdout, plan)
 
-	fmt.Print("Proceed with copy? (y/n): ")
-	var response string
-	fmt.Scanln(&response)
-	if response != "y" && response != "Y" {
+	if !confirm(in, os.Stdout, plan) {
 		cancelStyle := lipgloss.NewStyle().
 			Foreground(lipgloss.Color("203")).
 			Bold(true)
@@ -179,7 +769,8 @@ func (a *App) Run() error {
 		Foreground(lipgloss.Color("105"))
 	fmt.Println(copyingStyle.Render("Copying files..."))
 
-	if err := copier.CopyFiles(a.cliArgs.From, a.cliArgs.To, selectedFiles, a.cliArgs.Clean, a.cliArgs.CleanExclude); err != nil {
+	run, err := plan.Execute(a.cliArgs.To)
+	if err != nil {
 		return fmt.Errorf("error copying files: %w", err)
 	}
 
@@ -190,15 +781,32 @@ func (a *App) Run() error {
 
 	checkmark := successStyle.Render("✓")
 
+	// Files already as copied were left alone
+	message := fmt.Sprintf("%s Successfully copied to %s: %d copied, %d unchanged",
+		checkmark,
+		pathStyle.Render(a.cliArgs.To),
+		plan.CountFiles(copier.ActionCreate)+plan.CountFiles(copier.ActionOverwrite),
+		plan.CountFiles(copier.ActionUnchanged))
+	// Record which commit each git source was resolved to
+	for _, line := range revisionSummary(resolved) {
+		message += "\n  from " + line
+	}
+	// Record how edited and conflicting files were handled
+	for _, action := range plan.Actions {
+		if note := action.Note(); note != "" {
+			message += fmt.Sprintf("\n  %s: %s", action.Path, note)
+		}
+	}
+	if run != nil {
+		message += fmt.Sprintf("\n  run %s, undo with 'airule undo --to %s'", run.ID, a.cliArgs.To)
+	}
+
 	// Create a styled box for the success message
 	messageBox := lipgloss.NewStyle().
 		BorderStyle(lipgloss.RoundedBorder()).
 		BorderForeground(lipgloss.Color("63")).
 		Padding(0, 1).
-		Render(fmt.Sprintf("%s Successfully copied %d file(s) to %s",
-			checkmark,
-			len(selectedFiles),
-			pathStyle.Render(a.cliArgs.To)))
+		Render(message)
 
 	fmt.Println("\n" + messageBox)
 	return nil
@@ -1,9 +1,25 @@
 package app
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/upamune/airule/internal/builtin"
 	"github.com/upamune/airule/internal/cli"
+	"github.com/upamune/airule/internal/copier"
+	"github.com/upamune/airule/internal/finder"
+	"github.com/upamune/airule/internal/frontmatter"
+	"github.com/upamune/airule/internal/pattern"
+	"github.com/upamune/airule/internal/source"
 )
 
 // TestMatchesAnyPattern tests the matchesAnyPattern function with various patterns
@@ -68,6 +84,18 @@ func TestMatchesAnyPattern(t *testing.T) {
 			patterns: []string{"*.go", "*.txt"},
 			want:     true,
 		},
+		{
+			name:     "Match recursive doublestar pattern",
+			filePath: "rules/lang/go/go-style.mdc",
+			patterns: []string{"rules/**/go-*.mdc"},
+			want:     true,
+		},
+		{
+			name:     "Match brace expansion",
+			filePath: "rules/style.mdc",
+			patterns: []string{"*.{md,mdc}"},
+			want:     true,
+		},
 		{
 			name:     "Multiple patterns - match none",
 			filePath: "file.txt",
@@ -219,3 +247,562 @@ func TestPreselectionLogic(t *testing.T) {
 		})
 	}
 }
+
+// TestFindOptionsRules tests that rules from --rules-file are evaluated before --rule entries
+func TestFindOptionsRules(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "airule-rules")
+	if err := os.WriteFile(rulesFile, []byte("drafts/\n!drafts/approved.md\n"), 0644); err != nil {
+		t.Fatalf("Failed to write rules file: %v", err)
+	}
+
+	app := setupTestApp(t, cli.CLI{
+		Include:   []string{"*.md"},
+		Exclude:   []string{"*.tmp"},
+		Rules:     []string{"drafts/approved.md"},
+		RulesFile: rulesFile,
+	})
+
+	opts, err := app.findOptions()
+	if err != nil {
+		t.Fatalf("findOptions() error = %v", err)
+	}
+
+	want := pattern.Rules{
+		{Pattern: "drafts", DirOnly: true},
+		{Pattern: "drafts/approved.md", Negate: true},
+		{Pattern: "drafts/approved.md"},
+	}
+	if !reflect.DeepEqual(opts.Rules, want) {
+		t.Errorf("findOptions() rules = %+v, want %+v", opts.Rules, want)
+	}
+	if !reflect.DeepEqual(opts.Includes, []string{"*.md"}) || !reflect.DeepEqual(opts.Excludes, []string{"*.tmp"}) {
+		t.Errorf("findOptions() includes/excludes = %v/%v", opts.Includes, opts.Excludes)
+	}
+
+	// A missing rules file is reported
+	app = setupTestApp(t, cli.CLI{RulesFile: filepath.Join(t.TempDir(), "missing")})
+	if _, err := app.findOptions(); err == nil {
+		t.Error("findOptions() should fail for a missing rules file")
+	}
+}
+
+// TestExpandEntries tests that selected directories are replaced by the files below them, once each
+func TestExpandEntries(t *testing.T) {
+	org := finder.Source{Name: "org", Root: "/rules/org"}
+	team := finder.Source{Name: "team", Root: "/rules/team"}
+
+	below := map[string][]finder.Entry{
+		"go": {
+			{Path: "go/draft/wip.md", Source: team},
+			{Path: "go/style.md", Source: team, Shadowed: []finder.Source{org}},
+		},
+		"go/draft": {
+			{Path: "go/draft/wip.md", Source: team},
+		},
+	}
+	filesBelow := func(dir string) []finder.Entry { return below[dir] }
+
+	selected := []finder.Entry{
+		{Path: "go/style.md", Source: team, Shadowed: []finder.Source{org}},
+		{Path: "go", IsDir: true, Source: team, Shadowed: []finder.Source{org}},
+		{Path: "go/draft", IsDir: true, Source: team},
+		{Path: "common.md", Source: org},
+	}
+
+	var got []string
+	for _, entry := range expandEntries(selected, filesBelow) {
+		got = append(got, entry.Source.Name+":"+entry.Path)
+	}
+	want := []string{"team:go/style.md", "team:go/draft/wip.md", "org:common.md"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expandEntries() = %v, want %v", got, want)
+	}
+
+	// A directory without files below it copies nothing
+	if got := expandEntries([]finder.Entry{{Path: "empty", IsDir: true}}, filesBelow); len(got) != 0 {
+		t.Errorf("expandEntries() for an empty directory = %v, want none", got)
+	}
+}
+
+// TestCopyItemsForEntries tests how selected files from several sources are turned into copier items
+func TestCopyItemsForEntries(t *testing.T) {
+	org := finder.Source{Name: "org", Root: "/rules/org"}
+	team := finder.Source{Name: "team", Root: "/rules/team"}
+
+	entries := []finder.Entry{
+		{Path: "go/style.md", Source: team, Shadowed: []finder.Source{org}},
+		{Path: "common.md", Source: org},
+	}
+
+	got := copyItems(entries)
+	want := []copier.Item{
+		{Root: "/rules/team", Path: "go/style.md"},
+		{Root: "/rules/org", Path: "common.md"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("copyItems() = %v, want %v", got, want)
+	}
+
+	if label := entryLabel(entries[0], true); label != "go/style.md  [team] (overrides org)" {
+		t.Errorf("entryLabel() = %q", label)
+	}
+	if label := entryLabel(entries[0], false); label != "go/style.md" {
+		t.Errorf("entryLabel() with a single source = %q", label)
+	}
+	dir := finder.Entry{Path: "go", IsDir: true, Source: team, Shadowed: []finder.Source{org}}
+	if label := entryLabel(dir, true); label != "go  [team] (merged with org)" {
+		t.Errorf("entryLabel() for a directory = %q", label)
+	}
+}
+
+// TestEntryLabelDescription tests that the front-matter description follows the path
+func TestEntryLabelDescription(t *testing.T) {
+	org := finder.Source{Name: "org", Root: "/rules/org"}
+	team := finder.Source{Name: "team", Root: "/rules/team"}
+	entry := finder.Entry{
+		Path:     "go/style.md",
+		Source:   team,
+		Shadowed: []finder.Source{org},
+		Meta:     frontmatter.Metadata{"description": "Go style guide"},
+	}
+
+	if label := entryLabel(entry, false); label != "go/style.md  — Go style guide" {
+		t.Errorf("entryLabel() = %q", label)
+	}
+	if label := entryLabel(entry, true); label != "go/style.md  [team] (overrides org)  — Go style guide" {
+		t.Errorf("entryLabel() with several sources = %q", label)
+	}
+}
+
+// TestPreselected tests pre-selection by pattern and by front-matter conditions
+func TestPreselected(t *testing.T) {
+	where, err := frontmatter.ParseConditions([]string{"alwaysApply=true"})
+	if err != nil {
+		t.Fatalf("ParseConditions() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		entry    finder.Entry
+		patterns []string
+		where    []frontmatter.Condition
+		want     bool
+	}{
+		{name: "Pattern match", entry: finder.Entry{Path: "go/style.md"}, patterns: []string{"go/*"}, want: true},
+		{name: "Condition match", entry: finder.Entry{Path: "base.md", Meta: frontmatter.Metadata{"alwaysApply": true}}, where: where, want: true},
+		{name: "Condition mismatch", entry: finder.Entry{Path: "go.md", Meta: frontmatter.Metadata{"alwaysApply": false}}, where: where, want: false},
+		{name: "No front-matter", entry: finder.Entry{Path: "notes.md"}, where: where, want: false},
+		{name: "Directories are not matched by conditions", entry: finder.Entry{Path: "go", IsDir: true}, where: []frontmatter.Condition{{Key: "x", Op: frontmatter.OpNotEqual, Value: "y"}}, want: false},
+		{name: "Nothing requested", entry: finder.Entry{Path: "go.md", Meta: frontmatter.Metadata{"alwaysApply": true}}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := preselected(tt.entry, tt.patterns, tt.where); got != tt.want {
+				t.Errorf("preselected() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRevisionSummary tests that only git and built-in sources are listed with their resolved revision
+func TestRevisionSummary(t *testing.T) {
+	sources := []*source.Source{
+		{Spec: "./local", Name: "./local", Root: "/work/local"},
+		{Spec: "git+file:///srv/rules.git#v1.2.0", Name: "git+file:///srv/rules.git#v1.2.0", Root: "/cache/tree", Commit: "0123456789abcdef"},
+		{Spec: "builtin:go", Name: "builtin:go", Root: "builtin:go", Version: "2026.10.0"},
+	}
+
+	got := revisionSummary(sources)
+	want := []string{"git+file:///srv/rules.git#v1.2.0 @ 0123456789abcdef", "builtin:go @ 2026.10.0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("revisionSummary() = %v, want %v", got, want)
+	}
+}
+
+// TestListBuiltin tests that the built-in sets are listed with the library version
+func TestListBuiltin(t *testing.T) {
+	var buf strings.Builder
+	if err := listBuiltin(&buf); err != nil {
+		t.Fatalf("listBuiltin() error = %v", err)
+	}
+
+	got := buf.String()
+	for _, want := range []string{"Built-in rules " + builtin.Version(), "builtin:go (", "  style.md", "builtin:typescript ("} {
+		if !strings.Contains(got, want) {
+			t.Errorf("listBuiltin() = %q, want it to contain %q", got, want)
+		}
+	}
+}
+
+// TestCheckWarnings tests that warnings only fail the run in strict mode
+func TestCheckWarnings(t *testing.T) {
+	warnings := []string{"rules: secret: unreadable: permission denied", "rules: link.md: broken symlink: no such file"}
+
+	if err := checkWarnings(warnings, false); err != nil {
+		t.Errorf("checkWarnings() without strict error = %v", err)
+	}
+	if err := checkWarnings(nil, true); err != nil {
+		t.Errorf("checkWarnings() without warnings error = %v", err)
+	}
+	err := checkWarnings(warnings, true)
+	if err == nil {
+		t.Fatal("checkWarnings() in strict mode should fail")
+	}
+	for _, warning := range warnings {
+		if !strings.Contains(err.Error(), warning) {
+			t.Errorf("checkWarnings() error %q should contain %q", err, warning)
+		}
+	}
+}
+
+// TestPickerHeader tests the warning summary in the picker header
+func TestPickerHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		warnings []string
+		want     string
+	}{
+		{name: "No warnings", want: "airule - Rule File Selector"},
+		{name: "One warning", warnings: []string{"rules: a: broken symlink"}, want: "airule - Rule File Selector  ⚠ 1 warning: rules: a: broken symlink"},
+		{name: "Several warnings", warnings: []string{"rules: a: unreadable", "rules: b: unreadable"}, want: "airule - Rule File Selector  ⚠ 2 warnings (first: rules: a: unreadable)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pickerHeader(tt.warnings); got != tt.want {
+				t.Errorf("pickerHeader() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSortWarnings tests that warnings of the search are sorted after those of the sources
+func TestSortWarnings(t *testing.T) {
+	warnings := []string{"rules.tar: skipped ../evil", "rules: b.md: unreadable", "rules: a.md: unreadable"}
+	want := []string{"rules.tar: skipped ../evil", "rules: a.md: unreadable", "rules: b.md: unreadable"}
+	if got := sortWarnings(warnings, 1); !reflect.DeepEqual(got, want) {
+		t.Errorf("sortWarnings() = %v, want %v", got, want)
+	}
+
+	var buf bytes.Buffer
+	reportWarnings(&buf, want[:1])
+	if got := buf.String(); got != "Warning: rules.tar: skipped ../evil\n" {
+		t.Errorf("reportWarnings() = %q", got)
+	}
+}
+
+// TestNewPickerList tests that a finished search is listed in the requested order
+func TestNewPickerList(t *testing.T) {
+	src := finder.Source{Name: "rules", FS: fstest.MapFS{
+		"a.md":    {Data: []byte("a")},
+		"go/b.md": {Data: []byte("bb")},
+	}}
+	stream := finder.StreamSources(context.Background(), []finder.Source{src}, finder.Options{
+		Order: finder.Order{DirsFirst: true},
+	})
+	<-stream.Done()
+
+	list, err := newPickerList(stream, time.Second)
+	if err != nil {
+		t.Fatalf("newPickerList() error = %v", err)
+	}
+
+	list.lock.Lock()
+	var got []string
+	for _, entry := range *list.entries {
+		got = append(got, entry.Path)
+	}
+	list.lock.Unlock()
+
+	// The picker shows the last entry at the top, so the directory comes last
+	want := []string{filepath.Join("go", "b.md"), "a.md", "go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("picker list = %v, want %v", got, want)
+	}
+	if got := list.at(2).Path; got != "go" {
+		t.Errorf("at(2) = %s, want go", got)
+	}
+}
+
+// TestNewPickerListSlowSearch tests that a search outliving the grace period is listed while it
+// runs and that the entries picked from it end up in the requested order
+func TestNewPickerListSlowSearch(t *testing.T) {
+	tree := fstest.MapFS{}
+	for _, dir := range []string{"a", "b", "c", "d"} {
+		for i, name := range []string{"x.md", "yy.md", "zzz.md"} {
+			tree[dir+"/"+name] = &fstest.MapFile{Data: []byte(strings.Repeat(dir, i+1))}
+		}
+	}
+	opts := finder.Options{Order: finder.Order{Key: finder.SortName, DirsFirst: true}, Workers: 4}
+
+	want, err := finder.FindSources([]finder.Source{{Name: "rules", FS: tree}}, opts)
+	if err != nil {
+		t.Fatalf("FindSources() error = %v", err)
+	}
+
+	for run := 0; run < 5; run++ {
+		release := make(chan struct{})
+		src := finder.Source{Name: "rules", FS: slowFS{FS: tree, release: release}}
+		stream := finder.StreamSources(context.Background(), []finder.Source{src}, opts)
+
+		list, err := newPickerList(stream, 10*time.Millisecond)
+		if err != nil {
+			t.Fatalf("newPickerList() error = %v", err)
+		}
+		if list.stream == nil {
+			t.Fatal("newPickerList() should list the running search after the grace period")
+		}
+		close(release)
+		if _, err := stream.Wait(); err != nil {
+			t.Fatalf("Wait() error = %v", err)
+		}
+
+		// The picker reports the indices of the list, which stays in the order entries were found
+		list.lock.Lock()
+		indices := make([]int, len(*list.entries))
+		for i := range indices {
+			indices[i] = len(indices) - 1 - i
+		}
+		list.lock.Unlock()
+
+		if got := entryPaths(pickedEntries(list, indices, opts.Order)); !reflect.DeepEqual(got, entryPaths(want)) {
+			t.Fatalf("run %d: picked entries = %v, want %v", run, got, entryPaths(want))
+		}
+	}
+}
+
+// slowFS holds back reading every directory but the root until release is closed
+type slowFS struct {
+	fs.FS
+	release <-chan struct{}
+}
+
+func (s slowFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name != "." {
+		<-s.release
+	}
+	return fs.ReadDir(s.FS, name)
+}
+
+// entryPaths returns the paths of the entries
+func entryPaths(entries []finder.Entry) []string {
+	paths := make([]string, len(entries))
+	for i, entry := range entries {
+		paths[i] = entry.Path
+	}
+	return paths
+}
+
+// TestWritePlanJSON tests the JSON output of --dry-run
+func TestWritePlanJSON(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "style.md"), []byte("style"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dst := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dst, "old.md"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := copier.PlanItems([]copier.Item{{Root: src, Path: "style.md"}}, dst, copier.Options{Clean: true})
+	if err != nil {
+		t.Fatalf("PlanItems() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := writePlanJSON(&buf, plan); err != nil {
+		t.Fatalf("writePlanJSON() error = %v", err)
+	}
+	for _, want := range []string{
+		`"destination": "` + dst + `"`,
+		`"action": "remove",`,
+		`"path": "old.md",`,
+		`"action": "create",`,
+		`"source": "` + filepath.Join(src, "style.md") + `"`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("writePlanJSON() = %s, want it to contain %s", buf.String(), want)
+		}
+	}
+
+	// A dry run leaves the destination alone
+	if _, err := os.Stat(filepath.Join(dst, "old.md")); err != nil {
+		t.Errorf("old.md was touched by planning: %v", err)
+	}
+}
+
+// TestConfirm tests that deleting destination paths needs a stronger confirmation
+func TestConfirm(t *testing.T) {
+	copyOnly := &copier.Plan{Actions: []copier.Action{
+		{Kind: copier.ActionCreate, Path: "go/style.md", Type: copier.TypeFile},
+		{Kind: copier.ActionOverwrite, Path: "common.md", Type: copier.TypeFile},
+	}}
+	deleting := &copier.Plan{Clean: true, Actions: []copier.Action{
+		{Kind: copier.ActionRemove, Path: "notes", Type: copier.TypeDir},
+		{Kind: copier.ActionRemove, Path: "notes/todo.md", Type: copier.TypeFile},
+		{Kind: copier.ActionRemove, Path: "notes/old", Type: copier.TypeDir},
+		{Kind: copier.ActionRemove, Path: "notes/old/ideas.md", Type: copier.TypeFile},
+		{Kind: copier.ActionRemove, Path: "notes/plan.md", Type: copier.TypeFile},
+		{Kind: copier.ActionRemove, Path: "old.md", Type: copier.TypeFile},
+		{Kind: copier.ActionOverwrite, Path: "common.md", Type: copier.TypeFile},
+	}}
+
+	tests := []struct {
+		name   string
+		plan   *copier.Plan
+		answer string
+		want   bool
+	}{
+		{name: "y without deletions", plan: copyOnly, answer: "y\n", want: true},
+		{name: "Y without deletions", plan: copyOnly, answer: "Y\n", want: true},
+		{name: "n without deletions", plan: copyOnly, answer: "n\n", want: false},
+		{name: "No answer", plan: copyOnly, answer: "", want: false},
+		{name: "y with deletions", plan: deleting, answer: "y\n", want: false},
+		{name: "yes with deletions", plan: deleting, answer: "yes\n", want: true},
+		{name: "YES with deletions", plan: deleting, answer: " YES \n", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if got := confirm(strings.NewReader(tt.answer), &out, tt.plan); got != tt.want {
+				t.Errorf("confirm(%q) = %v, want %v", tt.answer, got, tt.want)
+			}
+			if tt.plan == deleting && !strings.Contains(out.String(), "This deletes 6 path(s)") {
+				t.Errorf("confirm() prompt = %q", out.String())
+			}
+		})
+	}
+
+	// Overwriting with backups kept mentions undo
+	src, dst := t.TempDir(), t.TempDir()
+	for dir, content := range map[string]string{src: "new content", dst: "old"} {
+		if err := os.WriteFile(filepath.Join(dir, "common.md"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, keep := range []int{0, 1} {
+		plan, err := copier.PlanItems([]copier.Item{{Root: src, Path: "common.md"}}, dst, copier.Options{KeepBackups: keep})
+		if err != nil {
+			t.Fatalf("PlanItems() error = %v", err)
+		}
+		var out bytes.Buffer
+		confirm(strings.NewReader("n\n"), &out, plan)
+		if got := strings.Contains(out.String(), "airule undo"); got != (keep > 0) {
+			t.Errorf("confirm() prompt with --keep-backups %d = %q", keep, out.String())
+		}
+	}
+
+	var out bytes.Buffer
+	writeDestructiveChanges(&out, deleting)
+	want := `
+Will remove 6 path(s) from the destination:
+  - notes/ (3 file(s) inside)
+    - notes/todo.md
+    - notes/old/ (1 file(s) inside)
+      - notes/old/ideas.md
+    - notes/plan.md
+  - old.md
+
+Will overwrite 1 file(s) with different content:
+  - common.md
+`
+	if out.String() != want {
+		t.Errorf("writeDestructiveChanges() =\n%s\nwant\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	writeDestructiveChanges(&out, &copier.Plan{Actions: copyOnly.Actions[:1]})
+	if out.Len() != 0 {
+		t.Errorf("writeDestructiveChanges() without destructive changes = %q", out.String())
+	}
+}
+
+// TestUndo tests listing and undoing runs
+func TestUndo(t *testing.T) {
+	dstDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dstDir, "style.md"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := undo(&out, dstDir, cli.UndoCmd{List: true}); err != nil {
+		t.Fatalf("undo(--list) error = %v", err)
+	}
+	if !strings.Contains(out.String(), "No runs to undo") {
+		t.Errorf("undo(--list) = %q", out.String())
+	}
+
+	src := fstest.MapFS{"style.md": {Data: []byte("new"), Mode: 0644}}
+	items := []copier.Item{{Root: "/rules", Path: "style.md", FS: src}}
+	plan, err := copier.PlanItems(items, dstDir, copier.Options{KeepBackups: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, err := plan.Execute(dstDir)
+	if err != nil || run == nil {
+		t.Fatalf("Execute() = %v, %v", run, err)
+	}
+
+	out.Reset()
+	if err := undo(&out, dstDir, cli.UndoCmd{List: true}); err != nil {
+		t.Fatalf("undo(--list) error = %v", err)
+	}
+	if !strings.HasPrefix(out.String(), run.ID+"  ") || !strings.Contains(out.String(), "0 created, 1 removed or overwritten") {
+		t.Errorf("undo(--list) = %q", out.String())
+	}
+
+	out.Reset()
+	if err := undo(&out, dstDir, cli.UndoCmd{}); err != nil {
+		t.Fatalf("undo() error = %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "Undid run "+run.ID) {
+		t.Errorf("undo() = %q", out.String())
+	}
+	if data, err := os.ReadFile(filepath.Join(dstDir, "style.md")); err != nil || string(data) != "old" {
+		t.Errorf("style.md after undo = %q, %v, want %q", data, err, "old")
+	}
+}
+
+// TestAskEdit tests reading how to resolve locally edited files
+func TestAskEdit(t *testing.T) {
+	var out bytes.Buffer
+	ask := askEdit(bufio.NewReader(strings.NewReader("o\nS\nm\n\nx\n")), &out)
+
+	var got []copier.EditPolicy
+	for i := 0; i < 6; i++ {
+		got = append(got, ask("go/style.md"))
+	}
+	want := []copier.EditPolicy{copier.EditOverwrite, copier.EditOrig, copier.EditMerge, copier.EditKeep, copier.EditKeep, copier.EditKeep}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("askEdit() = %v, want %v", got, want)
+	}
+	if !strings.Contains(out.String(), "go/style.md was edited since it was installed") || !strings.Contains(out.String(), "style.md.orig") {
+		t.Errorf("askEdit() prompt = %q", out.String())
+	}
+}
+
+// TestAskConflict tests reading how to resolve existing files replaced with different content
+func TestAskConflict(t *testing.T) {
+	var out bytes.Buffer
+	ask := askConflict(bufio.NewReader(strings.NewReader("o\nb\n\nn\nB\nx\n")), &out)
+
+	var got []copier.ConflictPolicy
+	for i := 0; i < 7; i++ {
+		got = append(got, ask(copier.Conflict{Path: "go/style.md"}))
+	}
+	want := []copier.ConflictPolicy{
+		copier.ConflictOverwrite, copier.ConflictBackup, copier.ConflictSkip, copier.ConflictNewer,
+		copier.ConflictBackup, copier.ConflictBackup, copier.ConflictBackup,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("askConflict() = %v, want %v", got, want)
+	}
+	if n := strings.Count(out.String(), "go/style.md already exists"); n != 5 {
+		t.Errorf("askConflict() asked %d times, want 5", n)
+	}
+	if !strings.Contains(out.String(), "style.md.bak") {
+		t.Errorf("askConflict() prompt = %q", out.String())
+	}
+}
@@ -70,7 +70,7 @@ func TestSelectAllFlag(t *testing.T) {
 
 	// Create CLI args with SelectAll=true
 	cliArgs := cli.CLI{
-		From:      tempDir,
+		From:      []string{tempDir},
 		To:        filepath.Join(tempDir, "dest"),
 		SelectAll: true,
 	}
@@ -167,7 +167,7 @@ func TestPreSelectPatterns(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// Create CLI args with PreSelect patterns
 			cliArgs := cli.CLI{
-				From:      tempDir,
+				From:      []string{tempDir},
 				To:        filepath.Join(tempDir, "dest"),
 				PreSelect: tt.preSelect,
 			}
@@ -288,7 +288,7 @@ func TestCombinedFunctionality(t *testing.T) {
 
 			// Create CLI args
 			cliArgs := cli.CLI{
-				From:      tempDir,
+				From:      []string{tempDir},
 				To:        filepath.Join(tempDir, "dest"),
 				Include:   tt.includes,
 				Exclude:   tt.excludes,
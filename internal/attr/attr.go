@@ -0,0 +1,192 @@
+package attr
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/upamune/airule/internal/content"
+)
+
+// Type is the kind of content a file must have
+type Type int
+
+const (
+	// TypeAny accepts every file
+	TypeAny Type = iota
+	// TypeText accepts files without NUL bytes at the start
+	TypeText
+	// TypeBinary accepts files with NUL bytes at the start
+	TypeBinary
+)
+
+// ParseType parses "any", "text" or "binary"
+func ParseType(s string) (Type, error) {
+	switch s {
+	case "", "any":
+		return TypeAny, nil
+	case "text":
+		return TypeText, nil
+	case "binary":
+		return TypeBinary, nil
+	}
+	return TypeAny, fmt.Errorf("invalid type %q: expected text or binary", s)
+}
+
+// String returns the name of the type
+func (t Type) String() string {
+	switch t {
+	case TypeText:
+		return "text"
+	case TypeBinary:
+		return "binary"
+	default:
+		return "any"
+	}
+}
+
+// Filter holds the size, age and type conditions a file must satisfy.
+// The zero value accepts every file.
+type Filter struct {
+	// MinSize and MaxSize bound the size in bytes. Zero means no bound.
+	MinSize int64
+	MaxSize int64
+	// NewerThan and OlderThan bound the time since the last modification. Zero means no bound.
+	NewerThan time.Duration
+	OlderThan time.Duration
+	Type      Type
+	// Now is the time ages are measured from. When zero, the current time is used.
+	Now time.Time
+}
+
+// Active reports whether there is anything to check
+func (f Filter) Active() bool {
+	return f.MinSize > 0 || f.MaxSize > 0 || f.NewerThan > 0 || f.OlderThan > 0 || f.Type != TypeAny
+}
+
+// MatchInfo reports whether a file with the given info satisfies the size and age conditions
+func (f Filter) MatchInfo(info fs.FileInfo) bool {
+	size := info.Size()
+	if f.MinSize > 0 && size < f.MinSize {
+		return false
+	}
+	if f.MaxSize > 0 && size > f.MaxSize {
+		return false
+	}
+
+	if f.NewerThan > 0 || f.OlderThan > 0 {
+		now := f.Now
+		if now.IsZero() {
+			now = time.Now()
+		}
+		age := now.Sub(info.ModTime())
+		if f.NewerThan > 0 && age > f.NewerThan {
+			return false
+		}
+		if f.OlderThan > 0 && age < f.OlderThan {
+			return false
+		}
+	}
+	return true
+}
+
+// Match reports whether the named file, described by info, satisfies every condition.
+// The type is only checked, by reading the start of the file, when the size and age match.
+// A file without info or that cannot be read never matches an active filter.
+func (f Filter) Match(fsys fs.FS, name string, info fs.FileInfo) bool {
+	if !f.Active() {
+		return true
+	}
+	if info == nil || !f.MatchInfo(info) {
+		return false
+	}
+	if f.Type == TypeAny {
+		return true
+	}
+
+	file, err := fsys.Open(name)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	head, err := io.ReadAll(io.LimitReader(file, content.BinarySniffLen))
+	if err != nil {
+		return false
+	}
+	return content.IsBinary(head) == (f.Type == TypeBinary)
+}
+
+// sizeUnits maps the suffixes accepted by ParseSize to multipliers. Units are powers of 1024.
+var sizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kb":  1 << 10,
+	"kib": 1 << 10,
+	"m":   1 << 20,
+	"mb":  1 << 20,
+	"mib": 1 << 20,
+	"g":   1 << 30,
+	"gb":  1 << 30,
+	"gib": 1 << 30,
+}
+
+// ParseSize parses a size such as "512", "100k", "1.5MB" or "2GiB".
+// The units are case-insensitive powers of 1024.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	unit, ok := sizeUnits[strings.ToLower(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", s, s[i:])
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q: expected a number followed by an optional unit such as k, M or G", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// ageUnits maps the suffixes accepted by ParseAge to durations
+var ageUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// ParseAge parses an age such as "7d", "2w" or "1d12h". The units are s, m, h, d (days) and w (weeks).
+func ParseAge(s string) (time.Duration, error) {
+	rest := strings.TrimSpace(s)
+	if rest == "" {
+		return 0, fmt.Errorf("invalid age %q: expected a number followed by s, m, h, d or w", s)
+	}
+
+	var total time.Duration
+	for rest != "" {
+		i := strings.IndexFunc(rest, func(r rune) bool { return !unicode.IsDigit(r) })
+		if i <= 0 {
+			return 0, fmt.Errorf("invalid age %q: expected a number followed by s, m, h, d or w", s)
+		}
+		n, err := strconv.Atoi(rest[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid age %q: %w", s, err)
+		}
+		unit, ok := ageUnits[rest[i:i+1]]
+		if !ok {
+			return 0, fmt.Errorf("invalid age %q: unknown unit %q", s, rest[i:i+1])
+		}
+		total += time.Duration(n) * unit
+		rest = rest[i+1:]
+	}
+	return total, nil
+}
@@ -0,0 +1,146 @@
+package attr
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// TestFilter tests size, age and type conditions against files
+func TestFilter(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	fsys := fstest.MapFS{
+		"fresh.md":  {Data: []byte("# Fresh\n"), ModTime: now.Add(-time.Hour)},
+		"stale.md":  {Data: []byte("# Stale\n"), ModTime: now.Add(-30 * day)},
+		"dump.md":   {Data: []byte(strings.Repeat("x", 4096)), ModTime: now.Add(-2 * day)},
+		"image.png": {Data: []byte("\x89PNG\x00\x01"), ModTime: now.Add(-2 * day)},
+		"empty.md":  {Data: nil, ModTime: now.Add(-2 * day)},
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{name: "Inactive", filter: Filter{}, want: []string{"dump.md", "empty.md", "fresh.md", "image.png", "stale.md"}},
+		{name: "Max size", filter: Filter{MaxSize: 1024}, want: []string{"empty.md", "fresh.md", "image.png", "stale.md"}},
+		{name: "Min size", filter: Filter{MinSize: 1024}, want: []string{"dump.md"}},
+		{name: "Newer than", filter: Filter{NewerThan: 7 * day}, want: []string{"dump.md", "empty.md", "fresh.md", "image.png"}},
+		{name: "Older than", filter: Filter{OlderThan: 7 * day}, want: []string{"stale.md"}},
+		{name: "Age range", filter: Filter{NewerThan: 7 * day, OlderThan: day}, want: []string{"dump.md", "empty.md", "image.png"}},
+		{name: "Text", filter: Filter{Type: TypeText}, want: []string{"dump.md", "empty.md", "fresh.md", "stale.md"}},
+		{name: "Binary", filter: Filter{Type: TypeBinary}, want: []string{"image.png"}},
+		{name: "Combined", filter: Filter{MaxSize: 1024, NewerThan: 7 * day, Type: TypeText}, want: []string{"empty.md", "fresh.md"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filter.Now = now
+			var got []string
+			for _, name := range []string{"dump.md", "empty.md", "fresh.md", "image.png", "stale.md"} {
+				info, err := fs.Stat(fsys, name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.filter.Match(fsys, name, info) {
+					got = append(got, name)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("matched %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if (Filter{MaxSize: 1}).Match(fsys, "missing.md", nil) {
+		t.Error("a file without info should not match an active filter")
+	}
+	if (Filter{Type: TypeText}).Match(fsys, "missing.md", fakeInfo{}) {
+		t.Error("an unreadable file should not match a type condition")
+	}
+}
+
+// fakeInfo describes an empty file
+type fakeInfo struct{ fs.FileInfo }
+
+func (fakeInfo) Size() int64        { return 0 }
+func (fakeInfo) ModTime() time.Time { return time.Time{} }
+
+// TestParseSize tests parsing sizes with units
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "512", want: 512},
+		{input: "0", want: 0},
+		{input: "100k", want: 100 << 10},
+		{input: "100KB", want: 100 << 10},
+		{input: "1.5M", want: 3 << 19},
+		{input: "2GiB", want: 2 << 30},
+		{input: "10 mb", want: 10 << 20},
+		{input: "12B", want: 12},
+		{input: "", wantErr: true},
+		{input: "k", wantErr: true},
+		{input: "10x", wantErr: true},
+		{input: "-1k", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseSize(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize(%q) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseAge tests parsing ages with units
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{input: "7d", want: 7 * 24 * time.Hour},
+		{input: "2w", want: 14 * 24 * time.Hour},
+		{input: "36h", want: 36 * time.Hour},
+		{input: "1d12h", want: 36 * time.Hour},
+		{input: "90m", want: 90 * time.Minute},
+		{input: "30s", want: 30 * time.Second},
+		{input: "", wantErr: true},
+		{input: "7", wantErr: true},
+		{input: "d", wantErr: true},
+		{input: "7y", wantErr: true},
+		{input: "-7d", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseAge(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAge(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseAge(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+
+	for _, typ := range []Type{TypeAny, TypeText, TypeBinary} {
+		if got, err := ParseType(typ.String()); err != nil || got != typ {
+			t.Errorf("ParseType(%q) = %v, %v", typ.String(), got, err)
+		}
+	}
+	if _, err := ParseType("image"); err == nil {
+		t.Error("ParseType(image) should fail")
+	}
+}
@@ -0,0 +1,94 @@
+package builtin
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// Scheme is the --from prefix selecting the built-in rule library, e.g. "builtin:" or "builtin:go"
+const Scheme = "builtin:"
+
+//go:embed rules
+var content embed.FS
+
+//go:embed VERSION
+var version string
+
+// Set is a group of built-in rules, stored in a top-level directory of the library
+type Set struct {
+	Name  string
+	Files []string
+}
+
+// Version returns the version of the embedded rule library
+func Version() string {
+	return strings.TrimSpace(version)
+}
+
+// FS returns the whole rule library. Each set is a top-level directory.
+func FS() fs.FS {
+	sub, err := fs.Sub(content, "rules")
+	if err != nil {
+		// The embedded directory always exists
+		panic(err)
+	}
+	return sub
+}
+
+// Open returns the rule library for an empty name, or the named set
+func Open(name string) (fs.FS, error) {
+	if name == "" {
+		return FS(), nil
+	}
+	sets, err := Sets()
+	if err != nil {
+		return nil, err
+	}
+	for _, set := range sets {
+		if set.Name == name {
+			return fs.Sub(FS(), name)
+		}
+	}
+	names := make([]string, len(sets))
+	for i, set := range sets {
+		names[i] = set.Name
+	}
+	return nil, fmt.Errorf("unknown built-in rule set %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+// Sets lists the bundled rule sets sorted by name, with the files of each set
+func Sets() ([]Set, error) {
+	fsys := FS()
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read built-in rules: %w", err)
+	}
+
+	var sets []Set
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		set := Set{Name: entry.Name()}
+		err := fs.WalkDir(fsys, entry.Name(), func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				set.Files = append(set.Files, strings.TrimPrefix(p, entry.Name()+"/"))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read built-in rule set %s: %w", entry.Name(), err)
+		}
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].Name < sets[j].Name
+	})
+	return sets, nil
+}
@@ -0,0 +1,66 @@
+package builtin
+
+import (
+	"io/fs"
+	"regexp"
+	"testing"
+)
+
+// TestVersion tests that the library version is set
+func TestVersion(t *testing.T) {
+	if !regexp.MustCompile(`^\d+\.\d+\.\d+$`).MatchString(Version()) {
+		t.Errorf("Version() = %q, want a version like 2026.10.0", Version())
+	}
+}
+
+// TestSets tests that the bundled sets are listed with their files
+func TestSets(t *testing.T) {
+	sets, err := Sets()
+	if err != nil {
+		t.Fatalf("Sets() error = %v", err)
+	}
+
+	found := make(map[string]Set)
+	for _, set := range sets {
+		if len(set.Files) == 0 {
+			t.Errorf("set %s has no files", set.Name)
+		}
+		found[set.Name] = set
+	}
+	for _, name := range []string{"general", "go", "typescript"} {
+		if _, ok := found[name]; !ok {
+			t.Errorf("Sets() is missing %q", name)
+		}
+	}
+}
+
+// TestOpen tests opening the whole library and single sets
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name     string
+		set      string
+		wantFile string
+		wantErr  bool
+	}{
+		{name: "Whole library", set: "", wantFile: "go/style.md"},
+		{name: "Go set", set: "go", wantFile: "style.md"},
+		{name: "TypeScript set", set: "typescript", wantFile: "testing.md"},
+		{name: "Unknown set", set: "cobol", wantErr: true},
+		{name: "Path traversal", set: "../rules", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys, err := Open(tt.set)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Open(%q) error = %v, wantErr %v", tt.set, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if _, err := fs.Stat(fsys, tt.wantFile); err != nil {
+				t.Errorf("Open(%q) should contain %s: %v", tt.set, tt.wantFile, err)
+			}
+		})
+	}
+}
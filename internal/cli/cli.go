@@ -2,8 +2,17 @@ package cli
 
 import (
 	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
 
 	"github.com/alecthomas/kong"
+	"github.com/upamune/airule/internal/attr"
+	"github.com/upamune/airule/internal/builtin"
+	"github.com/upamune/airule/internal/content"
+	"github.com/upamune/airule/internal/frontmatter"
+	"github.com/upamune/airule/internal/pattern"
 )
 
 var (
@@ -14,39 +23,228 @@ var (
 
 // CLI represents the command-line interface structure
 type CLI struct {
-	From         string   `name:"from" help:"Source directory to copy files from." type:"path" env:"AIRULE_FROM"`
-	To           string   `name:"to" help:"Destination directory to copy files to." type:"path" env:"AIRULE_TO"`
-	Include      []string `name:"include" short:"i" help:"Patterns to include (glob syntax, e.g. '*.go')." env:"AIRULE_INCLUDE"`
-	Exclude      []string `name:"exclude" short:"e" help:"Patterns to exclude (glob syntax, e.g. '*.tmp')." env:"AIRULE_EXCLUDE"`
-	SelectAll    bool     `name:"select-all" help:"Select all files matching the include/exclude patterns." env:"AIRULE_SELECT_ALL"`
-	PreSelect    []string `name:"pre-select" help:"Patterns to pre-select (glob syntax, e.g. '*.go')." env:"AIRULE_PRE_SELECT"`
-	Clean        bool     `name:"clean" help:"Clean the destination directory before copying (preserves hidden files)." default:"true" env:"AIRULE_CLEAN"`
-	CleanExclude []string `name:"clean-exclude" help:"Patterns to exclude from cleaning (glob syntax, e.g. '.gitkeep', 'config/*')." default:".gitkeep" env:"AIRULE_CLEAN_EXCLUDE"`
+	From           []string `name:"from" help:"Source to copy files from: a directory, a git repository (e.g. 'git+https://host/rules.git#v1.2.0'), an archive or the built-in rule library ('builtin:' or 'builtin:<set>'). Can be repeated; later sources override earlier ones for the same relative path." sep:"none" env:"AIRULE_FROM"`
+	To             string   `name:"to" help:"Destination directory to copy files to." type:"path" env:"AIRULE_TO"`
+	Include        []string `name:"include" short:"i" help:"Patterns to include (glob syntax with ** and {a,b}, e.g. '*.go')." sep:"none" env:"AIRULE_INCLUDE"`
+	Exclude        []string `name:"exclude" short:"e" help:"Patterns to exclude (glob syntax with ** and {a,b}, e.g. '*.tmp')." sep:"none" env:"AIRULE_EXCLUDE"`
+	Rules          []string `name:"rule" help:"Gitignore-style rules evaluated in order after --exclude (last match wins, '!pattern' re-includes, trailing '/' matches directories only)." sep:"none" env:"AIRULE_RULE"`
+	RulesFile      string   `name:"rules-file" help:"File containing gitignore-style rules, evaluated before --rule." type:"path" env:"AIRULE_RULES_FILE"`
+	NoIgnore       bool     `name:"no-ignore" help:"Do not honor .gitignore, .git/info/exclude and .airuleignore files in the source." env:"AIRULE_NO_IGNORE"`
+	Where          []string `name:"where" help:"Front-matter conditions files must satisfy, e.g. 'tags contains go' or 'alwaysApply=true'. Can be repeated; all conditions must match." sep:"none" env:"AIRULE_WHERE"`
+	Contains       []string `name:"contains" help:"Regular expressions file contents must match, e.g. 'golangci-lint'. Can be repeated; all expressions must match." sep:"none" env:"AIRULE_CONTAINS"`
+	NotContains    []string `name:"not-contains" help:"Regular expressions file contents must not match. Can be repeated." sep:"none" env:"AIRULE_NOT_CONTAINS"`
+	IgnoreCase     bool     `name:"ignore-case" help:"Match --contains and --not-contains case-insensitively." env:"AIRULE_IGNORE_CASE"`
+	MaxScanSize    int64    `name:"max-scan-size" help:"Largest file in bytes scanned by --contains and --not-contains; larger files and binary files never match." default:"1048576" env:"AIRULE_MAX_SCAN_SIZE"`
+	MinSize        string   `name:"min-size" help:"Smallest file to list, e.g. '1k'. Units are powers of 1024: k, M, G." env:"AIRULE_MIN_SIZE"`
+	MaxSize        string   `name:"max-size" help:"Largest file to list, e.g. '100k'. Units are powers of 1024: k, M, G." env:"AIRULE_MAX_SIZE"`
+	NewerThan      string   `name:"newer-than" help:"List only files modified within the given age, e.g. '7d'. Units: s, m, h, d, w." env:"AIRULE_NEWER_THAN"`
+	OlderThan      string   `name:"older-than" help:"List only files last modified longer ago than the given age, e.g. '90d'. Units: s, m, h, d, w." env:"AIRULE_OLDER_THAN"`
+	Type           string   `name:"type" help:"Kind of files to list: any, text or binary (a NUL byte within the first 8000 bytes)." enum:"any,text,binary" default:"any" env:"AIRULE_TYPE"`
+	Symlinks       string   `name:"symlinks" help:"How symbolic links in the sources are treated: follow them (skipping links that form a loop), preserve them as links in the destination, or skip them." enum:"follow,preserve,skip" default:"follow" env:"AIRULE_SYMLINKS"`
+	Strict         bool     `name:"strict" help:"Fail when a path in the sources cannot be read (unreadable directory, broken symlink, symlink loop, too-long path, invalid front-matter) instead of skipping it with a warning." env:"AIRULE_STRICT"`
+	Sort           string   `name:"sort" help:"Order of the file list: path, name, mtime, size or depth. Directories sort by the total size and newest modification time of the files below them." enum:"path,name,mtime,size,depth" default:"path" env:"AIRULE_SORT"`
+	SortDesc       bool     `name:"sort-desc" help:"Sort in descending order (e.g. largest or newest first)." env:"AIRULE_SORT_DESC"`
+	DirsFirst      bool     `name:"dirs-first" help:"List directories before files." env:"AIRULE_DIRS_FIRST"`
+	Group          string   `name:"group" help:"Group the file list by top-level folder or by source: none, folder or source." enum:"none,folder,source" default:"none" env:"AIRULE_GROUP"`
+	Entries        string   `name:"entries" help:"Kinds of entries to list: files, dirs or both. A selected directory copies the files found below it." enum:"files,dirs,both" default:"both" env:"AIRULE_ENTRIES"`
+	SelectAll      bool     `name:"select-all" help:"Select all files matching the include/exclude patterns." env:"AIRULE_SELECT_ALL"`
+	PreSelect      []string `name:"pre-select" help:"Patterns to pre-select (glob syntax with ** and {a,b}, e.g. '*.go')." sep:"none" env:"AIRULE_PRE_SELECT"`
+	PreSelectWhere []string `name:"pre-select-where" help:"Front-matter conditions selecting files to pre-select, e.g. 'alwaysApply=true'. Can be repeated; all conditions must match." sep:"none" env:"AIRULE_PRE_SELECT_WHERE"`
+	Mode           string   `name:"mode" help:"How files are installed in the destination: copy them, link them with symlink (absolute target) or relative-symlink, or hardlink them (copying files on another file system)." enum:"copy,symlink,hardlink,relative-symlink" default:"copy" env:"AIRULE_MODE"`
+	Clean          bool     `name:"clean" help:"Clean the destination directory before copying (preserves hidden files)." default:"true" env:"AIRULE_CLEAN"`
+	CleanStrategy  string   `name:"clean-strategy" help:"What --clean removes: all (everything not hidden or excluded) or managed (only files airule installed before, recorded in .airule.lock, that are no longer selected)." enum:"all,managed" default:"all" env:"AIRULE_CLEAN_STRATEGY"`
+	Manifest       bool     `name:"manifest" help:"Record the installed files in .airule.lock in the destination, so that later runs detect local edits and can clean only what airule installed. Implied by --clean-strategy managed and --local-edits merge; a destination with .airule.lock keeps it up to date." env:"AIRULE_MANIFEST"`
+	CleanExclude   []string `name:"clean-exclude" help:"Patterns to exclude from cleaning (glob syntax with ** and {a,b}, e.g. '.gitkeep', 'config/*')." default:".gitkeep" sep:"none" env:"AIRULE_CLEAN_EXCLUDE"`
+	OnConflict     string   `name:"on-conflict" help:"What to do with existing destination files that would be replaced with different content: overwrite them, skip them, back them up as <file>.bak before overwriting, overwrite them only if the source is newer, prompt for each file with a diff, or fail before copying anything." enum:"overwrite,skip,backup,newer,prompt,fail" default:"overwrite" env:"AIRULE_ON_CONFLICT"`
+	LocalEdits     string   `name:"local-edits" help:"What to do with destination files edited since airule installed them: prompt for each file, keep them, overwrite them, save them as <file>.orig before overwriting (orig) or merge the edits with the new version (merge)." enum:"prompt,keep,overwrite,orig,merge" default:"prompt" env:"AIRULE_LOCAL_EDITS"`
+	Checksum       bool     `name:"checksum" help:"Compare destination files with the same size and modification time as their source by SHA-256 as well, instead of taking them as unchanged." env:"AIRULE_CHECKSUM"`
+	DryRun         bool     `name:"dry-run" help:"Print the files that would be created, overwritten or left unchanged and the destination paths --clean would remove, without changing anything." env:"AIRULE_DRY_RUN"`
+	Format         string   `name:"format" help:"Format of the --dry-run plan: text or json." enum:"text,json" default:"text" env:"AIRULE_FORMAT"`
+	KeepBackups    int      `name:"keep-backups" help:"Number of runs whose removed and overwritten destination files are kept in .airule/backups for 'airule undo'; 0 keeps none." default:"10" env:"AIRULE_KEEP_BACKUPS"`
+
+	ListBuiltin bool `name:"list-builtin" help:"List the built-in rule sets and the version of the rule library, then exit."`
+
+	CacheDir string `name:"cache-dir" help:"Directory used to cache remote sources (defaults to the user cache directory)." type:"path" env:"AIRULE_CACHE_DIR"`
 
 	Version kong.VersionFlag `short:"v" help:"Show version and exit."`
+
+	Copy CopyCmd `cmd:"" default:"1" hidden:"" help:"Interactively copy rule files."`
+	Undo UndoCmd `cmd:"" help:"Restore the destination to its state before the latest run, or before the given run and every run after it."`
+
+	// Command is the command being run, set by BeforeApply
+	Command string `kong:"-"`
+}
+
+// CopyCmd is the default command, copying the selected files
+type CopyCmd struct{}
+
+// UndoCmd undoes runs recorded in the backups of the destination directory
+type UndoCmd struct {
+	RunID string `arg:"" optional:"" name:"run-id" help:"Run to undo, together with every run after it. Defaults to the latest run."`
+	List  bool   `name:"list" help:"List the runs that can be undone instead of undoing one."`
+}
+
+// Commands run by the application
+const (
+	CommandCopy = "copy"
+	CommandUndo = "undo"
+)
+
+// BeforeApply records the command being run, so that Validate knows which flags it needs.
+// kong calls it after parsing the command line and before validating it.
+func (c *CLI) BeforeApply(ctx *kong.Context) error {
+	if fields := strings.Fields(ctx.Command()); len(fields) > 0 {
+		c.Command = fields[0]
+	}
+	return nil
+}
+
+// patternLists are the pattern flags whose environment variables hold comma-separated lists
+var patternLists = map[string]bool{"include": true, "exclude": true, "pre-select": true, "clean-exclude": true}
+
+// AfterApply splits the patterns read from environment variables on the commas outside braces,
+// so that AIRULE_INCLUDE='*.md,*.mdc' still lists two patterns while '*.{md,mdc}' stays one.
+// Patterns given as flags are never split.
+func (c *CLI) AfterApply(ctx *kong.Context) error {
+	given := make(map[string]bool)
+	for _, p := range ctx.Path {
+		if p.Flag != nil {
+			given[p.Flag.Name] = true
+		}
+	}
+	for _, flag := range ctx.Flags() {
+		if !patternLists[flag.Name] || given[flag.Name] {
+			continue
+		}
+		for _, env := range flag.Envs {
+			// kong reads the first variable set, as a single pattern
+			if value := os.Getenv(env); value != "" {
+				flag.Target.Set(reflect.ValueOf(pattern.SplitList(value)))
+				break
+			}
+		}
+	}
+	return nil
 }
 
 // Validate validates the CLI arguments
 func (c *CLI) Validate() error {
-	// If version flag is set, no validation needed
-	if c.Version {
+	// If version or list flag is set, no validation needed
+	if bool(c.Version) || c.ListBuiltin {
+		return nil
+	}
+
+	// Undoing only needs the destination
+	if c.Command == CommandUndo {
+		if c.To == "" {
+			return fmt.Errorf("--to flag is required")
+		}
 		return nil
 	}
 
 	// Validate required fields when not showing version
-	if c.From == "" {
+	if len(c.From) == 0 {
 		return fmt.Errorf("--from flag is required")
 	}
 	if c.To == "" {
 		return fmt.Errorf("--to flag is required")
 	}
 
+	// Validate glob patterns so that typos are reported instead of silently never matching
+	for _, patterns := range [][]string{c.Include, c.Exclude, c.PreSelect, c.CleanExclude} {
+		for _, p := range patterns {
+			if err := pattern.Validate(p); err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", p, err)
+			}
+		}
+	}
+	if _, err := pattern.ParseRules(c.Rules); err != nil {
+		return fmt.Errorf("invalid --rule: %w", err)
+	}
+	if _, err := c.ContentMatcher(); err != nil {
+		return err
+	}
+	if _, err := c.AttrFilter(); err != nil {
+		return err
+	}
+	if _, err := frontmatter.ParseConditions(c.Where); err != nil {
+		return fmt.Errorf("invalid --where: %w", err)
+	}
+	if _, err := frontmatter.ParseConditions(c.PreSelectWhere); err != nil {
+		return fmt.Errorf("invalid --pre-select-where: %w", err)
+	}
+	if c.KeepBackups < 0 {
+		return fmt.Errorf("invalid --keep-backups: %d is negative", c.KeepBackups)
+	}
+
 	return nil
 }
 
+// ContentMatcher compiles the --contains and --not-contains expressions
+func (c *CLI) ContentMatcher() (content.Matcher, error) {
+	m := content.Matcher{MaxSize: c.MaxScanSize}
+	for _, exprs := range []struct {
+		flag   string
+		values []string
+		invert bool
+	}{
+		{flag: "--contains", values: c.Contains},
+		{flag: "--not-contains", values: c.NotContains, invert: true},
+	} {
+		for _, expr := range exprs.values {
+			p, err := content.Compile(expr, c.IgnoreCase, exprs.invert)
+			if err != nil {
+				return m, fmt.Errorf("invalid %s: %w", exprs.flag, err)
+			}
+			m.Predicates = append(m.Predicates, p)
+		}
+	}
+	return m, nil
+}
+
+// AttrFilter parses the --min-size, --max-size, --newer-than, --older-than and --type conditions
+func (c *CLI) AttrFilter() (attr.Filter, error) {
+	var f attr.Filter
+	var err error
+	for _, size := range []struct {
+		flag  string
+		value string
+		dst   *int64
+	}{
+		{flag: "--min-size", value: c.MinSize, dst: &f.MinSize},
+		{flag: "--max-size", value: c.MaxSize, dst: &f.MaxSize},
+	} {
+		if size.value == "" {
+			continue
+		}
+		if *size.dst, err = attr.ParseSize(size.value); err != nil {
+			return f, fmt.Errorf("invalid %s: %w", size.flag, err)
+		}
+	}
+	for _, age := range []struct {
+		flag  string
+		value string
+		dst   *time.Duration
+	}{
+		{flag: "--newer-than", value: c.NewerThan, dst: &f.NewerThan},
+		{flag: "--older-than", value: c.OlderThan, dst: &f.OlderThan},
+	} {
+		if age.value == "" {
+			continue
+		}
+		if *age.dst, err = attr.ParseAge(age.value); err != nil {
+			return f, fmt.Errorf("invalid %s: %w", age.flag, err)
+		}
+	}
+	if f.Type, err = attr.ParseType(c.Type); err != nil {
+		return f, fmt.Errorf("invalid --type: %w", err)
+	}
+	return f, nil
+}
+
 // GetVersion returns the formatted version string
 func GetVersion() string {
-	return fmt.Sprintf("%s (commit: %s, built at: %s)", version, commit, buildDate)
+	return fmt.Sprintf("%s (commit: %s, built at: %s, builtin rules: %s)", version, commit, buildDate, builtin.Version())
 }
 
 // SetVersionInfo sets the version information
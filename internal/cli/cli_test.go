@@ -3,8 +3,10 @@ package cli
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/alecthomas/kong"
+	"github.com/upamune/airule/internal/attr"
 )
 
 // TestDefaultValues tests that CLI struct has correct default values
@@ -39,6 +41,31 @@ func TestDefaultValues(t *testing.T) {
 			actual:   cli.CleanExclude,
 			expected: []string{".gitkeep"},
 		},
+		{
+			name:     "CleanStrategy default value",
+			actual:   cli.CleanStrategy,
+			expected: "all",
+		},
+		{
+			name:     "LocalEdits default value",
+			actual:   cli.LocalEdits,
+			expected: "prompt",
+		},
+		{
+			name:     "OnConflict default value",
+			actual:   cli.OnConflict,
+			expected: "overwrite",
+		},
+		{
+			name:     "Checksum default value",
+			actual:   cli.Checksum,
+			expected: false,
+		},
+		{
+			name:     "Mode default value",
+			actual:   cli.Mode,
+			expected: "copy",
+		},
 		{
 			name:     "SelectAll default value",
 			actual:   cli.SelectAll,
@@ -96,6 +123,96 @@ func TestCleanExcludeOverride(t *testing.T) {
 	}
 }
 
+// TestBracePatternsAreNotSplit tests that brace expansion patterns survive flag parsing
+func TestBracePatternsAreNotSplit(t *testing.T) {
+	var cli CLI
+
+	parser, err := kong.New(&cli)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+
+	_, err = parser.Parse([]string{
+		"--from", "/tmp/src",
+		"--to", "/tmp/dst",
+		"--include", "*.{md,mdc}",
+		"--pre-select", "rules/**/go-*.{md,mdc}",
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse arguments: %v", err)
+	}
+
+	if want := []string{"*.{md,mdc}"}; !reflect.DeepEqual(cli.Include, want) {
+		t.Errorf("Include: got %v, want %v", cli.Include, want)
+	}
+	if want := []string{"rules/**/go-*.{md,mdc}"}; !reflect.DeepEqual(cli.PreSelect, want) {
+		t.Errorf("PreSelect: got %v, want %v", cli.PreSelect, want)
+	}
+}
+
+// TestPatternEnvironmentVariables tests that pattern lists in environment variables are split
+// on commas outside braces, while flags are taken as single patterns
+func TestPatternEnvironmentVariables(t *testing.T) {
+	t.Setenv("AIRULE_INCLUDE", "*.md,*.mdc")
+	t.Setenv("AIRULE_EXCLUDE", "*.{tmp,bak},drafts/**")
+	t.Setenv("AIRULE_PRE_SELECT", "a.md,b.md")
+
+	var cli CLI
+	parser, err := kong.New(&cli)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	_, err = parser.Parse([]string{"--from", "/tmp/src", "--to", "/tmp/dst", "--pre-select", "c,d.md"})
+	if err != nil {
+		t.Fatalf("Failed to parse arguments: %v", err)
+	}
+
+	if want := []string{"*.md", "*.mdc"}; !reflect.DeepEqual(cli.Include, want) {
+		t.Errorf("Include: got %v, want %v", cli.Include, want)
+	}
+	if want := []string{"*.{tmp,bak}", "drafts/**"}; !reflect.DeepEqual(cli.Exclude, want) {
+		t.Errorf("Exclude: got %v, want %v", cli.Exclude, want)
+	}
+	if want := []string{"c,d.md"}; !reflect.DeepEqual(cli.PreSelect, want) {
+		t.Errorf("PreSelect: got %v, want %v", cli.PreSelect, want)
+	}
+	if want := []string{".gitkeep"}; !reflect.DeepEqual(cli.CleanExclude, want) {
+		t.Errorf("CleanExclude: got %v, want %v", cli.CleanExclude, want)
+	}
+}
+
+// TestValidateRejectsInvalidPatterns tests that malformed glob patterns are reported
+func TestValidateRejectsInvalidPatterns(t *testing.T) {
+	cli := CLI{From: []string{"/tmp/src"}, To: "/tmp/dst", Include: []string{"*.{md,mdc"}}
+	if err := cli.Validate(); err == nil {
+		t.Error("Validate() should fail for an unclosed brace pattern")
+	}
+}
+
+// TestMultipleFrom tests that --from can be repeated and keeps the declaration order
+func TestMultipleFrom(t *testing.T) {
+	var cli CLI
+
+	parser, err := kong.New(&cli)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+
+	_, err = parser.Parse([]string{
+		"--from", "/tmp/org-rules",
+		"--from", "/tmp/team-rules",
+		"--to", "/tmp/dst",
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse arguments: %v", err)
+	}
+
+	expected := []string{"/tmp/org-rules", "/tmp/team-rules"}
+	if !reflect.DeepEqual(cli.From, expected) {
+		t.Errorf("From: got %v, want %v", cli.From, expected)
+	}
+}
+
 // TestEnvironmentVariables tests that environment variables work correctly
 func TestEnvironmentVariables(t *testing.T) {
 	// This test would require setting environment variables
@@ -113,3 +230,129 @@ func TestEnvironmentVariables(t *testing.T) {
 		t.Error("Parser should not be nil")
 	}
 }
+
+// TestListBuiltinWithoutSource tests that --list-builtin does not require --from and --to
+func TestListBuiltinWithoutSource(t *testing.T) {
+	var cli CLI
+
+	parser, err := kong.New(&cli)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	if _, err := parser.Parse([]string{"--list-builtin"}); err != nil {
+		t.Fatalf("Failed to parse arguments: %v", err)
+	}
+	if err := cli.Validate(); err != nil {
+		t.Errorf("Validate() error = %v, want nil with --list-builtin", err)
+	}
+}
+
+// TestValidateRejectsInvalidConditions tests that malformed front-matter conditions are reported
+func TestValidateRejectsInvalidConditions(t *testing.T) {
+	for _, cli := range []CLI{
+		{From: []string{"/tmp/src"}, To: "/tmp/dst", Where: []string{"tags"}},
+		{From: []string{"/tmp/src"}, To: "/tmp/dst", PreSelectWhere: []string{"=true"}},
+	} {
+		if err := cli.Validate(); err == nil {
+			t.Errorf("Validate() should fail for %v %v", cli.Where, cli.PreSelectWhere)
+		}
+	}
+
+	valid := CLI{From: []string{"/tmp/src"}, To: "/tmp/dst", Where: []string{"tags contains go"}, PreSelectWhere: []string{"alwaysApply=true"}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+// TestContentMatcher tests compiling --contains and --not-contains
+func TestContentMatcher(t *testing.T) {
+	cli := CLI{Contains: []string{"golangci-lint"}, NotContains: []string{"deprecated"}, IgnoreCase: true, MaxScanSize: 1024}
+	m, err := cli.ContentMatcher()
+	if err != nil {
+		t.Fatalf("ContentMatcher() error = %v", err)
+	}
+	if len(m.Predicates) != 2 || m.Predicates[0].Invert || !m.Predicates[1].Invert || m.MaxSize != 1024 {
+		t.Errorf("ContentMatcher() = %+v", m)
+	}
+	if !m.Predicates[0].Pattern.MatchString("GOLANGCI-LINT") {
+		t.Error("ContentMatcher() should ignore case")
+	}
+
+	invalid := CLI{From: []string{"/tmp/src"}, To: "/tmp/dst", Contains: []string{"(unclosed"}}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Validate() should fail for an invalid --contains expression")
+	}
+}
+
+// TestAttrFilter tests parsing the size, age and type conditions
+func TestAttrFilter(t *testing.T) {
+	cli := CLI{MinSize: "1k", MaxSize: "2M", NewerThan: "7d", OlderThan: "1d12h", Type: "text"}
+	f, err := cli.AttrFilter()
+	if err != nil {
+		t.Fatalf("AttrFilter() error = %v", err)
+	}
+	want := attr.Filter{MinSize: 1 << 10, MaxSize: 2 << 20, NewerThan: 7 * 24 * time.Hour, OlderThan: 36 * time.Hour, Type: attr.TypeText}
+	if f != want {
+		t.Errorf("AttrFilter() = %+v, want %+v", f, want)
+	}
+
+	if f, err := (&CLI{}).AttrFilter(); err != nil || f.Active() {
+		t.Errorf("AttrFilter() without flags = %+v, %v, want an inactive filter", f, err)
+	}
+
+	for _, invalid := range []CLI{
+		{MaxSize: "10x"},
+		{MinSize: "big"},
+		{NewerThan: "7"},
+		{OlderThan: "1y"},
+	} {
+		invalid.From, invalid.To = []string{"/tmp/src"}, "/tmp/dst"
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Validate() should fail for %+v", invalid)
+		}
+	}
+}
+
+// TestUndoCommand tests that undo only requires --to and that copying stays the default command
+func TestUndoCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		command   string
+		runID     string
+		list      bool
+		wantError bool
+	}{
+		{name: "Default command", args: []string{"--from", "/tmp/src", "--to", "/tmp/dst"}, command: CommandCopy},
+		{name: "Latest run", args: []string{"undo", "--to", "/tmp/dst"}, command: CommandUndo},
+		{name: "Given run", args: []string{"undo", "20250101-120000", "--to", "/tmp/dst"}, command: CommandUndo, runID: "20250101-120000"},
+		{name: "List", args: []string{"undo", "--list", "--to", "/tmp/dst"}, command: CommandUndo, list: true},
+		{name: "Without destination", args: []string{"undo"}, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cli CLI
+			parser, err := kong.New(&cli)
+			if err != nil {
+				t.Fatalf("Failed to create parser: %v", err)
+			}
+			_, err = parser.Parse(tt.args)
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("Parse(%v) should fail", tt.args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to parse arguments: %v", err)
+			}
+			if cli.Command != tt.command || cli.Undo.RunID != tt.runID || cli.Undo.List != tt.list {
+				t.Errorf("Parse(%v) = command %q, run %q, list %v", tt.args, cli.Command, cli.Undo.RunID, cli.Undo.List)
+			}
+			if cli.KeepBackups != 10 {
+				t.Errorf("KeepBackups = %d, want 10", cli.KeepBackups)
+			}
+		})
+	}
+}
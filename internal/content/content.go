@@ -0,0 +1,105 @@
+package content
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+)
+
+// DefaultMaxSize is the default size cap for scanning file bodies (1 MiB)
+const DefaultMaxSize = 1024 * 1024
+
+// BinarySniffLen is how many leading bytes are inspected to detect binary files, as git does
+const BinarySniffLen = 8000
+
+// Predicate is a regular expression that file bodies must (or, when inverted, must not) match
+type Predicate struct {
+	Pattern *regexp.Regexp
+	Invert  bool
+}
+
+// Compile builds a predicate from a regular expression
+func Compile(expr string, ignoreCase, invert bool) (Predicate, error) {
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return Predicate{}, fmt.Errorf("invalid regular expression %q: %w", expr, err)
+	}
+	return Predicate{Pattern: re, Invert: invert}, nil
+}
+
+// Matcher evaluates content predicates against files
+type Matcher struct {
+	Predicates []Predicate
+	// MaxSize is the largest file that is scanned; larger files never match. Zero means DefaultMaxSize.
+	MaxSize int64
+}
+
+// Active reports whether there is anything to check
+func (m Matcher) Active() bool {
+	return len(m.Predicates) > 0
+}
+
+// maxSize returns the effective size cap
+func (m Matcher) maxSize() int64 {
+	if m.MaxSize > 0 {
+		return m.MaxSize
+	}
+	return DefaultMaxSize
+}
+
+// Match reports whether the named file satisfies every predicate.
+// Files larger than the size cap, binary files and unreadable files never match.
+func (m Matcher) Match(fsys fs.FS, name string) bool {
+	if !m.Active() {
+		return true
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.Size() > m.maxSize() {
+		return false
+	}
+	// Read one byte more than the cap in case the reported size was wrong
+	data, err := io.ReadAll(io.LimitReader(f, m.maxSize()+1))
+	if err != nil || int64(len(data)) > m.maxSize() || IsBinary(data) {
+		return false
+	}
+
+	for _, p := range m.Predicates {
+		if p.Pattern.Match(data) == p.Invert {
+			return false
+		}
+	}
+	return true
+}
+
+// Highlights returns the patterns of the non-inverted predicates, which identify the
+// lines that made a file match
+func (m Matcher) Highlights() []*regexp.Regexp {
+	var patterns []*regexp.Regexp
+	for _, p := range m.Predicates {
+		if !p.Invert {
+			patterns = append(patterns, p.Pattern)
+		}
+	}
+	return patterns
+}
+
+// IsBinary reports whether data looks like binary content, i.e. contains a NUL byte
+// within its first 8000 bytes
+func IsBinary(data []byte) bool {
+	if len(data) > BinarySniffLen {
+		data = data[:BinarySniffLen]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
@@ -0,0 +1,84 @@
+package content
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// TestMatcher tests content predicates against files
+func TestMatcher(t *testing.T) {
+	fsys := fstest.MapFS{
+		"lint.md":   {Data: []byte("# Linting\nRun golangci-lint before pushing.\n")},
+		"style.md":  {Data: []byte("# Style\nUse gofmt.\n")},
+		"upper.md":  {Data: []byte("GOLANGCI-LINT is required\n")},
+		"large.md":  {Data: []byte("golangci-lint " + strings.Repeat("x", 100))},
+		"binary.md": {Data: []byte("golangci-lint\x00\x01")},
+	}
+
+	tests := []struct {
+		name       string
+		expr       string
+		ignoreCase bool
+		invert     bool
+		maxSize    int64
+		want       []string
+	}{
+		{name: "Literal", expr: "golangci-lint", maxSize: 64, want: []string{"lint.md"}},
+		{name: "Ignore case", expr: "golangci-lint", ignoreCase: true, maxSize: 64, want: []string{"lint.md", "upper.md"}},
+		{name: "Regular expression", expr: `^Use \w+`, maxSize: 64, want: nil},
+		{name: "Multiline anchors", expr: `(?m)^Use \w+`, maxSize: 64, want: []string{"style.md"}},
+		{name: "Invert", expr: "golangci-lint", invert: true, maxSize: 64, want: []string{"style.md", "upper.md"}},
+		{name: "Default size cap", expr: "golangci-lint", want: []string{"large.md", "lint.md"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Compile(tt.expr, tt.ignoreCase, tt.invert)
+			if err != nil {
+				t.Fatalf("Compile() error = %v", err)
+			}
+			m := Matcher{Predicates: []Predicate{p}, MaxSize: tt.maxSize}
+
+			var got []string
+			for _, name := range []string{"binary.md", "large.md", "lint.md", "style.md", "upper.md"} {
+				if m.Match(fsys, name) {
+					got = append(got, name)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("matched %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if !(Matcher{}).Match(fsys, "missing.md") {
+		t.Error("an inactive matcher should match everything")
+	}
+	if _, err := Compile("(unclosed", false, false); err == nil {
+		t.Error("Compile() should fail for an invalid expression")
+	}
+}
+
+// TestIsBinary tests binary detection
+func TestIsBinary(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{name: "Text", data: []byte("hello\nworld"), want: false},
+		{name: "UTF-8", data: []byte("こんにちは"), want: false},
+		{name: "Empty", data: nil, want: false},
+		{name: "NUL byte", data: []byte("PNG\x00\x1a"), want: true},
+		{name: "NUL after sniff length", data: append([]byte(strings.Repeat("a", BinarySniffLen)), 0), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsBinary(tt.data); got != tt.want {
+				t.Errorf("IsBinary() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
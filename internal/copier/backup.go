@@ -0,0 +1,247 @@
+package copier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/upamune/airule/internal/writefs"
+)
+
+// BackupDir is the directory in the destination holding the backups of previous runs.
+// It is hidden, so cleaning never removes it.
+const BackupDir = ".airule/backups"
+
+// DefaultKeepBackups is the number of runs whose backups are kept by default
+const DefaultKeepBackups = 10
+
+const (
+	// runFile is the description of a run in its backup directory
+	runFile = "run.json"
+	// runFilesDir holds the previous content of the paths removed or replaced by a run
+	runFilesDir = "files"
+	// runIDFormat names runs after the time they started, so that they sort chronologically
+	runIDFormat = "20060102-150405"
+)
+
+// Run describes a copy whose changes to the destination can be undone
+type Run struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	// Created are the paths that did not exist before the run
+	Created []string `json:"created,omitempty"`
+	// Saved are the paths removed or replaced by the run, whose previous content is in the backup
+	Saved []string `json:"saved,omitempty"`
+	// Modes are the previous permissions of directories whose permissions were changed
+	Modes map[string]fs.FileMode `json:"modes,omitempty"`
+	// Decisions describe how edited and conflicting paths were handled, by path
+	Decisions map[string]string `json:"decisions,omitempty"`
+}
+
+// run describes the changes recorded by the journal
+func (j *journal) run(id string, start time.Time) *Run {
+	r := &Run{ID: id, Time: start, Created: j.created}
+	// Directories written with the permissions they had need no undoing
+	for name, mode := range j.modes {
+		if info, err := j.dst.Stat(name); err != nil || info.Mode().Perm() != mode {
+			if r.Modes == nil {
+				r.Modes = make(map[string]fs.FileMode)
+			}
+			r.Modes[name] = mode
+		}
+	}
+	for name := range j.saved {
+		r.Saved = append(r.Saved, name)
+	}
+	sort.Strings(r.Saved)
+	return r
+}
+
+// journal returns a journal that undoes the run, reading previous content from backup
+func (r *Run) journal(dst, backup writefs.FS) *journal {
+	j := newJournal(dst, backup)
+	j.created = r.Created
+	for _, name := range r.Saved {
+		j.saved[name] = true
+	}
+	for name, mode := range r.Modes {
+		j.modes[name] = mode
+	}
+	return j
+}
+
+// empty reports whether the run changed nothing
+func (r *Run) empty() bool {
+	return len(r.Created) == 0 && len(r.Saved) == 0 && len(r.Modes) == 0
+}
+
+// backupRoot returns the directory on disk holding the backups of the destination directory
+func backupRoot(toDir string) string {
+	return filepath.Join(toDir, filepath.FromSlash(BackupDir))
+}
+
+// newRunID returns an unused ID for a run starting at the given time
+func newRunID(root string, start time.Time) string {
+	id := start.Format(runIDFormat)
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(filepath.Join(root, id)); errors.Is(err, fs.ErrNotExist) {
+			return id
+		}
+		id = fmt.Sprintf("%s-%d", start.Format(runIDFormat), i)
+	}
+}
+
+// executeWithBackup carries out the plan in the destination directory, keeping whatever it
+// removes or replaces in a new run directory of the backup area. On success the run is
+// recorded and backups beyond keep are pruned; on failure the destination is rolled back
+// and the run directory is removed.
+func (p *Plan) executeWithBackup(toDir string, keep int) (*Run, error) {
+	root := backupRoot(toDir)
+	airuleDir := filepath.Dir(root)
+	_, err := os.Lstat(airuleDir)
+	createdArea := errors.Is(err, fs.ErrNotExist)
+
+	start := time.Now()
+	id := newRunID(root, start)
+	runDir := filepath.Join(root, id)
+	if err := os.MkdirAll(filepath.Join(runDir, runFilesDir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	j, err := p.executeJournal(writefs.Dir(toDir), writefs.Dir(filepath.Join(runDir, runFilesDir)))
+	if err != nil {
+		cleanup := runDir
+		if createdArea {
+			cleanup = airuleDir
+		}
+		if rmErr := os.RemoveAll(cleanup); rmErr != nil {
+			return nil, errors.Join(err, fmt.Errorf("failed to remove backup directory: %w", rmErr))
+		}
+		return nil, err
+	}
+
+	run := j.run(id, start)
+	if run.empty() {
+		return nil, os.RemoveAll(runDir)
+	}
+	for _, a := range p.Actions {
+		if note := a.Note(); note != "" {
+			if run.Decisions == nil {
+				run.Decisions = make(map[string]string)
+			}
+			run.Decisions[a.Path] = note
+		}
+	}
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode run: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(runDir, runFile), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to record run: %w", err)
+	}
+	if err := PruneBackups(toDir, keep); err != nil {
+		return run, err
+	}
+	return run, nil
+}
+
+// Runs returns the runs of the destination directory that can be undone, oldest first
+func Runs(toDir string) ([]*Run, error) {
+	root := backupRoot(toDir)
+	entries, err := os.ReadDir(root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backups: %w", err)
+	}
+
+	var runs []*Run
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(root, entry.Name(), runFile))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // An unfinished run, or something else
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read run %s: %w", entry.Name(), err)
+		}
+		var run Run
+		if err := json.Unmarshal(data, &run); err != nil {
+			return nil, fmt.Errorf("failed to read run %s: %w", entry.Name(), err)
+		}
+		run.ID = entry.Name()
+		runs = append(runs, &run)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		if !runs[i].Time.Equal(runs[j].Time) {
+			return runs[i].Time.Before(runs[j].Time)
+		}
+		return runs[i].ID < runs[j].ID
+	})
+	return runs, nil
+}
+
+// PruneBackups removes the backups of all but the keep most recent runs
+func PruneBackups(toDir string, keep int) error {
+	runs, err := Runs(toDir)
+	if err != nil {
+		return err
+	}
+	for len(runs) > keep {
+		if err := os.RemoveAll(filepath.Join(backupRoot(toDir), runs[0].ID)); err != nil {
+			return fmt.Errorf("failed to remove backup %s: %w", runs[0].ID, err)
+		}
+		runs = runs[1:]
+	}
+	return nil
+}
+
+// Undo restores the destination directory to its state before the run with the given ID,
+// or before the latest run when id is empty. Runs after it are undone first, newest first,
+// since they may have changed the same paths. The backups of undone runs are removed.
+// It returns the undone runs, newest first.
+func Undo(toDir, id string) ([]*Run, error) {
+	runs, err := Runs(toDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("no runs to undo in %s", toDir)
+	}
+
+	first := len(runs) - 1
+	if id != "" {
+		first = -1
+		for i, run := range runs {
+			if run.ID == id {
+				first = i
+			}
+		}
+		if first < 0 {
+			return nil, fmt.Errorf("run %s not found in %s", id, backupRoot(toDir))
+		}
+	}
+
+	var undone []*Run
+	for i := len(runs) - 1; i >= first; i-- {
+		run := runs[i]
+		runDir := filepath.Join(backupRoot(toDir), run.ID)
+		j := run.journal(writefs.Dir(toDir), writefs.Dir(filepath.Join(runDir, runFilesDir)))
+		if err := j.rollback(); err != nil {
+			return undone, fmt.Errorf("failed to undo run %s: %w", run.ID, err)
+		}
+		if err := os.RemoveAll(runDir); err != nil {
+			return undone, fmt.Errorf("failed to remove backup %s: %w", run.ID, err)
+		}
+		undone = append(undone, run)
+	}
+	return undone, nil
+}
@@ -0,0 +1,151 @@
+package copier
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/upamune/airule/internal/writefs"
+)
+
+// withoutBackups returns a snapshot without the backup area
+func withoutBackups(files map[string]string) map[string]string {
+	got := make(map[string]string)
+	for name, content := range files {
+		if !strings.HasPrefix(name, ".airule") {
+			got[name] = content
+		}
+	}
+	return got
+}
+
+// TestBackupAndUndo tests that runs keep what they remove or overwrite and can be undone
+func TestBackupAndUndo(t *testing.T) {
+	dstDir := filepath.Join(t.TempDir(), "rules")
+	dst := writefs.Dir(dstDir)
+	for name, content := range map[string]string{
+		"go/style.md":  "old style",
+		"stale/old.md": "stale",
+		".gitkeep":     "",
+	} {
+		if err := dst.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dstDir, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	original := snapshot(t, dst)
+
+	copyRun := func(style string, clean bool) *Run {
+		t.Helper()
+		src := fstest.MapFS{
+			"go/style.md": {Data: []byte(style), Mode: 0644},
+			"new/a.md":    {Data: []byte("a"), Mode: 0644},
+		}
+		items := []Item{{Root: "/rules", Path: "go", FS: src}, {Root: "/rules", Path: "new", FS: src}}
+		plan, err := PlanItems(items, dstDir, Options{Clean: clean, KeepBackups: 2})
+		if err != nil {
+			t.Fatalf("PlanItems() error = %v", err)
+		}
+		run, err := plan.Execute(dstDir)
+		if err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		return run
+	}
+
+	first := copyRun("style 1", true)
+	if first == nil {
+		t.Fatal("Execute() returned no run")
+	}
+	if want := []string{"go/style.md", "stale"}; !reflect.DeepEqual(first.Saved, want) {
+		t.Errorf("Saved = %v, want %v", first.Saved, want)
+	}
+	afterFirst := snapshot(t, dst)
+
+	// Copying the same files again without cleaning changes nothing, so no run is recorded
+	if run := copyRun("style 1", false); run != nil {
+		t.Errorf("Execute() = run %s, want none for an unchanged destination", run.ID)
+	}
+
+	second := copyRun("style 2", true)
+	third := copyRun("style 3", true)
+	runs, err := Runs(dstDir)
+	if err != nil {
+		t.Fatalf("Runs() error = %v", err)
+	}
+	var ids []string
+	for _, run := range runs {
+		ids = append(ids, run.ID)
+	}
+	// The first run is pruned, since only two are kept
+	if want := []string{second.ID, third.ID}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("Runs() = %v, want %v", ids, want)
+	}
+
+	// Undoing the latest run goes back to the second one
+	undone, err := Undo(dstDir, "")
+	if err != nil {
+		t.Fatalf("Undo() error = %v", err)
+	}
+	if len(undone) != 1 || undone[0].ID != third.ID {
+		t.Errorf("Undo() = %v, want run %s", undone, third.ID)
+	}
+	data, err := os.ReadFile(filepath.Join(dstDir, "go", "style.md"))
+	if err != nil || string(data) != "style 2" {
+		t.Errorf("go/style.md after undo = %q, %v, want %q", data, err, "style 2")
+	}
+
+	// Undoing a run undoes the runs after it too
+	third = copyRun("style 3", true)
+	undone, err = Undo(dstDir, second.ID)
+	if err != nil {
+		t.Fatalf("Undo(%s) error = %v", second.ID, err)
+	}
+	if len(undone) != 2 || undone[0].ID != third.ID || undone[1].ID != second.ID {
+		t.Errorf("Undo(%s) = %v, want runs %s and %s", second.ID, undone, third.ID, second.ID)
+	}
+	if got := withoutBackups(snapshot(t, dst)); !reflect.DeepEqual(got, withoutBackups(afterFirst)) {
+		t.Errorf("destination after undo = %v, want %v", got, afterFirst)
+	}
+
+	if _, err := Undo(dstDir, ""); err == nil {
+		t.Error("Undo() should fail without runs")
+	}
+	if _, err := Undo(dstDir, first.ID); err == nil {
+		t.Errorf("Undo(%s) should fail for a pruned run", first.ID)
+	}
+	if got := withoutBackups(snapshot(t, dst)); reflect.DeepEqual(got, original) {
+		t.Error("pruned run was undone")
+	}
+}
+
+// TestBackupFailedCopy tests that a failed copy records no run
+func TestBackupFailedCopy(t *testing.T) {
+	dstDir := filepath.Join(t.TempDir(), "rules")
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dstDir, "zz.md"), []byte("old zz"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := failingFS{
+		MapFS: fstest.MapFS{"zz.md": {Data: []byte("zz"), Mode: 0644}},
+		fail:  "zz.md",
+	}
+
+	if err := CopyItems([]Item{{Root: "/rules", Path: "zz.md", FS: src}}, dstDir, Options{Clean: true, KeepBackups: 1}); err == nil {
+		t.Fatal("CopyItems() should fail")
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, ".airule")); !os.IsNotExist(err) {
+		t.Errorf("backup area left by a failed copy: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dstDir, "zz.md"))
+	if err != nil || string(data) != "old zz" {
+		t.Errorf("zz.md after failure = %q, %v, want %q", data, err, "old zz")
+	}
+}
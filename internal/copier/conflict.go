@@ -0,0 +1,196 @@
+package copier
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/upamune/airule/internal/content"
+	"github.com/upamune/airule/internal/linkfs"
+	"github.com/upamune/airule/internal/merge"
+	"github.com/upamune/airule/internal/writefs"
+)
+
+// BackupSuffix is appended to the path of an existing file saved before it is overwritten
+const BackupSuffix = ".bak"
+
+// ConflictPolicy decides what happens to an existing destination file that a copy would
+// replace with different content
+type ConflictPolicy int
+
+const (
+	// ConflictOverwrite replaces the existing file
+	ConflictOverwrite ConflictPolicy = iota
+	// ConflictSkip leaves the existing file as it is
+	ConflictSkip
+	// ConflictBackup saves the existing file with BackupSuffix before replacing it
+	ConflictBackup
+	// ConflictNewer replaces the existing file only if the source was modified after it
+	ConflictNewer
+	// ConflictFail fails the copy before anything is changed
+	ConflictFail
+)
+
+// ParseConflictPolicy parses "overwrite", "skip", "backup", "newer" or "fail"
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch s {
+	case "", "overwrite":
+		return ConflictOverwrite, nil
+	case "skip":
+		return ConflictSkip, nil
+	case "backup":
+		return ConflictBackup, nil
+	case "newer":
+		return ConflictNewer, nil
+	case "fail":
+		return ConflictFail, nil
+	}
+	return ConflictOverwrite, fmt.Errorf("invalid conflict policy %q: expected overwrite, skip, backup, newer or fail", s)
+}
+
+// String returns the policy name
+func (p ConflictPolicy) String() string {
+	switch p {
+	case ConflictSkip:
+		return "skip"
+	case ConflictBackup:
+		return "backup"
+	case ConflictNewer:
+		return "newer"
+	case ConflictFail:
+		return "fail"
+	default:
+		return "overwrite"
+	}
+}
+
+// Conflict is an existing destination path that a copy would replace with different content
+type Conflict struct {
+	// Path is the slash-separated path relative to the destination root
+	Path string
+	// Source is the path it is copied from
+	Source string
+
+	action Action
+	dst    writefs.FS
+}
+
+// Diff returns the changes the copy makes to the file as unified diff hunks. It returns
+// nothing for links, directories and binary files.
+func (c Conflict) Diff() ([]byte, error) {
+	if c.action.Type != TypeFile {
+		return nil, nil
+	}
+	info, err := c.dst.Lstat(c.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check %s: %w", c.Path, err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, nil
+	}
+	current, err := fs.ReadFile(c.dst, c.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", c.Path, err)
+	}
+	next, err := fs.ReadFile(c.action.src, c.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", c.Source, err)
+	}
+	if content.IsBinary(current) || content.IsBinary(next) {
+		return nil, nil
+	}
+	return merge.Diff(current, next), nil
+}
+
+// planConflicts applies the conflict policy of opts to the writes replacing existing paths
+// with different content, asking opts.ResolveConflict when it is set. Edited files are left
+// to the local edit policy, and paths still as airule installed them according to the previous
+// manifest are updated without asking. With ConflictFail, it returns an error listing the conflicts.
+func planConflicts(dst writefs.FS, prev *Manifest, actions []Action, opts Options) error {
+	var failed []string
+	for i := range actions {
+		a := &actions[i]
+		if a.Kind != ActionOverwrite || a.Type == TypeDir || a.Edited {
+			continue
+		}
+		if e, ok := prev.Entry(a.Path); ok {
+			managed, err := intact(dst, e)
+			if err != nil {
+				return err
+			}
+			if managed {
+				continue
+			}
+		}
+
+		policy := opts.OnConflict
+		if opts.ResolveConflict != nil {
+			policy = opts.ResolveConflict(Conflict{Path: a.Path, Source: a.Source, action: *a, dst: dst})
+		}
+		a.Conflict = true
+		a.Resolution = policy.String()
+
+		switch policy {
+		case ConflictSkip:
+			a.Kind = ActionKeep
+		case ConflictNewer:
+			newer, err := sourceNewer(dst, *a)
+			if err != nil {
+				return err
+			}
+			if !newer {
+				a.Kind = ActionKeep
+			}
+		case ConflictFail:
+			failed = append(failed, a.Path)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d path(s) already exist in the destination with different content: %s",
+			len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// sourceNewer reports whether the source of a was modified after the path it replaces
+func sourceNewer(dst writefs.FS, a Action) (bool, error) {
+	srcInfo, err := linkfs.Lstat(a.src, a.Path)
+	if err != nil {
+		return false, fmt.Errorf("failed to get file info for %s: %w", a.Source, err)
+	}
+	if a.Type == TypeFile {
+		// A followed link is compared by the file it points to
+		if srcInfo, err = fs.Stat(a.src, a.Path); err != nil {
+			return false, fmt.Errorf("failed to get file info for %s: %w", a.Source, err)
+		}
+	}
+	dstInfo, err := dst.Lstat(a.Path)
+	if err != nil {
+		return false, fmt.Errorf("failed to check %s: %w", a.Path, err)
+	}
+	return srcInfo.ModTime().After(dstInfo.ModTime()), nil
+}
+
+// saveCopy copies the file or link name to name with suffix, recording the change in j first
+func saveCopy(dst writefs.FS, name, suffix string, j *journal) error {
+	info, err := dst.Lstat(name)
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", name, err)
+	}
+	saved := name + suffix
+	if err := j.prepare(saved, false); err != nil {
+		return err
+	}
+	if linkfs.IsLink(info.Mode()) {
+		target, err := dst.ReadLink(name)
+		if err != nil {
+			return fmt.Errorf("failed to read link %s: %w", name, err)
+		}
+		return writeLink(dst, saved, target)
+	}
+	data, err := fs.ReadFile(dst, name)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return writeData(dst, saved, data, info.Mode().Perm())
+}
@@ -0,0 +1,183 @@
+package copier
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/upamune/airule/internal/memfs"
+)
+
+// TestConflicts tests the policies applied to existing destination files
+func TestConflicts(t *testing.T) {
+	now := time.Now()
+	src := fstest.MapFS{
+		"old.md":  {Data: []byte("old source"), Mode: 0644, ModTime: now.Add(-time.Hour)},
+		"new.md":  {Data: []byte("new source"), Mode: 0644, ModTime: now.Add(time.Hour)},
+		"same.md": {Data: []byte("same"), Mode: 0644},
+		"add.md":  {Data: []byte("added"), Mode: 0644},
+	}
+	var items []Item
+	for _, name := range []string{"add.md", "new.md", "old.md", "same.md"} {
+		items = append(items, Item{Root: "/rules", Path: name, FS: src})
+	}
+
+	tests := []struct {
+		name      string
+		opts      Options
+		want      map[string]string
+		wantNotes []string
+		wantErr   string
+	}{
+		{
+			name:      "Overwrite",
+			opts:      Options{OnConflict: ConflictOverwrite},
+			want:      map[string]string{"old.md": "old source", "new.md": "new source"},
+			wantNotes: []string{"new.md: exists, overwritten", "old.md: exists, overwritten"},
+		},
+		{
+			name:      "Skip",
+			opts:      Options{OnConflict: ConflictSkip},
+			want:      map[string]string{"old.md": "old dest", "new.md": "new dest"},
+			wantNotes: []string{"new.md: exists, skipped", "old.md: exists, skipped"},
+		},
+		{
+			name: "Backup",
+			opts: Options{OnConflict: ConflictBackup},
+			want: map[string]string{
+				"old.md": "old source", "old.md.bak": "old dest",
+				"new.md": "new source", "new.md.bak": "new dest",
+			},
+			wantNotes: []string{"new.md: exists, saved as new.md.bak", "old.md: exists, saved as old.md.bak"},
+		},
+		{
+			name:      "Newer",
+			opts:      Options{OnConflict: ConflictNewer},
+			want:      map[string]string{"old.md": "old dest", "new.md": "new source"},
+			wantNotes: []string{"new.md: exists, source is newer", "old.md: exists, destination is newer"},
+		},
+		{
+			name:    "Fail",
+			opts:    Options{OnConflict: ConflictFail},
+			want:    map[string]string{"old.md": "old dest", "new.md": "new dest"},
+			wantErr: "2 path(s) already exist in the destination with different content: new.md, old.md",
+		},
+		{
+			name: "Resolved per file",
+			opts: Options{ResolveConflict: func(c Conflict) ConflictPolicy {
+				if c.Path == "new.md" {
+					return ConflictSkip
+				}
+				return ConflictOverwrite
+			}},
+			want:      map[string]string{"old.md": "old source", "new.md": "new dest"},
+			wantNotes: []string{"new.md: exists, skipped", "old.md: exists, overwritten"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := memfs.New()
+			for name, content := range map[string]string{"old.md": "old dest", "new.md": "new dest", "same.md": "same"} {
+				if err := dst.WriteFile(name, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			plan, err := PlanItemsTo(items, dst, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("PlanItemsTo() error = %v, want %q", err, tt.wantErr)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("PlanItemsTo() error = %v", err)
+				}
+				var notes []string
+				for _, a := range plan.Conflicts() {
+					notes = append(notes, a.Path+": "+a.Note())
+				}
+				if !reflect.DeepEqual(notes, tt.wantNotes) {
+					t.Errorf("Conflicts() = %v, want %v", notes, tt.wantNotes)
+				}
+				if err := plan.ExecuteTo(dst, memfs.New()); err != nil {
+					t.Fatalf("ExecuteTo() error = %v", err)
+				}
+				tt.want["add.md"] = "added"
+			}
+
+			tt.want["same.md"] = "same"
+			if got := snapshot(t, dst); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("destination = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestConflictsManaged tests that updating files still as airule installed them is not a conflict
+func TestConflictsManaged(t *testing.T) {
+	src := fstest.MapFS{
+		"a.md": {Data: []byte("a"), Mode: 0644},
+		"b.md": {Data: []byte("b"), Mode: 0644},
+	}
+	item := func(name string) Item { return Item{Root: "/rules", Path: name, FS: src} }
+	opts := Options{OnConflict: ConflictFail, Manifest: true}
+
+	dst := memfs.New()
+	if err := CopyItemsTo([]Item{item("a.md")}, dst, opts); err != nil {
+		t.Fatalf("CopyItemsTo() error = %v", err)
+	}
+	src["a.md"] = &fstest.MapFile{Data: []byte("a updated"), Mode: 0644}
+
+	// b.md was not installed by airule, so it still conflicts
+	if err := dst.WriteFile("b.md", []byte("my b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := PlanItemsTo([]Item{item("a.md"), item("b.md")}, dst, opts)
+	if want := "1 path(s) already exist in the destination with different content: b.md"; err == nil || err.Error() != want {
+		t.Fatalf("PlanItemsTo() error = %v, want %q", err, want)
+	}
+
+	plan, err := PlanItemsTo([]Item{item("a.md")}, dst, opts)
+	if err != nil {
+		t.Fatalf("PlanItemsTo() error = %v", err)
+	}
+	if conflicts := plan.Conflicts(); len(conflicts) != 0 {
+		t.Errorf("Conflicts() = %+v, want none", conflicts)
+	}
+	if err := plan.ExecuteTo(dst, memfs.New()); err != nil {
+		t.Fatalf("ExecuteTo() error = %v", err)
+	}
+	got := withoutBackups(snapshot(t, dst))
+	if want := map[string]string{"a.md": "a updated", "b.md": "my b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("destination = %v, want %v", got, want)
+	}
+}
+
+// TestConflictDiff tests the diff shown for a conflict
+func TestConflictDiff(t *testing.T) {
+	src := fstest.MapFS{"style.md": {Data: []byte("# Style\nWrap at 80.\n"), Mode: 0644}}
+	dst := memfs.New()
+	if err := dst.WriteFile("style.md", []byte("# Style\nWrap at 120.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var diff string
+	_, err := PlanItemsTo([]Item{{Root: "/rules", Path: "style.md", FS: src}}, dst, Options{
+		ResolveConflict: func(c Conflict) ConflictPolicy {
+			data, err := c.Diff()
+			if err != nil {
+				t.Errorf("Diff() error = %v", err)
+			}
+			diff = string(data)
+			return ConflictSkip
+		},
+	})
+	if err != nil {
+		t.Fatalf("PlanItemsTo() error = %v", err)
+	}
+	if want := "@@ -1,2 +1,2 @@\n # Style\n-Wrap at 120.\n+Wrap at 80.\n"; diff != want {
+		t.Errorf("Diff() = %q, want %q", diff, want)
+	}
+}
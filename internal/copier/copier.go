@@ -1,147 +1,78 @@
 package copier
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
-	"sort"
 	"strings"
+
+	"github.com/upamune/airule/internal/linkfs"
+	"github.com/upamune/airule/internal/memfs"
+	"github.com/upamune/airule/internal/pattern"
+	"github.com/upamune/airule/internal/writefs"
 )
 
 // matchesAnyPattern checks if a file path matches any of the provided patterns
 func matchesAnyPattern(filePath string, patterns []string) bool {
-	for _, pattern := range patterns {
-		// Match against the full path or just the basename if the pattern doesn't contain a separator
-		base := filepath.Base(filePath)
-		matchPath, _ := filepath.Match(pattern, filePath)
-		matchBase := false
-		if !strings.Contains(pattern, string(filepath.Separator)) {
-			matchBase, _ = filepath.Match(pattern, base)
-		}
-		if matchPath || matchBase {
-			return true
-		}
-
-		// Handle directory patterns specifically (e.g., "dir/*" or "dir/**")
-		if strings.HasSuffix(pattern, "/*") || strings.HasSuffix(pattern, "/**") {
-			dirPattern := strings.TrimSuffix(strings.TrimSuffix(pattern, "*"), "/")
-			// Ensure dirPattern is not empty and path actually starts with it + separator
-			if dirPattern != "" && strings.HasPrefix(filePath, dirPattern+string(filepath.Separator)) {
-				return true
-			}
-			// Also handle case where the pattern *is* the directory path itself
-			if filePath == dirPattern {
-				return true
-			}
-		}
-
-		// Handle glob patterns with filepath.Match for more complex patterns
-		if strings.Contains(pattern, "*") {
-			matched, _ := filepath.Match(pattern, filePath)
-			if matched {
-				return true
-			}
-		}
-	}
-	return false
+	return pattern.MatchAny(filePath, patterns)
 }
 
-// checkPreservationRecursiveWithBase is the internal implementation that tracks the base directory
-func checkPreservationRecursiveWithBase(path, baseDir string, excludePatterns []string) (bool, error) {
-	info, err := os.Lstat(path) // Use Lstat to handle symlinks if they were ever supported
+// checkPreservationRecursive reports whether name in the destination file system must be preserved
+// while cleaning. name is a slash-separated path relative to the destination root.
+func checkPreservationRecursive(dst writefs.FS, name string, excludePatterns []string) (bool, error) {
+	info, err := dst.Lstat(name) // Use Lstat to handle symlinks if they were ever supported
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, fs.ErrNotExist) {
 			return false, nil // Path doesn't exist, definitely not preserved
 		}
 		return false, err // Other stat error
 	}
 
-	// Calculate relative path for pattern matching
-	var relPath string
-	if baseDir != "" {
-		var err error
-		relPath, err = filepath.Rel(baseDir, path)
-		if err != nil {
-			relPath = path // Fallback to absolute path
-		}
-	} else {
-		relPath = path
-	}
-
 	// 1. Check if the item itself is hidden or matches exclude patterns
-	name := filepath.Base(path)
+	base := path.Base(name)
 	isDir := info.IsDir()
-	isHidden := len(name) > 0 && name[0] == '.'
-	matchesExclusion := matchesAnyPattern(relPath, excludePatterns)
+	isHidden := len(base) > 0 && base[0] == '.'
+	matchesExclusion := matchesAnyPattern(name, excludePatterns)
 
 	if isHidden || matchesExclusion {
 		return true, nil // Item itself should be preserved
 	}
 
 	// 2. Check if any parent directory is hidden or matches exclude patterns
-	// But skip the base directory itself (the directory we're cleaning)
-	currentPath := path
-	for {
-		parent := filepath.Dir(currentPath)
-		if parent == currentPath || parent == "." || parent == "/" || parent == baseDir {
-			break
-		}
-
-		// Calculate relative path for parent
-		var parentRelPath string
-		if baseDir != "" {
-			var err error
-			parentRelPath, err = filepath.Rel(baseDir, parent)
-			if err != nil {
-				parentRelPath = parent // Fallback to absolute path
-			}
-		} else {
-			parentRelPath = parent
-		}
-
-		parentName := filepath.Base(parent)
+	// But skip the root itself (the directory we're cleaning)
+	for parent := path.Dir(name); parent != "."; parent = path.Dir(parent) {
+		parentName := path.Base(parent)
 		parentIsHidden := len(parentName) > 0 && parentName[0] == '.'
-		parentMatchesExclusion := matchesAnyPattern(parentRelPath, excludePatterns)
+		parentMatchesExclusion := matchesAnyPattern(parent, excludePatterns)
 
 		if parentIsHidden || parentMatchesExclusion {
 			return true, nil // Parent directory should be preserved, so this item should too
 		}
-
-		currentPath = parent
 	}
 
 	// 3. If it's a directory, check if any exclusion pattern would match files inside this directory
 	if isDir {
-		for _, pattern := range excludePatterns {
-			// Check if this is a directory pattern (e.g., "dir/*" or "dir/**")
-			if strings.HasSuffix(pattern, "/*") || strings.HasSuffix(pattern, "/**") {
-				dirPattern := strings.TrimSuffix(strings.TrimSuffix(pattern, "*"), "/")
-				if relPath == dirPattern {
-					return true, nil // This directory is targeted by a wildcard pattern
-				}
-			}
-
-			// Check if any pattern targets files in this directory (e.g., "config/*.json")
-			if strings.Contains(pattern, string(filepath.Separator)) && strings.Contains(pattern, "*") {
-				patternDir := filepath.Dir(pattern)
-				if relPath == patternDir {
-					return true, nil // This directory contains files that match the pattern
-				}
+		for _, p := range excludePatterns {
+			// Check if any pattern targets files in this directory (e.g., "config/*.json" or "rules/**/*.md")
+			if pattern.MatchesUnder(p, name) {
+				return true, nil
 			}
 		}
 
 		// Check its contents recursively
-		entries, err := os.ReadDir(path)
+		entries, err := dst.ReadDir(name)
 		if err != nil {
 			// Handle cases like permission denied reading directory
 			// If we can't read it, err on the side of caution and preserve it
 			return true, nil
 		}
 		for _, entry := range entries {
-			childPath := filepath.Join(path, entry.Name())
 			// Recursively check child. If any child needs preservation, this dir needs it too.
-			preserveChild, err := checkPreservationRecursiveWithBase(childPath, baseDir, excludePatterns)
+			preserveChild, err := checkPreservationRecursive(dst, path.Join(name, entry.Name()), excludePatterns)
 			if err != nil {
 				return false, err // Propagate error from recursive call
 			}
@@ -159,87 +90,107 @@ func checkPreservationRecursiveWithBase(path, baseDir string, excludePatterns []
 // while preserving files/directories that are hidden or match exclude patterns,
 // including items nested within directories and the parent directories needed to hold them.
 func clearDestinationDir(dir string, excludePatterns []string) error {
-	_, err := os.Stat(dir)
+	return clearDestination(writefs.Dir(dir), excludePatterns)
+}
+
+// clearDestination is clearDestinationDir for any writable file system
+func clearDestination(dst writefs.FS, excludePatterns []string) error {
+	removals, err := planRemovals(dst, excludePatterns, nil)
 	if err != nil {
-		if os.IsNotExist(err) {
-			// Directory doesn't exist, create it
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				return fmt.Errorf("failed to create destination directory: %w", err)
-			}
-			return nil
-		}
-		// Some other error occurred
-		return fmt.Errorf("failed to check destination directory: %w", err)
+		return err
 	}
+	return removePaths(dst, removals)
+}
 
-	// Use filepath.WalkDir to traverse the directory.
-	// We need to remove items *after* traversing their children if the parent directory
-	// itself doesn't need preservation but some children do. This is tricky with WalkDir.
-	// A simpler approach might be to collect all paths to potentially remove first,
-	// then iterate through them and check preservation *again* before removing.
+// planRemovals returns the paths in the destination that cleaning removes, sorted by path.
+// It returns nothing when the destination does not exist yet.
+// Paths in keep, and the directories holding them, are not removed.
+func planRemovals(dst writefs.FS, excludePatterns []string, keep map[string]bool) ([]string, error) {
+	if _, err := dst.Stat("."); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to check destination directory: %w", err)
+	}
 
-	pathsToRemove := []string{}
-	err = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+	var removals []string
+	err := fs.WalkDir(dst, ".", func(name string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err // Propagate walk errors
 		}
 		// Skip root
-		if path == dir {
+		if name == "." {
+			return nil
+		}
+
+		if keep[name] || (d.IsDir() && keepsBelow(name, keep)) {
 			return nil
 		}
 
-		// Tentatively add all paths for removal check later
-		pathsToRemove = append(pathsToRemove, path)
+		// Whether a path is preserved does not depend on the removal of other paths,
+		// since a directory holding anything preserved is preserved itself
+		preserve, err := checkPreservationRecursive(dst, name, excludePatterns)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: error checking preservation for %s, skipping removal: %v\n", name, err)
+			return nil
+		}
+		if !preserve {
+			removals = append(removals, name)
+			if d.IsDir() {
+				return fs.SkipDir // Everything inside goes with it
+			}
+		}
 		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("error walking destination directory %s: %w", dir, err)
+		return nil, fmt.Errorf("error walking destination directory: %w", err)
 	}
+	return removals, nil
+}
 
-	// Sort paths in reverse order so children are processed before parents
-	sort.Slice(pathsToRemove, func(i, j int) bool {
-		return len(pathsToRemove[i]) > len(pathsToRemove[j])
-	})
-
-	// Now, check each path for preservation and remove if necessary
-	for _, path := range pathsToRemove {
-		// Check if the path still exists (might have been removed as part of a parent dir)
-		if _, err := os.Lstat(path); os.IsNotExist(err) {
-			continue // Already removed
+// keepsBelow reports whether a path in keep is inside the directory dir
+func keepsBelow(dir string, keep map[string]bool) bool {
+	for name := range keep {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
 		}
+	}
+	return false
+}
 
-		preserve, err := checkPreservationRecursiveWithBase(path, dir, excludePatterns)
-		if err != nil {
-			// Log or handle error during check, maybe skip removal?
-			fmt.Fprintf(os.Stderr, "Warning: error checking preservation for %s, skipping removal: %v\n", path, err)
-			continue
+// removePaths removes the paths returned by planRemovals, then makes sure the destination
+// directory exists with the expected permissions
+func removePaths(dst writefs.FS, removals []string) error {
+	if _, err := dst.Stat("."); errors.Is(err, fs.ErrNotExist) {
+		// Directory doesn't exist, create it
+		if err := dst.MkdirAll(".", 0755); err != nil {
+			return fmt.Errorf("failed to create destination directory: %w", err)
 		}
+		return nil
+	}
 
-		if !preserve {
-			// Attempt to remove. Use RemoveAll for directories.
-			if err := os.RemoveAll(path); err != nil {
-				// Log or handle error during removal
-				fmt.Fprintf(os.Stderr, "Warning: failed to remove %s: %v\n", path, err)
-				// Decide whether to continue or return error. Let's continue for now.
-			}
+	for _, name := range removals {
+		// Attempt to remove. Use RemoveAll for directories.
+		if err := dst.RemoveAll(name); err != nil {
+			// Report the failure and continue with the other paths
+			fmt.Fprintf(os.Stderr, "Warning: failed to remove %s: %v\n", name, err)
 		}
 	}
 
 	// Ensure the root directory still exists and has correct permissions
-	// (It shouldn't have been added to pathsToRemove, but double-check)
-	info, err := os.Stat(dir)
+	info, err := dst.Stat(".")
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, fs.ErrNotExist) {
 			// This is unexpected if removal logic is correct, recreate it.
-			fmt.Fprintf(os.Stderr, "Warning: destination directory %s was unexpectedly removed, recreating.\n", dir)
-			if err := os.MkdirAll(dir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: destination directory was unexpectedly removed, recreating.\n")
+			if err := dst.MkdirAll(".", 0755); err != nil {
 				return fmt.Errorf("failed to recreate destination directory: %w", err)
 			}
 		} else {
 			return fmt.Errorf("failed to stat destination directory after clear: %w", err)
 		}
 	} else if info.Mode().Perm() != 0755 {
-		if err := os.Chmod(dir, 0755); err != nil {
+		if err := dst.Chmod(".", 0755); err != nil {
 			return fmt.Errorf("failed to set directory permissions after clear: %w", err)
 		}
 	}
@@ -247,59 +198,129 @@ func clearDestinationDir(dir string, excludePatterns []string) error {
 	return nil
 }
 
+// Item is a file or directory to copy, identified by the root it is copied from
+// and its path relative to that root. The same relative path is used in the destination.
+type Item struct {
+	Root string
+	Path string
+	// FS is the file system holding the source tree. When nil, Root is read from disk.
+	FS fs.FS
+}
+
+// files returns the file system the item is read from
+func (item Item) files() fs.FS {
+	if item.FS != nil {
+		return item.FS
+	}
+	return linkfs.Dir(item.Root)
+}
+
+// diskRoot returns the absolute directory the item is read from, or "" if it is not read from disk
+func (item Item) diskRoot() string {
+	if item.FS != nil {
+		return ""
+	}
+	root, err := filepath.Abs(item.Root)
+	if err != nil {
+		return ""
+	}
+	return root
+}
+
+// Options configures how items are copied
+type Options struct {
+	// Clean clears the destination directory before copying, preserving hidden files
+	Clean bool
+	// CleanExclude are patterns of destination paths preserved while cleaning
+	CleanExclude []string
+	// CleanStrategy decides whether cleaning removes everything or only the files airule installed
+	CleanStrategy CleanStrategy
+	// Manifest records the files and links written in ManifestFile of the destination.
+	// It is implied by CleanManaged and EditMerge, which rely on it, and by a destination
+	// already holding a ManifestFile, which is kept up to date.
+	Manifest bool
+	// LocalEdits decides what happens to files edited in the destination since they were
+	// installed. Edits are only detected with a manifest.
+	LocalEdits EditPolicy
+	// ResolveEdit, when set, is called with the path of every edited file to decide its policy
+	// instead of LocalEdits
+	ResolveEdit func(name string) EditPolicy
+	// OnConflict decides what happens to existing destination paths replaced with different
+	// content, other than edited files
+	OnConflict ConflictPolicy
+	// ResolveConflict, when set, is called for every conflict to decide its policy instead of OnConflict
+	ResolveConflict func(c Conflict) ConflictPolicy
+	// Mode decides whether files are copied or installed as links to their source
+	Mode InstallMode
+	// Checksum compares the content of destination files whose size and modification time
+	// match the source as well, by SHA-256, instead of taking them as unchanged
+	Checksum bool
+	// Symlinks decides whether symbolic links are copied as their targets, recreated or skipped
+	Symlinks linkfs.Policy
+	// KeepBackups is the number of runs whose removed and replaced paths are kept in BackupDir
+	// of a destination directory, so that they can be undone. Zero keeps no backups.
+	KeepBackups int
+}
+
 // CopyFiles copies files from the source directory to the destination directory
 // If cleanDest is true, it will clear the destination directory before copying,
 // while preserving hidden files (those starting with a dot) and files matching cleanExcludePatterns.
 // If cleanDest is false, it will not clear the destination directory.
 func CopyFiles(fromDir, toDir string, relativePaths []string, cleanDest bool, cleanExcludePatterns []string) error {
-	// Clear the destination directory before copying if cleanDest is true
-	if cleanDest {
-		if err := clearDestinationDir(toDir, cleanExcludePatterns); err != nil {
-			return err
-		}
-	} else {
-		// Ensure the destination directory exists
-		if err := os.MkdirAll(toDir, 0755); err != nil {
-			return fmt.Errorf("failed to create destination directory: %w", err)
-		}
+	items := make([]Item, len(relativePaths))
+	for i, relPath := range relativePaths {
+		items[i] = Item{Root: fromDir, Path: relPath}
 	}
+	return CopyItems(items, toDir, Options{Clean: cleanDest, CleanExclude: cleanExcludePatterns})
+}
 
-	// Copy each file
-	for _, relPath := range relativePaths {
-		srcPath := filepath.Join(fromDir, relPath)
-		dstPath := filepath.Join(toDir, relPath)
-
-		// Get file info
-		info, err := os.Stat(srcPath)
-		if err != nil {
-			return fmt.Errorf("failed to get file info for %s: %w", srcPath, err)
-		}
+// CopyItems copies items, possibly from different roots, to the destination directory.
+// Items are copied in order, so a later item overwrites an earlier one with the same destination path.
+// If copying fails, the destination is left as it was.
+func CopyItems(items []Item, toDir string, opts Options) error {
+	plan, err := PlanItems(items, toDir, opts)
+	if err != nil {
+		return err
+	}
+	_, err = plan.Execute(toDir)
+	return err
+}
 
-		// Handle directories and files differently
-		if info.IsDir() {
-			if err := copyDir(srcPath, dstPath); err != nil {
-				return fmt.Errorf("failed to copy directory %s: %w", relPath, err)
-			}
-		} else {
-			if err := copyFile(srcPath, dstPath); err != nil {
-				return fmt.Errorf("failed to copy file %s: %w", relPath, err)
-			}
-		}
+// CopyItemsTo copies items to the root of a writable file system. Whatever is removed or
+// replaced is kept in memory until the copy is finished, and put back if it fails.
+func CopyItemsTo(items []Item, dst writefs.FS, opts Options) error {
+	plan, err := PlanItemsTo(items, dst, opts)
+	if err != nil {
+		return err
 	}
+	return plan.ExecuteTo(dst, memfs.New())
+}
 
+// writeLink creates a symbolic link at name in dst, replacing whatever is there
+func writeLink(dst writefs.FS, name, target string) error {
+	if err := dst.RemoveAll(name); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", name, err)
+	}
+	dstDir := path.Dir(name)
+	if err := dst.MkdirAll(dstDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dstDir, err)
+	}
+	if err := dst.Symlink(target, name); err != nil {
+		return fmt.Errorf("failed to create link: %w", err)
+	}
 	return nil
 }
 
-// copyFile copies a single file from src to dst
-func copyFile(src, dst string) error {
+// copyFile copies a single file from src to the same path in dst
+func copyFile(src fs.FS, dst writefs.FS, name string) error {
 	// Create destination directory if it doesn't exist
-	dstDir := filepath.Dir(dst)
-	if err := os.MkdirAll(dstDir, 0755); err != nil {
+	dstDir := path.Dir(name)
+	if err := dst.MkdirAll(dstDir, 0755); err != nil {
 		return fmt.Errorf("failed to create directory %s: %w", dstDir, err)
 	}
 
 	// Open source file
-	srcFile, err := os.Open(src)
+	srcFile, err := src.Open(name)
 	if err != nil {
 		return fmt.Errorf("failed to open source file: %w", err)
 	}
@@ -311,62 +332,59 @@ func copyFile(src, dst string) error {
 		return fmt.Errorf("failed to get source file info: %w", err)
 	}
 
+	// Replace a link left at the destination instead of writing through it
+	if info, err := dst.Lstat(name); err == nil && linkfs.IsLink(info.Mode()) {
+		if err := dst.RemoveAll(name); err != nil {
+			return fmt.Errorf("failed to remove link %s: %w", name, err)
+		}
+	}
+
 	// Create destination file
-	dstFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, srcInfo.Mode())
+	dstFile, err := dst.Create(name, srcInfo.Mode().Perm())
 	if err != nil {
 		return fmt.Errorf("failed to create destination file: %w", err)
 	}
-	defer dstFile.Close()
 
 	// Copy the content
 	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		dstFile.Close()
 		return fmt.Errorf("failed to copy file content: %w", err)
 	}
+	if err := dstFile.Close(); err != nil {
+		return fmt.Errorf("failed to write destination file: %w", err)
+	}
+	// Keep the modification time, so that the next run finds the file unchanged without reading it
+	if err := dst.Chtimes(name, srcInfo.ModTime(), srcInfo.ModTime()); err != nil {
+		return fmt.Errorf("failed to set modification time of %s: %w", name, err)
+	}
 
 	return nil
 }
 
-// copyDir copies a directory recursively from src to dst
-func copyDir(src, dst string) error {
+// copyDirEntry creates the directory name in dst with the permissions it has in src
+func copyDirEntry(src fs.FS, dst writefs.FS, name string) error {
+	// Replace a link left at the destination instead of writing through it
+	if info, err := dst.Lstat(name); err == nil && linkfs.IsLink(info.Mode()) {
+		if err := dst.RemoveAll(name); err != nil {
+			return fmt.Errorf("failed to remove link %s: %w", name, err)
+		}
+	}
+
 	// Create destination directory if it doesn't exist
-	if err := os.MkdirAll(dst, 0755); err != nil {
-		return fmt.Errorf("failed to create directory %s: %w", dst, err)
+	if err := dst.MkdirAll(name, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", name, err)
 	}
 
 	// Get source directory info for permissions
-	srcInfo, err := os.Stat(src)
+	srcInfo, err := fs.Stat(src, name)
 	if err != nil {
 		return fmt.Errorf("failed to get source directory info: %w", err)
 	}
 
 	// Set the same permissions on the destination directory
-	if err := os.Chmod(dst, srcInfo.Mode()); err != nil {
+	if err := dst.Chmod(name, srcInfo.Mode().Perm()); err != nil {
 		return fmt.Errorf("failed to set directory permissions: %w", err)
 	}
 
-	// Read directory entries
-	entries, err := os.ReadDir(src)
-	if err != nil {
-		return fmt.Errorf("failed to read directory: %w", err)
-	}
-
-	// Copy each entry
-	for _, entry := range entries {
-		srcPath := filepath.Join(src, entry.Name())
-		dstPath := filepath.Join(dst, entry.Name())
-
-		if entry.IsDir() {
-			// Recursively copy subdirectory
-			if err := copyDir(srcPath, dstPath); err != nil {
-				return err
-			}
-		} else {
-			// Copy file
-			if err := copyFile(srcPath, dstPath); err != nil {
-				return err
-			}
-		}
-	}
-
 	return nil
 }
@@ -1,12 +1,17 @@
 package copier
 
 import (
+	"io/fs"
 	"os"
 	"path/filepath"
 	"reflect"
 	"sort"
 	"strings"
 	"testing"
+	"testing/fstest"
+
+	"github.com/upamune/airule/internal/linkfs"
+	"github.com/upamune/airule/internal/memfs"
 )
 
 // setupTestDir creates a temporary test directory with both hidden and non-hidden files
@@ -652,3 +657,303 @@ func TestDefaultCleanExcludePattern(t *testing.T) {
 		}
 	}
 }
+
+// TestClearDestinationDirWithDoublestarExclusions tests that recursive clean-exclude
+// patterns preserve matching files at any depth together with their parent directories
+func TestClearDestinationDirWithDoublestarExclusions(t *testing.T) {
+	tempDir := t.TempDir()
+
+	files := []string{
+		"remove.txt",
+		"rules/keep.md",
+		"rules/go/keep.mdc",
+		"rules/go/remove.txt",
+		"other/deep/keep.md",
+	}
+	for _, file := range files {
+		filePath := filepath.Join(tempDir, file)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatalf("Failed to create directory for %s: %v", file, err)
+		}
+		if err := os.WriteFile(filePath, []byte("test content"), 0644); err != nil {
+			t.Fatalf("Failed to create file %s: %v", filePath, err)
+		}
+	}
+
+	if err := clearDestinationDir(tempDir, []string{"rules/**/*.{md,mdc}", "**/deep/*.md"}); err != nil {
+		t.Fatalf("clearDestinationDir failed: %v", err)
+	}
+
+	remainingFiles, err := listFiles(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to list remaining files: %v", err)
+	}
+
+	expectedFiles := []string{
+		"other",
+		"other/deep",
+		"other/deep/keep.md",
+		"rules",
+		"rules/go",
+		"rules/go/keep.mdc",
+		"rules/keep.md",
+	}
+	if !reflect.DeepEqual(remainingFiles, expectedFiles) {
+		t.Errorf("clearDestinationDir did not preserve files correctly: Got:  %v Want: %v", remainingFiles, expectedFiles)
+	}
+}
+
+// TestCopyItemsFromMultipleRoots tests that items are copied from their own roots
+// and that later items overwrite earlier ones with the same relative path
+func TestCopyItemsFromMultipleRoots(t *testing.T) {
+	tempDir := t.TempDir()
+	orgDir := filepath.Join(tempDir, "org")
+	teamDir := filepath.Join(tempDir, "team")
+	dstDir := filepath.Join(tempDir, "dst")
+
+	sourceFiles := map[string]string{
+		filepath.Join(orgDir, "common.md"):     "org common",
+		filepath.Join(orgDir, "go/style.md"):   "org style",
+		filepath.Join(orgDir, "go/testing.md"): "org testing",
+		filepath.Join(teamDir, "go/style.md"):  "team style",
+	}
+	for filePath, content := range sourceFiles {
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatalf("Failed to create directory for %s: %v", filePath, err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file %s: %v", filePath, err)
+		}
+	}
+
+	items := []Item{
+		{Root: orgDir, Path: "common.md"},
+		{Root: orgDir, Path: "go"},
+		{Root: teamDir, Path: "go"},
+	}
+	if err := CopyItems(items, dstDir, Options{Clean: true}); err != nil {
+		t.Fatalf("CopyItems failed: %v", err)
+	}
+
+	want := map[string]string{
+		"common.md":     "org common",
+		"go/style.md":   "team style",
+		"go/testing.md": "org testing",
+	}
+	for file, content := range want {
+		got, err := os.ReadFile(filepath.Join(dstDir, file))
+		if err != nil {
+			t.Errorf("Failed to read copied file %s: %v", file, err)
+			continue
+		}
+		if string(got) != content {
+			t.Errorf("File %s has incorrect content: got %q, want %q", file, string(got), content)
+		}
+	}
+}
+
+// TestCopyItemsFromFS tests copying files and directories from a non-disk file system
+func TestCopyItemsFromFS(t *testing.T) {
+	dstDir := filepath.Join(t.TempDir(), "dst")
+	fsys := fstest.MapFS{
+		"common.md":        {Data: []byte("common"), Mode: 0644},
+		"go/style.md":      {Data: []byte("style"), Mode: 0644},
+		"go/nested/run.sh": {Data: []byte("run"), Mode: 0755},
+	}
+
+	items := []Item{
+		{Root: "/srv/rules.tar.gz", Path: "common.md", FS: fsys},
+		{Root: "/srv/rules.tar.gz", Path: "go", FS: fsys},
+	}
+	if err := CopyItems(items, dstDir, Options{}); err != nil {
+		t.Fatalf("CopyItems failed: %v", err)
+	}
+
+	want := map[string]string{
+		"common.md":        "common",
+		"go/style.md":      "style",
+		"go/nested/run.sh": "run",
+	}
+	for file, content := range want {
+		got, err := os.ReadFile(filepath.Join(dstDir, file))
+		if err != nil {
+			t.Errorf("Failed to read copied file %s: %v", file, err)
+			continue
+		}
+		if string(got) != content {
+			t.Errorf("File %s has incorrect content: got %q, want %q", file, string(got), content)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(dstDir, "go/nested/run.sh"))
+	if err != nil {
+		t.Fatalf("Failed to stat copied file: %v", err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("copied file mode = %v, want 0755", info.Mode().Perm())
+	}
+}
+
+// TestCopyItemsToMemFS tests cleaning and copying into an in-memory destination
+func TestCopyItemsToMemFS(t *testing.T) {
+	src := fstest.MapFS{
+		"go/style.md":   {Data: []byte("new style"), Mode: 0644},
+		"go/testing.md": {Data: []byte("testing"), Mode: 0644},
+	}
+
+	dst := memfs.New()
+	existing := map[string]string{
+		"go/style.md":          "old style",
+		"go/removed.md":        "removed",
+		"stale/old.md":         "stale",
+		".gitkeep":             "",
+		"config/local.json":    "{}",
+		"config/ignored.md":    "ignored",
+		".hidden/settings.txt": "settings",
+	}
+	for name, content := range existing {
+		if err := dst.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	items := []Item{{Root: "/srv/rules", Path: "go", FS: src}}
+	if err := CopyItemsTo(items, dst, Options{Clean: true, CleanExclude: []string{"config/*.json"}}); err != nil {
+		t.Fatalf("CopyItemsTo failed: %v", err)
+	}
+
+	got := make(map[string]string)
+	err := fs.WalkDir(dst, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := dst.ReadFile(name)
+		got[name] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk destination: %v", err)
+	}
+
+	want := map[string]string{
+		"go/style.md":          "new style",
+		"go/testing.md":        "testing",
+		".gitkeep":             "",
+		"config/local.json":    "{}",
+		".hidden/settings.txt": "settings",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("destination = %v, want %v", got, want)
+	}
+}
+
+// TestCopyItemsSymlinks tests copying symbolic links with each link policy
+func TestCopyItemsSymlinks(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "src")
+	files := map[string]string{
+		filepath.Join(srcDir, "shared/go.md"): "go",
+		filepath.Join(tempDir, "outside.md"):  "outside",
+	}
+	for filePath, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatalf("Failed to create directory for %s: %v", filePath, err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file %s: %v", filePath, err)
+		}
+	}
+	links := map[string]string{
+		"rules/go.md":      "../shared/go.md",
+		"rules/shared":     "../shared",
+		"rules/loop":       "..",
+		"rules/outside.md": "../../outside.md",
+		"rules/broken.md":  "missing.md",
+	}
+	for name, target := range links {
+		linkPath := filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(linkPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory for %s: %v", name, err)
+		}
+		if err := os.Symlink(target, linkPath); err != nil {
+			t.Skipf("symbolic links are not supported: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		policy linkfs.Policy
+		want   map[string]string
+	}{
+		{
+			name:   "follow copies link targets",
+			policy: linkfs.Follow,
+			want: map[string]string{
+				"rules/go.md":        "go",
+				"rules/shared/go.md": "go",
+				"rules/outside.md":   "outside",
+			},
+		},
+		{
+			name:   "preserve recreates links",
+			policy: linkfs.Preserve,
+			want: map[string]string{
+				"rules/go.md":      "-> ../shared/go.md",
+				"rules/shared":     "-> ../shared",
+				"rules/loop":       "-> ..",
+				"rules/outside.md": "-> " + filepath.Join(tempDir, "outside.md"),
+				"rules/broken.md":  "-> missing.md",
+			},
+		},
+		{
+			name:   "skip omits links",
+			policy: linkfs.Skip,
+			want:   map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := memfs.New()
+			items := []Item{{Root: srcDir, Path: "rules"}}
+			if err := CopyItemsTo(items, dst, Options{Symlinks: tt.policy}); err != nil {
+				t.Fatalf("CopyItemsTo failed: %v", err)
+			}
+
+			got := make(map[string]string)
+			err := fs.WalkDir(dst, ".", func(name string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				if d.Type()&fs.ModeSymlink != 0 {
+					target, err := dst.ReadLink(name)
+					got[name] = "-> " + target
+					return err
+				}
+				data, err := dst.ReadFile(name)
+				got[name] = string(data)
+				return err
+			})
+			if err != nil {
+				t.Fatalf("Failed to walk destination: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("destination = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCopyLinkOutsideFS tests that a link escaping a non-disk source cannot be preserved
+func TestCopyLinkOutsideFS(t *testing.T) {
+	src := memfs.New()
+	if err := src.Symlink("../../etc/passwd", "rules/passwd"); err != nil {
+		t.Fatal(err)
+	}
+
+	items := []Item{{Root: "/srv/rules.tar", Path: "rules/passwd", FS: src}}
+	err := CopyItemsTo(items, memfs.New(), Options{Symlinks: linkfs.Preserve})
+	if err == nil || !strings.Contains(err.Error(), "points outside the source") {
+		t.Errorf("CopyItemsTo() error = %v, want a link outside the source error", err)
+	}
+}
@@ -0,0 +1,214 @@
+package copier
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+
+	"github.com/upamune/airule/internal/merge"
+	"github.com/upamune/airule/internal/writefs"
+)
+
+// BaseDir is the directory in the destination holding the content of every installed file as
+// airule wrote it, the base of three-way merges. It is only written along with the manifest,
+// and it is hidden, so cleaning never removes it.
+const BaseDir = ".airule/base"
+
+// OrigSuffix is appended to the path of a locally edited file saved before it is overwritten
+const OrigSuffix = ".orig"
+
+// EditPolicy decides what happens to a file edited in the destination since airule installed it
+type EditPolicy int
+
+const (
+	// EditOverwrite replaces the edited file, losing the edits
+	EditOverwrite EditPolicy = iota
+	// EditKeep leaves the edited file as it is
+	EditKeep
+	// EditOrig saves the edited file with OrigSuffix before replacing it
+	EditOrig
+	// EditMerge merges the edits with the changes made in the source since the file was installed
+	EditMerge
+)
+
+// ParseEditPolicy parses "overwrite", "keep", "orig" or "merge"
+func ParseEditPolicy(s string) (EditPolicy, error) {
+	switch s {
+	case "", "overwrite":
+		return EditOverwrite, nil
+	case "keep":
+		return EditKeep, nil
+	case "orig":
+		return EditOrig, nil
+	case "merge":
+		return EditMerge, nil
+	}
+	return EditOverwrite, fmt.Errorf("invalid local edit policy %q: expected overwrite, keep, orig or merge", s)
+}
+
+// String returns the policy name
+func (p EditPolicy) String() string {
+	switch p {
+	case EditKeep:
+		return "keep"
+	case EditOrig:
+		return "orig"
+	case EditMerge:
+		return "merge"
+	default:
+		return "overwrite"
+	}
+}
+
+// baseName returns the path of the base of an installed file
+func baseName(name string) string {
+	return path.Join(BaseDir, name)
+}
+
+// edited reports whether the file written by a was changed in the destination since airule
+// installed it: it differs from the checksum in the manifest and from the new content alike
+func edited(dst writefs.FS, prev *Manifest, a Action) (bool, error) {
+	entry, ok := prev.Entry(a.Path)
+	if !ok || entry.Type != TypeFile || a.Type != TypeFile {
+		return false, nil
+	}
+	info, err := dst.Lstat(a.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check %s: %w", a.Path, err)
+	}
+	if !info.Mode().IsRegular() {
+		return false, nil
+	}
+	sum, err := checksum(dst, a.Path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", a.Path, err)
+	}
+	return sum != entry.SHA256 && !sameContent(a.src, dst, a.Path, info.Size()), nil
+}
+
+// planEdits finds the writes replacing files edited since they were installed and resolves
+// them with the policy of opts, asking opts.ResolveEdit when it is set. It returns the
+// edited paths that must survive cleaning.
+func planEdits(dst writefs.FS, prev *Manifest, writes []Action, opts Options) (map[string]bool, error) {
+	keep := make(map[string]bool)
+	for i := range writes {
+		a := &writes[i]
+		ok, err := edited(dst, prev, *a)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		policy := opts.LocalEdits
+		if opts.ResolveEdit != nil {
+			policy = opts.ResolveEdit(a.Path)
+		}
+		a.Edited = true
+		a.Resolution = policy.String()
+		if policy != EditOverwrite {
+			keep[a.Path] = true
+		}
+
+		switch policy {
+		case EditKeep:
+			a.Kind = ActionKeep
+		case EditMerge:
+			local, err := fs.ReadFile(dst, a.Path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", a.Path, err)
+			}
+			other, err := fs.ReadFile(a.src, a.Path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", a.Source, err)
+			}
+			// Without a base every difference is a conflict
+			base, err := fs.ReadFile(dst, baseName(a.Path))
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("failed to read base of %s: %w", a.Path, err)
+			}
+			a.data, a.Conflicts = merge.Merge(base, local, other)
+			a.Hardlink = false // The merged content is not the source file
+		}
+	}
+	return keep, nil
+}
+
+// writeBases writes the base of every file the plan installs and removes the bases of files
+// no longer in the manifest, recording the changes in j first. Unchanged bases are left alone.
+func (p *Plan) writeBases(dst writefs.FS, j *journal) error {
+	for _, a := range p.Actions {
+		if a.Type != TypeFile || a.Kind == ActionRemove || a.Kind == ActionKeep {
+			continue
+		}
+		data, err := fs.ReadFile(a.src, a.Path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", a.Source, err)
+		}
+		name := baseName(a.Path)
+		if old, err := fs.ReadFile(dst, name); err == nil && bytes.Equal(old, data) {
+			continue
+		}
+		if err := j.prepare(name, false); err != nil {
+			return err
+		}
+		if err := writeData(dst, name, data, 0644); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range p.staleBases {
+		if _, err := dst.Lstat(baseName(name)); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err := j.prepare(baseName(name), false); err != nil {
+			return err
+		}
+		if err := dst.RemoveAll(baseName(name)); err != nil {
+			return fmt.Errorf("failed to remove base of %s: %w", name, err)
+		}
+		// Remove the directories left empty
+		for dir := path.Dir(baseName(name)); dir != BaseDir; dir = path.Dir(dir) {
+			if entries, err := dst.ReadDir(dir); err != nil || len(entries) > 0 {
+				break
+			}
+			if err := j.prepare(dir, false); err != nil {
+				return err
+			}
+			if err := dst.RemoveAll(dir); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", dir, err)
+			}
+		}
+	}
+	return nil
+}
+
+// writeData writes data to the file name in dst, creating its parent directories
+func writeData(dst writefs.FS, name string, data []byte, perm fs.FileMode) error {
+	if err := dst.MkdirAll(path.Dir(name), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", path.Dir(name), err)
+	}
+	if info, err := dst.Lstat(name); err == nil && !info.Mode().IsRegular() {
+		if err := dst.RemoveAll(name); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+	f, err := dst.Create(name, perm)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
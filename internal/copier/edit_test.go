@@ -0,0 +1,193 @@
+package copier
+
+import (
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/upamune/airule/internal/memfs"
+)
+
+// TestLocalEdits tests how files edited since they were installed are resolved
+func TestLocalEdits(t *testing.T) {
+	installed := fstest.MapFS{
+		"go":          {Mode: fs.ModeDir | 0755},
+		"go/style.md": {Data: []byte("# Style\nUse gofmt.\nWrap at 100.\n"), Mode: 0644},
+		"go/other.md": {Data: []byte("other"), Mode: 0644},
+	}
+	updated := fstest.MapFS{
+		"go":          {Mode: fs.ModeDir | 0755},
+		"go/style.md": {Data: []byte("# Go Style\nUse gofmt.\nWrap at 100.\n"), Mode: 0644},
+		"go/other.md": {Data: []byte("other v2"), Mode: 0644},
+	}
+	local := "# Style\nUse gofmt.\nWrap at 120.\n"
+
+	tests := []struct {
+		name       string
+		opts       Options
+		want       map[string]string
+		wantKind   ActionKind
+		wantEdited string
+	}{
+		{
+			name:       "Overwrite",
+			opts:       Options{LocalEdits: EditOverwrite},
+			want:       map[string]string{"go/style.md": "# Go Style\nUse gofmt.\nWrap at 100.\n"},
+			wantKind:   ActionOverwrite,
+			wantEdited: "edited, overwritten",
+		},
+		{
+			name:       "Keep",
+			opts:       Options{LocalEdits: EditKeep},
+			want:       map[string]string{"go/style.md": local},
+			wantKind:   ActionKeep,
+			wantEdited: "edited, kept",
+		},
+		{
+			name:       "Keep while cleaning",
+			opts:       Options{Clean: true, LocalEdits: EditKeep},
+			want:       map[string]string{"go/style.md": local},
+			wantKind:   ActionKeep,
+			wantEdited: "edited, kept",
+		},
+		{
+			name: "Orig",
+			opts: Options{LocalEdits: EditOrig},
+			want: map[string]string{
+				"go/style.md":      "# Go Style\nUse gofmt.\nWrap at 100.\n",
+				"go/style.md.orig": local,
+			},
+			wantKind:   ActionOverwrite,
+			wantEdited: "edited, saved as style.md.orig",
+		},
+		{
+			name:       "Merge",
+			opts:       Options{Clean: true, LocalEdits: EditMerge},
+			want:       map[string]string{"go/style.md": "# Go Style\nUse gofmt.\nWrap at 120.\n"},
+			wantKind:   ActionOverwrite,
+			wantEdited: "edited, merged",
+		},
+		{
+			name: "Resolved per file",
+			opts: Options{LocalEdits: EditOverwrite, ResolveEdit: func(name string) EditPolicy {
+				if name != "go/style.md" {
+					t.Errorf("ResolveEdit(%q) for a file that was not edited", name)
+				}
+				return EditKeep
+			}},
+			want:       map[string]string{"go/style.md": local},
+			wantKind:   ActionKeep,
+			wantEdited: "edited, kept",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := memfs.New()
+			items := func(src fs.FS) []Item { return []Item{{Root: "/rules", Path: "go", FS: src}} }
+			if err := CopyItemsTo(items(installed), dst, Options{Manifest: true}); err != nil {
+				t.Fatalf("CopyItemsTo() error = %v", err)
+			}
+			if err := dst.WriteFile("go/style.md", []byte(local), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			opts := tt.opts
+			opts.Manifest = true
+			plan, err := PlanItemsTo(items(updated), dst, opts)
+			if err != nil {
+				t.Fatalf("PlanItemsTo() error = %v", err)
+			}
+			edits := plan.Edits()
+			if len(edits) != 1 || edits[0].Path != "go/style.md" || edits[0].Kind != tt.wantKind || edits[0].Note() != tt.wantEdited {
+				t.Fatalf("Edits() = %+v, want go/style.md to %v (%s)", edits, tt.wantKind, tt.wantEdited)
+			}
+			if err := plan.ExecuteTo(dst, memfs.New()); err != nil {
+				t.Fatalf("ExecuteTo() error = %v", err)
+			}
+
+			got := withoutBackups(snapshot(t, dst))
+			tt.want["go/"] = "-rwxr-xr-x"
+			tt.want["go/other.md"] = "other v2"
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("destination = %v, want %v", got, tt.want)
+			}
+
+			// Kept and merged files still have the local edits the next time
+			plan, err = PlanItemsTo(items(updated), dst, Options{Manifest: true, LocalEdits: EditKeep})
+			if err != nil {
+				t.Fatalf("PlanItemsTo() error = %v", err)
+			}
+			wantEdits := 0
+			if tt.wantKind == ActionKeep || tt.opts.LocalEdits == EditMerge {
+				wantEdits = 1
+			}
+			if got := len(plan.Edits()); got != wantEdits {
+				t.Errorf("Edits() on the next run = %d, want %d", got, wantEdits)
+			}
+		})
+	}
+}
+
+// TestMergeConflict tests that conflicting edits are merged with conflict markers
+func TestMergeConflict(t *testing.T) {
+	dst := memfs.New()
+	install := func(content string, opts Options) *Plan {
+		t.Helper()
+		src := fstest.MapFS{"style.md": {Data: []byte(content), Mode: 0644}}
+		opts.Manifest = true
+		plan, err := PlanItemsTo([]Item{{Root: "/rules", Path: "style.md", FS: src}}, dst, opts)
+		if err != nil {
+			t.Fatalf("PlanItemsTo() error = %v", err)
+		}
+		if err := plan.ExecuteTo(dst, memfs.New()); err != nil {
+			t.Fatalf("ExecuteTo() error = %v", err)
+		}
+		return plan
+	}
+
+	install("Wrap at 100.\n", Options{})
+	if err := dst.WriteFile("style.md", []byte("Wrap at 120.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plan := install("Wrap at 80.\n", Options{LocalEdits: EditMerge})
+	if got := plan.Edits(); len(got) != 1 || got[0].Conflicts != 1 {
+		t.Errorf("Edits() = %+v, want one conflict", got)
+	}
+	data, err := dst.ReadFile("style.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<<<<<<< local\nWrap at 120.\n=======\nWrap at 80.\n>>>>>>> airule\n"; string(data) != want {
+		t.Errorf("style.md = %q, want %q", data, want)
+	}
+}
+
+// TestBasesWithManifest tests that merge bases are only kept along with the manifest
+func TestBasesWithManifest(t *testing.T) {
+	src := fstest.MapFS{"style.md": {Data: []byte("style"), Mode: 0644}}
+	items := []Item{{Root: "/rules", Path: "style.md", FS: src}}
+
+	for _, tt := range []struct {
+		name string
+		opts Options
+		want bool
+	}{
+		{name: "Default", opts: Options{}, want: false},
+		{name: "Manifest", opts: Options{Manifest: true}, want: true},
+		{name: "Merge", opts: Options{LocalEdits: EditMerge}, want: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := memfs.New()
+			if err := CopyItemsTo(items, dst, tt.opts); err != nil {
+				t.Fatalf("CopyItemsTo() error = %v", err)
+			}
+			for _, name := range []string{ManifestFile, baseName("style.md")} {
+				if _, err := dst.Lstat(name); (err == nil) != tt.want {
+					t.Errorf("%s exists = %v, want %v", name, err == nil, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package copier
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/upamune/airule/internal/linkfs"
+	"github.com/upamune/airule/internal/writefs"
+)
+
+// journal records the changes made to a destination so that they can be undone.
+// Before a path is removed or replaced, its previous content is copied to the backup
+// file system; paths that did not exist are remembered so that they can be removed again.
+type journal struct {
+	dst    writefs.FS
+	backup writefs.FS
+
+	// saved holds the paths copied to backup, created the paths that did not exist before
+	saved   map[string]bool
+	created []string
+	// modes holds the previous permissions of directories whose permissions were changed
+	modes map[string]fs.FileMode
+}
+
+// newJournal creates a journal for dst keeping previous content in backup
+func newJournal(dst, backup writefs.FS) *journal {
+	return &journal{
+		dst:    dst,
+		backup: backup,
+		saved:  make(map[string]bool),
+		modes:  make(map[string]fs.FileMode),
+	}
+}
+
+// known reports whether name or one of its parents is already saved or created,
+// in which case its previous state is already recorded
+func (j *journal) known(name string) bool {
+	for p := name; p != "." && p != "/"; p = path.Dir(p) {
+		if j.saved[p] {
+			return true
+		}
+	}
+	for _, c := range j.created {
+		if c == "." || c == name || strings.HasPrefix(name, c+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// prepare records the state of name before it is written, removed or has its permissions
+// changed. Missing parent directories are recorded as created. An existing directory that
+// stays a directory only has its permissions recorded, since its content is recorded path by path.
+func (j *journal) prepare(name string, keepDir bool) error {
+	if j.known(name) {
+		return nil
+	}
+
+	// Record the topmost missing parent, whose removal takes everything created below it
+	var missing string
+	for p := path.Dir(name); p != "."; p = path.Dir(p) {
+		if _, err := j.dst.Lstat(p); errors.Is(err, fs.ErrNotExist) {
+			missing = p
+		} else if err != nil {
+			return fmt.Errorf("failed to check %s: %w", p, err)
+		}
+	}
+	if missing != "" {
+		j.created = append(j.created, missing)
+		return nil
+	}
+
+	info, err := j.dst.Lstat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		j.created = append(j.created, name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", name, err)
+	}
+
+	if keepDir && info.IsDir() {
+		if _, ok := j.modes[name]; !ok {
+			j.modes[name] = info.Mode().Perm()
+		}
+		return nil
+	}
+	if err := copyTree(j.dst, j.backup, name); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", name, err)
+	}
+	j.saved[name] = true
+	return nil
+}
+
+// prepareRoot records whether the destination root is created. Its permissions are
+// recorded before they are changed.
+func (j *journal) prepareRoot() error {
+	_, err := j.dst.Stat(".")
+	if errors.Is(err, fs.ErrNotExist) {
+		j.created = append(j.created, ".")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check destination directory: %w", err)
+	}
+	return nil
+}
+
+// rollback undoes every recorded change: created paths are removed, saved paths are put
+// back and directory permissions are restored. It carries on after errors and returns them all.
+func (j *journal) rollback() error {
+	var errs []error
+	for i := len(j.created) - 1; i >= 0; i-- {
+		name := j.created[i]
+		if name == "." {
+			// The root itself is removed by the caller, which knows where it is
+			entries, err := j.dst.ReadDir(".")
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			for _, entry := range entries {
+				if err := j.dst.RemoveAll(entry.Name()); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			continue
+		}
+		if err := j.dst.RemoveAll(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for name := range j.saved {
+		if err := j.dst.RemoveAll(name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := j.dst.MkdirAll(path.Dir(name), 0755); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := copyTree(j.backup, j.dst, name); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore %s: %w", name, err))
+		}
+	}
+
+	for name, mode := range j.modes {
+		if err := j.dst.Chmod(name, mode); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// copyTree copies the file, link or directory tree at name from one file system to the
+// same path in another, recreating links instead of following them
+func copyTree(from fs.FS, to writefs.FS, name string) error {
+	info, err := linkfs.Lstat(from, name)
+	if err != nil {
+		return err
+	}
+	if linkfs.IsLink(info.Mode()) {
+		return copyTreeLink(from, to, name)
+	}
+
+	return fs.WalkDir(from, name, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case p == name && info.IsDir(), p != name && d.IsDir():
+			return copyDirEntry(from, to, p)
+		case p != name && linkfs.IsLink(d.Type()):
+			return copyTreeLink(from, to, p)
+		default:
+			return copyFile(from, to, p)
+		}
+	})
+}
+
+// copyTreeLink recreates the symbolic link at name as it is
+func copyTreeLink(from fs.FS, to writefs.FS, name string) error {
+	target, err := linkfs.ReadLink(from, name)
+	if err != nil {
+		return err
+	}
+	return writeLink(to, name, target)
+}
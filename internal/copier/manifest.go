@@ -0,0 +1,277 @@
+package copier
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+
+	"github.com/upamune/airule/internal/writefs"
+)
+
+// ManifestFile is the manifest in the destination listing the files airule installed.
+// It is hidden, so cleaning never removes it.
+const ManifestFile = ".airule.lock"
+
+// manifestVersion is the version of the manifest format
+const manifestVersion = 1
+
+// ManifestEntry records a file or link installed in the destination
+type ManifestEntry struct {
+	// Path is the slash-separated path relative to the destination root
+	Path string `json:"path"`
+	// Type is TypeFile or TypeLink
+	Type string `json:"type"`
+	// Source is the path the entry was copied from
+	Source string `json:"source"`
+	// SHA256 is the checksum of the content written for a file
+	SHA256 string `json:"sha256,omitempty"`
+	// Target is the target of a link
+	Target string `json:"target,omitempty"`
+	// Hardlink reports that the file was installed as a hard link to its source
+	Hardlink bool `json:"hardlink,omitempty"`
+}
+
+// Manifest lists the files and links installed in a destination, sorted by path
+type Manifest struct {
+	Version int             `json:"version"`
+	Files   []ManifestEntry `json:"files"`
+}
+
+// CleanStrategy decides which destination paths cleaning removes
+type CleanStrategy int
+
+const (
+	// CleanAll removes everything that is not hidden or excluded
+	CleanAll CleanStrategy = iota
+	// CleanManaged removes only the files recorded in the manifest that are no longer copied
+	CleanManaged
+)
+
+// ParseCleanStrategy parses "all" or "managed"
+func ParseCleanStrategy(s string) (CleanStrategy, error) {
+	switch s {
+	case "", "all":
+		return CleanAll, nil
+	case "managed":
+		return CleanManaged, nil
+	}
+	return CleanAll, fmt.Errorf("invalid clean strategy %q: expected all or managed", s)
+}
+
+// String returns the strategy name
+func (s CleanStrategy) String() string {
+	if s == CleanManaged {
+		return "managed"
+	}
+	return "all"
+}
+
+// ReadManifest reads the manifest at the root of the destination. A destination without one
+// has an empty manifest.
+func ReadManifest(dst fs.FS) (*Manifest, error) {
+	data, err := fs.ReadFile(dst, ManifestFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Manifest{Version: manifestVersion}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", ManifestFile, err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", ManifestFile, err)
+	}
+	return &m, nil
+}
+
+// Entry returns the entry recorded for name
+func (m *Manifest) Entry(name string) (ManifestEntry, bool) {
+	for _, e := range m.Files {
+		if e.Path == name {
+			return e, true
+		}
+	}
+	return ManifestEntry{}, false
+}
+
+// encode returns the manifest as written to the destination
+func (m *Manifest) encode() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", ManifestFile, err)
+	}
+	return append(data, '\n'), nil
+}
+
+// checksum returns the hex-encoded SHA-256 of the file name
+func checksum(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// intact reports whether the destination still holds what airule installed at the path of e:
+// a file with the recorded checksum or a link to the recorded target
+func intact(dst writefs.FS, e ManifestEntry) (bool, error) {
+	info, err := dst.Lstat(e.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check %s: %w", e.Path, err)
+	}
+	if entryType(info.Mode()) != e.Type {
+		return false, nil
+	}
+	switch e.Type {
+	case TypeLink:
+		target, err := dst.ReadLink(e.Path)
+		return err == nil && target == e.Target, nil
+	case TypeFile:
+		sum, err := checksum(dst, e.Path)
+		if err != nil {
+			return false, fmt.Errorf("failed to read %s: %w", e.Path, err)
+		}
+		return sum == e.SHA256, nil
+	}
+	return false, nil
+}
+
+// planManifest returns the manifest after the actions are carried out: the previous entries
+// whose paths are neither removed nor written again, and an entry per file and link written
+func planManifest(prev *Manifest, actions []Action, removed map[string]bool) (*Manifest, error) {
+	written := make(map[string]bool, len(actions))
+	m := &Manifest{Version: manifestVersion}
+	for _, a := range actions {
+		switch a.Kind {
+		case ActionRemove:
+			continue
+		case ActionKeep:
+			continue // The previous entry stays, so that the file is still seen as edited
+		}
+		written[a.Path] = true
+		switch a.Type {
+		case TypeFile:
+			sum, err := checksum(a.src, a.Path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", a.Source, err)
+			}
+			m.Files = append(m.Files, ManifestEntry{Path: a.Path, Type: TypeFile, Source: a.Source, SHA256: sum, Hardlink: a.Hardlink})
+		case TypeLink:
+			m.Files = append(m.Files, ManifestEntry{Path: a.Path, Type: TypeLink, Source: a.Source, Target: a.Target})
+		}
+	}
+	for _, e := range prev.Files {
+		if !written[e.Path] && !gone(e.Path, removed) {
+			m.Files = append(m.Files, e)
+		}
+	}
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+	return m, nil
+}
+
+// planManagedRemovals returns the files recorded in the previous manifest that are not written
+// again, together with the directories left empty by their removal, sorted by path.
+// Paths that are preserved while cleaning, or no longer what airule installed, are kept,
+// and so are files edited since they were installed, which now hold the user's content.
+func planManagedRemovals(dst writefs.FS, prev *Manifest, writes []Action, excludePatterns []string) ([]string, error) {
+	// writes include the edited files that are kept, which must not be removed either
+	written := make(map[string]bool, len(writes))
+	for _, a := range writes {
+		for p := a.Path; p != "." && p != "/"; p = path.Dir(p) {
+			written[p] = true // Directories holding written paths stay too
+		}
+	}
+
+	removed := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, e := range prev.Files {
+		if written[e.Path] {
+			continue
+		}
+		ok, err := intact(dst, e)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue // Missing, edited since airule installed it or replaced by something else
+		}
+		preserve, err := checkPreservationRecursive(dst, e.Path, excludePatterns)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", e.Path, err)
+		}
+		if preserve {
+			continue
+		}
+		removed[e.Path] = true
+		for p := path.Dir(e.Path); p != "."; p = path.Dir(p) {
+			dirs[p] = true
+		}
+	}
+
+	// Remove directories whose whole content is removed, the deepest first
+	var candidates []string
+	for dir := range dirs {
+		candidates = append(candidates, dir)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return len(candidates[i]) > len(candidates[j]) })
+	for _, dir := range candidates {
+		if written[dir] {
+			continue
+		}
+		entries, err := dst.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		empty := true
+		for _, entry := range entries {
+			if !removed[path.Join(dir, entry.Name())] {
+				empty = false
+				break
+			}
+		}
+		if empty {
+			removed[dir] = true
+		}
+	}
+
+	// A removed directory takes everything inside it
+	var removals []string
+	for name := range removed {
+		if !gone(path.Dir(name), removed) {
+			removals = append(removals, name)
+		}
+	}
+	sort.Strings(removals)
+	return removals, nil
+}
+
+// writeManifest writes the manifest to the destination, recording the change in j first.
+// An unchanged manifest is left alone.
+func writeManifest(dst writefs.FS, m *Manifest, j *journal) error {
+	data, err := m.encode()
+	if err != nil {
+		return err
+	}
+	if old, err := fs.ReadFile(dst, ManifestFile); err == nil && bytes.Equal(old, data) {
+		return nil
+	}
+	if err := j.prepare(ManifestFile, false); err != nil {
+		return err
+	}
+	return writeData(dst, ManifestFile, data, 0644)
+}
@@ -0,0 +1,230 @@
+package copier
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/upamune/airule/internal/memfs"
+)
+
+// TestParseCleanStrategy tests parsing clean strategies by name
+func TestParseCleanStrategy(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    CleanStrategy
+		wantErr bool
+	}{
+		{input: "", want: CleanAll},
+		{input: "all", want: CleanAll},
+		{input: "managed", want: CleanManaged},
+		{input: "some", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseCleanStrategy(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCleanStrategy(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("ParseCleanStrategy(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestManagedClean tests that a managed clean only removes files installed before
+// that are no longer copied
+func TestManagedClean(t *testing.T) {
+	src := fstest.MapFS{
+		"go":               {Mode: fs.ModeDir | 0755},
+		"go/style.md":      {Data: []byte("style"), Mode: 0644},
+		"go/testing.md":    {Data: []byte("testing"), Mode: 0644},
+		"python/style.md":  {Data: []byte("python"), Mode: 0644},
+		"python/typing.md": {Data: []byte("typing"), Mode: 0644},
+		"common.md":        {Data: []byte("common"), Mode: 0644},
+	}
+	item := func(name string) Item { return Item{Root: "/rules", Path: name, FS: src} }
+	opts := Options{Clean: true, CleanStrategy: CleanManaged}
+
+	dst := memfs.New()
+	for name, content := range map[string]string{
+		"notes.md":      "my notes",
+		"go/project.md": "my project",
+	} {
+		if err := dst.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := CopyItemsTo([]Item{item("go"), item("python"), item("common.md")}, dst, opts); err != nil {
+		t.Fatalf("CopyItemsTo() error = %v", err)
+	}
+	m, err := ReadManifest(dst)
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+	var paths []string
+	for _, e := range m.Files {
+		paths = append(paths, e.Path)
+	}
+	if want := []string{"common.md", "go/style.md", "go/testing.md", "python/style.md", "python/typing.md"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("manifest = %v, want %v", paths, want)
+	}
+	if e, _ := m.Entry("go/style.md"); e.Source != "/rules/go/style.md" || e.SHA256 != sha256Hex("style") {
+		t.Errorf("manifest entry = %+v", e)
+	}
+
+	// Deselecting python and one Go file removes them, leaving the user's files alone
+	plan, err := PlanItemsTo([]Item{item("go/style.md"), item("common.md")}, dst, opts)
+	if err != nil {
+		t.Fatalf("PlanItemsTo() error = %v", err)
+	}
+	var removals []string
+	for _, a := range plan.ActionsOf(ActionRemove) {
+		removals = append(removals, a.Type+" "+a.Path)
+	}
+	if want := []string{"file go/testing.md", "dir python", "file python/style.md", "file python/typing.md"}; !reflect.DeepEqual(removals, want) {
+		t.Errorf("removals = %v, want %v", removals, want)
+	}
+	if err := plan.ExecuteTo(dst, memfs.New()); err != nil {
+		t.Fatalf("ExecuteTo() error = %v", err)
+	}
+
+	got := withoutBackups(snapshot(t, dst))
+	want := map[string]string{
+		"go/":           "-rwxr-xr-x",
+		"go/style.md":   "style",
+		"go/project.md": "my project",
+		"common.md":     "common",
+		"notes.md":      "my notes",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("destination = %v, want %v", got, want)
+	}
+
+	m, err = ReadManifest(dst)
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+	paths = nil
+	for _, e := range m.Files {
+		paths = append(paths, e.Path)
+	}
+	if want := []string{"common.md", "go/style.md"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("manifest = %v, want %v", paths, want)
+	}
+
+	// Only the bases of installed files are kept
+	bases := make(map[string]string)
+	for name, content := range snapshot(t, dst) {
+		if strings.HasPrefix(name, BaseDir+"/") && name != BaseDir+"/" {
+			bases[strings.TrimPrefix(name, BaseDir+"/")] = content
+		}
+	}
+	if want := map[string]string{"go/": "-rwxr-xr-x", "go/style.md": "style", "common.md": "common"}; !reflect.DeepEqual(bases, want) {
+		t.Errorf("bases = %v, want %v", bases, want)
+	}
+}
+
+// TestManagedCleanKeepsEditedFiles tests that a managed clean leaves deselected files alone
+// once they have been edited in the destination
+func TestManagedCleanKeepsEditedFiles(t *testing.T) {
+	src := fstest.MapFS{
+		"a.md":    {Data: []byte("a"), Mode: 0644},
+		"b.md":    {Data: []byte("b"), Mode: 0644},
+		"go/c.md": {Data: []byte("c"), Mode: 0644},
+	}
+	item := func(name string) Item { return Item{Root: "/rules", Path: name, FS: src} }
+	opts := Options{Clean: true, CleanStrategy: CleanManaged}
+
+	dst := memfs.New()
+	if err := CopyItemsTo([]Item{item("a.md"), item("b.md"), item("go/c.md")}, dst, opts); err != nil {
+		t.Fatalf("CopyItemsTo() error = %v", err)
+	}
+	for name, content := range map[string]string{"b.md": "b with my edits", "go/c.md": "c with my edits"} {
+		if err := dst.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plan, err := PlanItemsTo([]Item{item("a.md")}, dst, opts)
+	if err != nil {
+		t.Fatalf("PlanItemsTo() error = %v", err)
+	}
+	if removals := plan.ActionsOf(ActionRemove); len(removals) != 0 {
+		t.Errorf("removals = %+v, want none", removals)
+	}
+	if err := plan.ExecuteTo(dst, memfs.New()); err != nil {
+		t.Fatalf("ExecuteTo() error = %v", err)
+	}
+
+	got := withoutBackups(snapshot(t, dst))
+	want := map[string]string{
+		"a.md":    "a",
+		"b.md":    "b with my edits",
+		"go/":     "-rwxr-xr-x",
+		"go/c.md": "c with my edits",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("destination = %v, want %v", got, want)
+	}
+
+	// Reverting the edits makes the file removable again
+	if err := dst.WriteFile("b.md", []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plan, err = PlanItemsTo([]Item{item("a.md")}, dst, opts)
+	if err != nil {
+		t.Fatalf("PlanItemsTo() error = %v", err)
+	}
+	var removals []string
+	for _, a := range plan.ActionsOf(ActionRemove) {
+		removals = append(removals, a.Path)
+	}
+	if want := []string{"b.md"}; !reflect.DeepEqual(removals, want) {
+		t.Errorf("removals = %v, want %v", removals, want)
+	}
+}
+
+// TestManifestOptional tests that the manifest is only written when asked for, and kept up to
+// date once the destination has one
+func TestManifestOptional(t *testing.T) {
+	src := fstest.MapFS{"a.md": {Data: []byte("a"), Mode: 0644}}
+	items := []Item{{Root: "/rules", Path: "a.md", FS: src}}
+
+	dst := memfs.New()
+	if err := CopyItemsTo(items, dst, Options{}); err != nil {
+		t.Fatalf("CopyItemsTo() error = %v", err)
+	}
+	if _, err := dst.Lstat(ManifestFile); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("%s written without Manifest: %v", ManifestFile, err)
+	}
+
+	if err := CopyItemsTo(items, dst, Options{Manifest: true}); err != nil {
+		t.Fatalf("CopyItemsTo() error = %v", err)
+	}
+	src["a.md"] = &fstest.MapFile{Data: []byte("a updated"), Mode: 0644}
+	if err := CopyItemsTo(items, dst, Options{}); err != nil {
+		t.Fatalf("CopyItemsTo() error = %v", err)
+	}
+	m, err := ReadManifest(dst)
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+	if e, ok := m.Entry("a.md"); !ok || e.SHA256 != sha256Hex("a updated") {
+		t.Errorf("manifest entry = %+v, %v, want the checksum of the updated file", e, ok)
+	}
+}
+
+// sha256Hex returns the hex-encoded SHA-256 of s
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,187 @@
+package copier
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+
+	"github.com/upamune/airule/internal/linkfs"
+	"github.com/upamune/airule/internal/writefs"
+)
+
+// InstallMode decides how files are installed in the destination
+type InstallMode int
+
+const (
+	// ModeCopy copies the content of files
+	ModeCopy InstallMode = iota
+	// ModeSymlink installs files as symbolic links to the absolute path of their source
+	ModeSymlink
+	// ModeHardlink installs files as hard links to their source, copying them when the
+	// source is on another file system
+	ModeHardlink
+	// ModeRelativeSymlink installs files as symbolic links to their source, relative to
+	// the directory holding the link
+	ModeRelativeSymlink
+)
+
+// ParseInstallMode parses "copy", "symlink", "hardlink" or "relative-symlink"
+func ParseInstallMode(s string) (InstallMode, error) {
+	switch s {
+	case "", "copy":
+		return ModeCopy, nil
+	case "symlink":
+		return ModeSymlink, nil
+	case "hardlink":
+		return ModeHardlink, nil
+	case "relative-symlink":
+		return ModeRelativeSymlink, nil
+	}
+	return ModeCopy, fmt.Errorf("invalid install mode %q: expected copy, symlink, hardlink or relative-symlink", s)
+}
+
+// String returns the mode name
+func (m InstallMode) String() string {
+	switch m {
+	case ModeSymlink:
+		return "symlink"
+	case ModeHardlink:
+		return "hardlink"
+	case ModeRelativeSymlink:
+		return "relative-symlink"
+	default:
+		return "copy"
+	}
+}
+
+// installer turns the file writes of a plan into links according to an install mode
+type installer struct {
+	mode InstallMode
+	// dstRoot is the absolute destination directory, or "" if it is not on disk
+	dstRoot string
+	// dstDev is the device holding the destination, known when hasDev is set
+	dstDev uint64
+	hasDev bool
+}
+
+// newInstaller prepares installing files into dst with mode
+func newInstaller(dst writefs.FS, mode InstallMode) *installer {
+	in := &installer{mode: mode}
+	d, ok := dst.(writefs.Dir)
+	if !ok {
+		return in
+	}
+	root, err := filepath.Abs(string(d))
+	if err != nil {
+		return in
+	}
+	in.dstRoot = root
+
+	// The destination may not exist yet, in which case it is created on the device of
+	// its nearest existing parent
+	for dir := root; ; dir = filepath.Dir(dir) {
+		if info, err := os.Stat(dir); err == nil {
+			if id, ok := linkfs.ID(info); ok {
+				in.dstDev, in.hasDev = id.Dev, true
+			}
+			break
+		}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	return in
+}
+
+// install rewrites the write of a file read from the source directory root on disk.
+// Files not read from disk cannot be linked: symbolic link modes fail for them, and the
+// hardlink mode copies them.
+func (in *installer) install(a *Action, root string) error {
+	if in.mode == ModeCopy || a.Type != TypeFile {
+		return nil
+	}
+	if root == "" {
+		if in.mode == ModeHardlink {
+			return nil
+		}
+		return fmt.Errorf("cannot link %s: the source is not on disk", a.Path)
+	}
+	srcPath := filepath.Join(root, filepath.FromSlash(a.Path))
+
+	switch in.mode {
+	case ModeSymlink:
+		a.Type, a.Target = TypeLink, srcPath
+	case ModeRelativeSymlink:
+		if in.dstRoot == "" {
+			return fmt.Errorf("cannot link %s: relative links need a destination on disk", a.Path)
+		}
+		dir := filepath.Dir(filepath.Join(in.dstRoot, filepath.FromSlash(a.Path)))
+		target, err := filepath.Rel(dir, srcPath)
+		if err != nil {
+			return fmt.Errorf("cannot link %s: %w", a.Path, err)
+		}
+		a.Type, a.Target = TypeLink, filepath.ToSlash(target)
+	case ModeHardlink:
+		// Hard links cannot cross file systems, so such files are copied
+		info, err := os.Stat(srcPath)
+		if err != nil {
+			return fmt.Errorf("failed to get file info for %s: %w", a.Source, err)
+		}
+		if id, ok := linkfs.ID(info); !ok || !in.hasDev || id.Dev != in.dstDev {
+			return nil
+		}
+		a.Hardlink, a.srcPath = true, srcPath
+	}
+	return nil
+}
+
+// sameFile reports whether the destination file name is a hard link to the source file of a
+func sameFile(dst writefs.FS, a Action) bool {
+	srcInfo, err := os.Stat(a.srcPath)
+	if err != nil {
+		return false
+	}
+	dstInfo, err := dst.Lstat(a.Path)
+	if err != nil {
+		return false
+	}
+	srcID, ok := linkfs.ID(srcInfo)
+	dstID, dstOK := linkfs.ID(dstInfo)
+	return ok && dstOK && srcID == dstID
+}
+
+// linkFile creates name in dst as a hard link to the source file of a, copying the file
+// instead when the link cannot be created
+func linkFile(dst writefs.FS, a Action) error {
+	if err := dst.MkdirAll(path.Dir(a.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", path.Dir(a.Path), err)
+	}
+	// Never write through an existing link to another file
+	if err := dst.RemoveAll(a.Path); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", a.Path, err)
+	}
+	err := dst.Link(a.srcPath, a.Path)
+	if errors.Is(err, syscall.EXDEV) || errors.Is(err, errors.ErrUnsupported) {
+		return copyFile(a.src, dst, a.Path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create hard link: %w", err)
+	}
+	return nil
+}
+
+// unlinkInstalled marks the file writes replacing hard links installed before, which must be
+// removed before they are written so that their source is not written through them
+func unlinkInstalled(prev *Manifest, writes []Action) {
+	for i := range writes {
+		if writes[i].Type != TypeFile || writes[i].Hardlink {
+			continue
+		}
+		if entry, ok := prev.Entry(writes[i].Path); ok && entry.Hardlink {
+			writes[i].unlink = true
+		}
+	}
+}
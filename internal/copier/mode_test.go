@@ -0,0 +1,223 @@
+package copier
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/upamune/airule/internal/memfs"
+)
+
+// TestParseInstallMode tests parsing install mode names
+func TestParseInstallMode(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    InstallMode
+		wantErr bool
+	}{
+		{input: "", want: ModeCopy},
+		{input: "copy", want: ModeCopy},
+		{input: "symlink", want: ModeSymlink},
+		{input: "hardlink", want: ModeHardlink},
+		{input: "relative-symlink", want: ModeRelativeSymlink},
+		{input: "junction", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseInstallMode(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseInstallMode(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseInstallMode(%q) = %v", tt.input, got)
+			}
+		})
+	}
+}
+
+// TestInstallModes tests installing files as copies and links, and cleaning them up
+func TestInstallModes(t *testing.T) {
+	tests := []struct {
+		mode InstallMode
+		// linked reports whether changes to the source show in the destination
+		linked bool
+		// target is the link target of go/style.md, relative to the temporary directory
+		// for absolute links
+		target string
+	}{
+		{mode: ModeCopy},
+		{mode: ModeSymlink, linked: true, target: "src/go/style.md"},
+		{mode: ModeRelativeSymlink, linked: true, target: "../../../src/go/style.md"},
+		{mode: ModeHardlink, linked: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			tmp := t.TempDir()
+			srcDir := filepath.Join(tmp, "src")
+			dstDir := filepath.Join(tmp, "rules", "cursor")
+			for name, content := range map[string]string{"go/style.md": "style", "common.md": "common"} {
+				p := filepath.Join(srcDir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			install := func(paths ...string) *Plan {
+				t.Helper()
+				var items []Item
+				for _, p := range paths {
+					items = append(items, Item{Root: srcDir, Path: p})
+				}
+				opts := Options{Mode: tt.mode, Manifest: true, Clean: true, CleanStrategy: CleanManaged}
+				plan, err := PlanItems(items, dstDir, opts)
+				if err != nil {
+					t.Fatalf("PlanItems() error = %v", err)
+				}
+				if _, err := plan.Execute(dstDir); err != nil {
+					t.Fatalf("Execute() error = %v", err)
+				}
+				return plan
+			}
+			install("go", "common.md")
+
+			style := filepath.Join(dstDir, "go", "style.md")
+			info, err := os.Lstat(style)
+			if err != nil {
+				t.Fatalf("Lstat() error = %v", err)
+			}
+			if tt.target != "" {
+				target, err := os.Readlink(style)
+				want := tt.target
+				if tt.mode == ModeSymlink {
+					want = filepath.Join(tmp, want)
+				}
+				if err != nil || target != filepath.FromSlash(want) {
+					t.Errorf("Readlink() = %q, %v, want %q", target, err, want)
+				}
+			} else if !info.Mode().IsRegular() {
+				t.Errorf("go/style.md mode = %v, want a regular file", info.Mode())
+			}
+			if srcInfo, err := os.Stat(filepath.Join(srcDir, "go", "style.md")); err != nil || os.SameFile(srcInfo, info) != (tt.mode == ModeHardlink) {
+				t.Errorf("SameFile() = %v, %v", os.SameFile(srcInfo, info), err)
+			}
+
+			// Changing the source in place shows through links only
+			if err := os.WriteFile(filepath.Join(srcDir, "go", "style.md"), []byte("new style"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			want := "style"
+			if tt.linked {
+				want = "new style"
+			}
+			if data, err := os.ReadFile(style); err != nil || string(data) != want {
+				t.Errorf("go/style.md = %q, %v, want %q", data, err, want)
+			}
+
+			// Links already in place are unchanged
+			plan := install("go", "common.md")
+			if tt.linked {
+				if n := plan.CountFiles(ActionUnchanged); n != 2 {
+					t.Errorf("second install unchanged = %d, want 2 (%s)", n, plan.Summary())
+				}
+			}
+
+			// A managed clean removes the links it created, never their sources
+			install("common.md")
+			if _, err := os.Lstat(style); !os.IsNotExist(err) {
+				t.Errorf("Lstat(go/style.md) after clean error = %v, want not exist", err)
+			}
+			if data, err := os.ReadFile(filepath.Join(srcDir, "go", "style.md")); err != nil || string(data) != "new style" {
+				t.Errorf("source after clean = %q, %v", data, err)
+			}
+		})
+	}
+}
+
+// TestHardlinkToCopy tests that copying over a hard link installed before leaves its source alone
+func TestHardlinkToCopy(t *testing.T) {
+	tmp := t.TempDir()
+	srcDir := filepath.Join(tmp, "src")
+	dstDir := filepath.Join(tmp, "dst")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "style.md"), []byte("style"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	items := []Item{{Root: srcDir, Path: "style.md"}}
+
+	for _, mode := range []InstallMode{ModeHardlink, ModeCopy} {
+		plan, err := PlanItems(items, dstDir, Options{Mode: mode, Manifest: true})
+		if err != nil {
+			t.Fatalf("PlanItems(%v) error = %v", mode, err)
+		}
+		if _, err := plan.Execute(dstDir); err != nil {
+			t.Fatalf("Execute(%v) error = %v", mode, err)
+		}
+	}
+
+	srcInfo, err := os.Stat(filepath.Join(srcDir, "style.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstInfo, err := os.Stat(filepath.Join(dstDir, "style.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(srcInfo, dstInfo) {
+		t.Error("style.md is still a hard link to its source after copying")
+	}
+
+	// Editing the copy must not change the source
+	if err := os.WriteFile(filepath.Join(dstDir, "style.md"), []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(srcDir, "style.md")); err != nil || string(data) != "style" {
+		t.Errorf("source = %q, %v, want %q", data, err, "style")
+	}
+}
+
+// TestLinkFallback tests that files that cannot be linked are copied or rejected
+func TestLinkFallback(t *testing.T) {
+	src := fstest.MapFS{"style.md": {Data: []byte("style"), Mode: 0644}}
+	items := []Item{{Root: "/rules", Path: "style.md", FS: src}}
+
+	// A source that is not on disk is copied by the hardlink mode
+	dst := memfs.New()
+	plan, err := PlanItemsTo(items, dst, Options{Mode: ModeHardlink})
+	if err != nil {
+		t.Fatalf("PlanItemsTo() error = %v", err)
+	}
+	if plan.Actions[0].Hardlink {
+		t.Error("PlanItemsTo() plans a hard link to a source that is not on disk")
+	}
+	if err := plan.ExecuteTo(dst, memfs.New()); err != nil {
+		t.Fatalf("ExecuteTo() error = %v", err)
+	}
+	if data, err := dst.ReadFile("style.md"); err != nil || string(data) != "style" {
+		t.Errorf("style.md = %q, %v", data, err)
+	}
+
+	// A destination that cannot hold the link gets a copy
+	dst = memfs.New()
+	a := Action{Path: "style.md", Type: TypeFile, Hardlink: true, src: src, srcPath: "/rules/style.md"}
+	if err := linkFile(dst, a); err != nil {
+		t.Fatalf("linkFile() error = %v", err)
+	}
+	if data, err := dst.ReadFile("style.md"); err != nil || string(data) != "style" {
+		t.Errorf("style.md = %q, %v", data, err)
+	}
+
+	// Symbolic links need a source on disk
+	for _, mode := range []InstallMode{ModeSymlink, ModeRelativeSymlink} {
+		if _, err := PlanItemsTo(items, memfs.New(), Options{Mode: mode}); err == nil {
+			t.Errorf("PlanItemsTo(%v) error = nil, want an error for a source not on disk", mode)
+		}
+	}
+}
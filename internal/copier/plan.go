@@ -0,0 +1,810 @@
+package copier
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/upamune/airule/internal/linkfs"
+	"github.com/upamune/airule/internal/writefs"
+)
+
+// ActionKind is what a plan does to a destination path
+type ActionKind int
+
+const (
+	// ActionCreate writes a path that does not exist in the destination
+	ActionCreate ActionKind = iota
+	// ActionOverwrite replaces an existing path with different content
+	ActionOverwrite
+	// ActionUnchanged writes a path that already has the same content
+	ActionUnchanged
+	// ActionRemove removes a path while cleaning the destination
+	ActionRemove
+	// ActionKeep leaves an edited or conflicting destination path as it is instead of writing it
+	ActionKeep
+)
+
+// String returns the name of the action kind
+func (k ActionKind) String() string {
+	switch k {
+	case ActionOverwrite:
+		return "overwrite"
+	case ActionUnchanged:
+		return "unchanged"
+	case ActionRemove:
+		return "remove"
+	case ActionKeep:
+		return "keep"
+	default:
+		return "create"
+	}
+}
+
+// MarshalText encodes the action kind by name
+func (k ActionKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// Types of the paths an action applies to
+const (
+	TypeFile = "file"
+	TypeDir  = "dir"
+	TypeLink = "link"
+)
+
+// Action is one change a plan makes to the destination
+type Action struct {
+	Kind ActionKind `json:"action"`
+	// Path is the slash-separated path relative to the destination root
+	Path string `json:"path"`
+	// Type is TypeFile, TypeDir or TypeLink
+	Type string `json:"type"`
+	// Source is the path the entry is copied from. It is empty for removals.
+	Source string `json:"source,omitempty"`
+	// Target is the target of a link written to the destination
+	Target string `json:"target,omitempty"`
+	// Edited reports that the file was changed in the destination since airule installed it
+	Edited bool `json:"edited,omitempty"`
+	// Conflict reports that the path exists in the destination with different content
+	Conflict bool `json:"conflict,omitempty"`
+	// Resolution is the EditPolicy applied to an edited file, or the ConflictPolicy applied
+	// to a conflicting one
+	Resolution string `json:"resolution,omitempty"`
+	// Conflicts is the number of conflicts left in a merged file
+	Conflicts int `json:"conflicts,omitempty"`
+	// Hardlink reports that the file is installed as a hard link to its source
+	Hardlink bool `json:"hardlink,omitempty"`
+
+	// src is the file system holding Path in the source
+	src fs.FS
+	// data is the merged content written instead of the source content
+	data []byte
+	// srcPath is the source file on disk a hard link points to
+	srcPath string
+	// unlink removes the destination file before it is written, since it is a hard link
+	unlink bool
+	// inside reports that a removed path goes with the removed directory holding it
+	inside bool
+}
+
+// Plan lists every change a copy makes to the destination: the paths removed while cleaning,
+// every removed directory followed by the paths inside it, then the files, directories and
+// links written, in the order they are carried out.
+// With Options.Manifest, the files and links written are recorded in the ManifestFile of
+// the destination.
+// A plan is computed without touching the destination and executed with Execute.
+type Plan struct {
+	// Destination is the destination directory, when the plan was made for one on disk
+	Destination string   `json:"destination,omitempty"`
+	Clean       bool     `json:"clean"`
+	Actions     []Action `json:"actions"`
+
+	// keepBackups is the number of runs whose backups are kept by Execute
+	keepBackups int
+	// manifest is written to the destination after the copy, when anything is installed
+	manifest *Manifest
+	// staleBases are the paths whose base is removed, since they are no longer installed
+	staleBases []string
+}
+
+// PlanItems computes the plan for copying items to the destination directory
+func PlanItems(items []Item, toDir string, opts Options) (*Plan, error) {
+	plan, err := PlanItemsTo(items, writefs.Dir(toDir), opts)
+	if err != nil {
+		return nil, err
+	}
+	plan.Destination = toDir
+	return plan, nil
+}
+
+// PlanItemsTo computes the plan for copying items to the root of a writable file system.
+// Items are planned in order; when several write the same path, only the last one is kept.
+func PlanItemsTo(items []Item, dst writefs.FS, opts Options) (*Plan, error) {
+	plan := &Plan{Clean: opts.Clean, keepBackups: opts.KeepBackups}
+
+	// The files installed before, which are the only ones a managed clean removes
+	useManifest := opts.Manifest || opts.CleanStrategy == CleanManaged || opts.LocalEdits == EditMerge
+	if _, err := dst.Lstat(ManifestFile); err == nil {
+		useManifest = true // Keep it up to date, so that it is never stale
+	}
+	prev := &Manifest{Version: manifestVersion}
+	if useManifest {
+		var err error
+		if prev, err = ReadManifest(dst); err != nil {
+			return nil, err
+		}
+	}
+
+	installer := newInstaller(dst, opts.Mode)
+	var writes []Action
+	for _, item := range items {
+		fsys := item.files()
+		name := filepath.ToSlash(item.Path)
+
+		// Get file info without following a final link, so that the link policy applies to it
+		info, err := linkfs.Lstat(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get file info for %s: %w", filepath.Join(item.Root, item.Path), err)
+		}
+
+		p := itemPlanner{src: fsys, root: item.diskRoot(), source: item.Root, policy: opts.Symlinks}
+		if err := p.planEntry(name, info, 0); err != nil {
+			return nil, fmt.Errorf("failed to copy %s: %w", item.Path, err)
+		}
+		for i := range p.actions {
+			if err := installer.install(&p.actions[i], p.root); err != nil {
+				return nil, err
+			}
+		}
+		writes = append(writes, p.actions...)
+	}
+
+	// Keep the last write of every path, in the place of the first one, so that
+	// directories still come before their content
+	index := make(map[string]int, len(writes))
+	var kept []Action
+	for _, a := range writes {
+		if i, ok := index[a.Path]; ok {
+			kept[i] = a
+			continue
+		}
+		index[a.Path] = len(kept)
+		kept = append(kept, a)
+	}
+
+	if useManifest {
+		unlinkInstalled(prev, kept)
+	}
+
+	// Files edited since they were installed are resolved first, since cleaning must not
+	// remove those that are kept, saved or merged
+	var edits map[string]bool
+	if useManifest {
+		var err error
+		if edits, err = planEdits(dst, prev, kept, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	removed := make(map[string]bool)
+	if opts.Clean {
+		var removals []string
+		var err error
+		if opts.CleanStrategy == CleanManaged {
+			removals, err = planManagedRemovals(dst, prev, kept, opts.CleanExclude)
+		} else {
+			// Paths written again in place are not removed, so that unchanged files are not
+			// rewritten
+			keep := make(map[string]bool, len(kept)+len(edits))
+			for name := range edits {
+				keep[name] = true
+			}
+			for _, a := range kept {
+				if inPlace(dst, a) {
+					keep[a.Path] = true
+				}
+			}
+			removals, err = planRemovals(dst, opts.CleanExclude, keep)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range removals {
+			actions, err := planRemoval(dst, name)
+			if err != nil {
+				return nil, err
+			}
+			plan.Actions = append(plan.Actions, actions...)
+			removed[name] = true
+		}
+	}
+
+	for _, a := range kept {
+		if !gone(a.Path, removed) && a.Kind != ActionKeep {
+			// Files recorded in the manifest are compared by SHA-256, since a matching size and
+			// modification time can hide changes, e.g. in sources without modification times
+			_, recorded := prev.Entry(a.Path)
+			a.Kind = compare(a, dst, opts.Checksum || recorded)
+		}
+		plan.Actions = append(plan.Actions, a)
+	}
+	if err := planConflicts(dst, prev, plan.Actions, opts); err != nil {
+		return nil, err
+	}
+
+	if useManifest {
+		var err error
+		if plan.manifest, err = planManifest(prev, plan.Actions, removed); err != nil {
+			return nil, err
+		}
+		if len(prev.Files) == 0 && len(plan.manifest.Files) == 0 {
+			plan.manifest = nil // Nothing was ever installed
+		}
+		for _, e := range prev.Files {
+			if _, ok := plan.manifest.Entry(e.Path); !ok && e.Type == TypeFile {
+				plan.staleBases = append(plan.staleBases, e.Path)
+			}
+		}
+	}
+	return plan, nil
+}
+
+// planRemoval returns the removal of name followed, when it is a directory, by the removal of
+// every path inside it, which goes with the directory
+func planRemoval(dst writefs.FS, name string) ([]Action, error) {
+	info, err := dst.Lstat(name)
+	if err != nil || !info.IsDir() {
+		typ := TypeFile
+		if err == nil {
+			typ = entryType(info.Mode())
+		}
+		return []Action{{Kind: ActionRemove, Path: name, Type: typ}}, nil
+	}
+
+	var actions []Action
+	err = fs.WalkDir(dst, name, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		actions = append(actions, Action{Kind: ActionRemove, Path: p, Type: entryType(d.Type()), inside: p != name})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", name, err)
+	}
+	return actions, nil
+}
+
+// gone reports whether name or one of its parent directories is removed while cleaning
+func gone(name string, removed map[string]bool) bool {
+	for ; name != "." && name != "/"; name = path.Dir(name) {
+		if removed[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// compare classifies a write against what the destination currently holds at its path.
+// A file with the size of its source is unchanged when it has the same SHA-256 if checksum
+// is set, and otherwise when it has the same modification time or the same content.
+func compare(a Action, dst writefs.FS, checksum bool) ActionKind {
+	info, err := dst.Lstat(a.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ActionCreate
+	}
+	if err != nil || entryType(info.Mode()) != a.Type {
+		return ActionOverwrite
+	}
+
+	switch a.Type {
+	case TypeDir:
+		return ActionUnchanged
+	case TypeLink:
+		if target, err := linkfs.ReadLink(dst, a.Path); err == nil && target == a.Target {
+			return ActionUnchanged
+		}
+	default:
+		if a.unlink {
+			return ActionOverwrite // A hard link installed before is replaced by a copy
+		}
+		if a.Hardlink {
+			if sameFile(dst, a) {
+				return ActionUnchanged
+			}
+			return ActionOverwrite
+		}
+		srcInfo, err := fs.Stat(a.src, a.Path)
+		if err != nil || srcInfo.Size() != info.Size() {
+			return ActionOverwrite
+		}
+		switch {
+		case checksum:
+			if sameChecksum(a.src, dst, a.Path) {
+				return ActionUnchanged
+			}
+		case srcInfo.ModTime().Equal(info.ModTime()), sameContent(a.src, dst, a.Path, info.Size()):
+			return ActionUnchanged
+		}
+	}
+	return ActionOverwrite
+}
+
+// sameChecksum reports whether the file name has the same SHA-256 in src and dst.
+// Files that cannot be read are reported as different.
+func sameChecksum(src fs.FS, dst fs.FS, name string) bool {
+	a, err := checksum(src, name)
+	if err != nil {
+		return false
+	}
+	b, err := checksum(dst, name)
+	return err == nil && a == b
+}
+
+// inPlace reports whether a can be written over the path the destination holds: it has the
+// type of a, and every directory above it is a directory rather than a link
+func inPlace(dst writefs.FS, a Action) bool {
+	for p := a.Path; p != "."; p = path.Dir(p) {
+		info, err := dst.Lstat(p)
+		if err != nil {
+			return false
+		}
+		if p == a.Path && entryType(info.Mode()) != a.Type || p != a.Path && !info.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// entryType returns the action type of a file mode
+func entryType(mode fs.FileMode) string {
+	switch {
+	case linkfs.IsLink(mode):
+		return TypeLink
+	case mode.IsDir():
+		return TypeDir
+	default:
+		return TypeFile
+	}
+}
+
+// sameContent reports whether the file name has the same content in src and dst.
+// size is the size of the file in dst. Files that cannot be read are reported as different.
+func sameContent(src fs.FS, dst fs.FS, name string, size int64) bool {
+	if info, err := fs.Stat(src, name); err != nil || info.Size() != size {
+		return false
+	}
+
+	a, err := src.Open(name)
+	if err != nil {
+		return false
+	}
+	defer a.Close()
+	b, err := dst.Open(name)
+	if err != nil {
+		return false
+	}
+	defer b.Close()
+
+	bufA, bufB := make([]byte, 32*1024), make([]byte, 32*1024)
+	for {
+		n, errA := io.ReadFull(a, bufA)
+		m, errB := io.ReadFull(b, bufB)
+		if !bytes.Equal(bufA[:n], bufB[:m]) {
+			return false
+		}
+		endA := errors.Is(errA, io.EOF) || errors.Is(errA, io.ErrUnexpectedEOF)
+		endB := errors.Is(errB, io.EOF) || errors.Is(errB, io.ErrUnexpectedEOF)
+		if endA || endB {
+			return endA && endB
+		}
+		if errA != nil || errB != nil {
+			return false
+		}
+	}
+}
+
+// Execute carries out the plan in the destination directory. Whatever the plan removes or
+// replaces is saved first, so that a failed copy leaves the destination as it was.
+// When the plan keeps backups, the saved paths are kept in BackupDir after the copy and
+// the returned run can be undone with Undo; otherwise they are kept in a temporary directory
+// next to the destination until the copy is finished, and the returned run is nil.
+func (p *Plan) Execute(toDir string) (*Run, error) {
+	toDir = filepath.Clean(toDir)
+
+	// A destination created by the copy is removed again when it fails
+	missing := ""
+	for dir := toDir; ; dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(dir); !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		missing = dir
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	run, err := p.executeDir(toDir)
+	if err != nil && missing != "" {
+		if rmErr := os.RemoveAll(missing); rmErr != nil {
+			return nil, errors.Join(err, fmt.Errorf("failed to remove %s: %w", missing, rmErr))
+		}
+	}
+	return run, err
+}
+
+// KeepsBackups reports whether Execute keeps what the plan removes or overwrites in a backup,
+// so that the run can be undone
+func (p *Plan) KeepsBackups() bool {
+	return p.keepBackups > 0
+}
+
+// executeDir carries out the plan in the destination directory on disk
+func (p *Plan) executeDir(toDir string) (*Run, error) {
+	if p.keepBackups > 0 {
+		return p.executeWithBackup(toDir, p.keepBackups)
+	}
+
+	// Prefer a sibling of the destination, which is on the same file system, and fall back
+	// to the temporary directory when the parent does not exist yet
+	backupDir, err := os.MkdirTemp(filepath.Dir(toDir), "."+filepath.Base(toDir)+".airule-")
+	if err != nil {
+		backupDir, err = os.MkdirTemp("", "airule-backup-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create backup directory: %w", err)
+		}
+	}
+	defer os.RemoveAll(backupDir)
+
+	return nil, p.ExecuteTo(writefs.Dir(toDir), writefs.Dir(backupDir))
+}
+
+// ExecuteTo carries out the plan at the root of a writable file system. Before a path is
+// removed or replaced it is copied to backup; if any step fails, every change is undone
+// and the error is returned.
+func (p *Plan) ExecuteTo(dst, backup writefs.FS) error {
+	_, err := p.executeJournal(dst, backup)
+	return err
+}
+
+// executeJournal is ExecuteTo returning the journal of the changes made
+func (p *Plan) executeJournal(dst, backup writefs.FS) (*journal, error) {
+	j := newJournal(dst, backup)
+	if err := p.execute(dst, j); err != nil {
+		if rbErr := j.rollback(); rbErr != nil {
+			return nil, errors.Join(err, fmt.Errorf("failed to roll back the destination: %w", rbErr))
+		}
+		return nil, err
+	}
+	return j, nil
+}
+
+// execute carries out the plan, recording every change in j first
+func (p *Plan) execute(dst writefs.FS, j *journal) error {
+	if err := j.prepareRoot(); err != nil {
+		return err
+	}
+	// Ensure the destination directory exists
+	if err := dst.MkdirAll(".", 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+
+	// Clear the destination directory before copying if the plan cleans it
+	if p.Clean {
+		for _, a := range p.Actions {
+			if a.Kind != ActionRemove || a.inside {
+				continue
+			}
+			if err := j.prepare(a.Path, false); err != nil {
+				return err
+			}
+			if err := dst.RemoveAll(a.Path); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", a.Path, err)
+			}
+		}
+		if info, err := dst.Stat("."); err == nil && info.Mode().Perm() != 0755 {
+			if _, ok := j.modes["."]; !ok {
+				j.modes["."] = info.Mode().Perm()
+			}
+			if err := dst.Chmod(".", 0755); err != nil {
+				return fmt.Errorf("failed to set directory permissions after clear: %w", err)
+			}
+		}
+	}
+
+	for _, a := range p.Actions {
+		if a.Kind == ActionRemove || a.Kind == ActionKeep {
+			continue
+		}
+		// Files and links that are already as written are left alone, so that their
+		// modification times do not change
+		if a.Kind == ActionUnchanged && a.Type != TypeDir {
+			continue
+		}
+		if err := j.prepare(a.Path, a.Type == TypeDir); err != nil {
+			return err
+		}
+
+		switch {
+		case a.Edited && a.Resolution == EditOrig.String():
+			if err := saveCopy(dst, a.Path, OrigSuffix, j); err != nil {
+				return err
+			}
+		case a.Conflict && a.Resolution == ConflictBackup.String():
+			if err := saveCopy(dst, a.Path, BackupSuffix, j); err != nil {
+				return err
+			}
+		}
+
+		if a.unlink {
+			if err := dst.RemoveAll(a.Path); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", a.Path, err)
+			}
+		}
+
+		var err error
+		switch {
+		case a.Hardlink:
+			err = linkFile(dst, a)
+		case a.data != nil:
+			var info fs.FileInfo
+			if info, err = fs.Stat(a.src, a.Path); err == nil {
+				err = writeData(dst, a.Path, a.data, info.Mode().Perm())
+			}
+		case a.Type == TypeDir:
+			err = copyDirEntry(a.src, dst, a.Path)
+		case a.Type == TypeLink:
+			err = writeLink(dst, a.Path, a.Target)
+		default:
+			err = copyFile(a.src, dst, a.Path)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %w", filepath.FromSlash(a.Path), err)
+		}
+	}
+
+	if p.manifest != nil {
+		if err := p.writeBases(dst, j); err != nil {
+			return err
+		}
+		return writeManifest(dst, p.manifest, j)
+	}
+	return nil
+}
+
+// ActionsOf returns the actions of the given kind. Directories are only returned for removals,
+// since written directories are implied by the files inside them.
+func (p *Plan) ActionsOf(kind ActionKind) []Action {
+	var actions []Action
+	for _, a := range p.Actions {
+		if a.Kind == kind && (a.Type != TypeDir || kind == ActionRemove) {
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+// Count returns the number of actions returned by ActionsOf
+func (p *Plan) Count(kind ActionKind) int {
+	return len(p.ActionsOf(kind))
+}
+
+// CountFiles returns the number of files and links with the given kind of action
+func (p *Plan) CountFiles(kind ActionKind) int {
+	n := 0
+	for _, a := range p.ActionsOf(kind) {
+		if a.Type != TypeDir {
+			n++
+		}
+	}
+	return n
+}
+
+// Summary describes the number of paths created, overwritten, left unchanged and removed,
+// and the number of edited files kept when there are any
+func (p *Plan) Summary() string {
+	summary := fmt.Sprintf("%d to create, %d to overwrite, %d unchanged, %d to remove",
+		p.Count(ActionCreate), p.Count(ActionOverwrite), p.Count(ActionUnchanged), p.Count(ActionRemove))
+	if n := p.Count(ActionKeep); n > 0 {
+		summary += fmt.Sprintf(", %d kept", n)
+	}
+	return summary
+}
+
+// Conflicts returns the actions on paths that existed in the destination with different content
+func (p *Plan) Conflicts() []Action {
+	var actions []Action
+	for _, a := range p.Actions {
+		if a.Conflict {
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+// Edits returns the actions on files edited in the destination since they were installed
+func (p *Plan) Edits() []Action {
+	var actions []Action
+	for _, a := range p.Actions {
+		if a.Edited {
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+// Note describes how an edited or conflicting path is handled, e.g. "edited, merged with
+// 1 conflict(s)" or "exists, skipped". It is empty for other paths.
+func (a Action) Note() string {
+	switch {
+	case a.Edited:
+		switch a.Resolution {
+		case EditKeep.String():
+			return "edited, kept"
+		case EditOrig.String():
+			return "edited, saved as " + path.Base(a.Path) + OrigSuffix
+		case EditMerge.String():
+			if a.Conflicts > 0 {
+				return fmt.Sprintf("edited, merged with %d conflict(s)", a.Conflicts)
+			}
+			return "edited, merged"
+		default:
+			return "edited, overwritten"
+		}
+	case a.Conflict:
+		switch a.Resolution {
+		case ConflictSkip.String():
+			return "exists, skipped"
+		case ConflictBackup.String():
+			return "exists, saved as " + path.Base(a.Path) + BackupSuffix
+		case ConflictNewer.String():
+			if a.Kind == ActionKeep {
+				return "exists, destination is newer"
+			}
+			return "exists, source is newer"
+		default:
+			return "exists, overwritten"
+		}
+	}
+	return ""
+}
+
+// WriteText writes the plan for people to read: one line per removed path, including every
+// path inside a removed directory, and per file or link written, followed by the summary.
+// Removed directories end with a slash.
+func (p *Plan) WriteText(w io.Writer) error {
+	var b strings.Builder
+	for _, a := range p.Actions {
+		if a.Type == TypeDir && a.Kind != ActionRemove {
+			continue // Directories are implied by the files inside them
+		}
+		name := a.Path
+		if a.Type == TypeDir {
+			name += "/"
+		}
+		switch {
+		case a.Type == TypeLink && a.Kind != ActionRemove:
+			fmt.Fprintf(&b, "  %-10s %s -> %s\n", a.Kind, name, a.Target)
+		case a.Edited, a.Conflict:
+			fmt.Fprintf(&b, "  %-10s %s (%s)\n", a.Kind, name, a.Note())
+		default:
+			fmt.Fprintf(&b, "  %-10s %s\n", a.Kind, name)
+		}
+	}
+	fmt.Fprintf(&b, "%s\n", p.Summary())
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// itemPlanner plans the writes for one item, applying the symbolic link policy
+type itemPlanner struct {
+	src fs.FS
+	// root is the absolute source directory on disk, used to rewrite links pointing outside of it
+	root string
+	// source is the root the item is copied from, as shown in the plan
+	source  string
+	policy  linkfs.Policy
+	actions []Action
+}
+
+// add records a write of name
+func (p *itemPlanner) add(name, typ, target string) {
+	p.actions = append(p.actions, Action{
+		Path:   name,
+		Type:   typ,
+		Source: filepath.Join(p.source, filepath.FromSlash(name)),
+		Target: target,
+		src:    p.src,
+	})
+}
+
+// planEntry plans copying the file, directory or link at name. info is the result of Lstat;
+// followed is the number of links followed to reach name.
+func (p *itemPlanner) planEntry(name string, info fs.FileInfo, followed int) error {
+	if !linkfs.IsLink(info.Mode()) {
+		if info.IsDir() {
+			return p.planDir(name, followed)
+		}
+		p.add(name, TypeFile, "")
+		return nil
+	}
+
+	switch p.policy {
+	case linkfs.Skip:
+		return nil
+	case linkfs.Preserve:
+		return p.planLink(name)
+	}
+
+	target, err := fs.Stat(p.src, name)
+	if err != nil {
+		return fmt.Errorf("failed to resolve link %s: %w", name, err)
+	}
+	if !target.IsDir() {
+		p.add(name, TypeFile, "")
+		return nil
+	}
+	if linkfs.IsLoop(p.src, name, target, followed) {
+		return fmt.Errorf("link %s points to a directory containing it", name)
+	}
+	return p.planDir(name, followed+1)
+}
+
+// planDir plans copying a directory recursively
+func (p *itemPlanner) planDir(name string, followed int) error {
+	p.add(name, TypeDir, "")
+
+	// Read directory entries
+	entries, err := fs.ReadDir(p.src, name)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	// Plan each entry
+	for _, entry := range entries {
+		childName := path.Join(name, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			return fmt.Errorf("failed to get file info for %s: %w", childName, err)
+		}
+
+		// Broken links and link loops inside a directory are skipped, as they are when
+		// finding files, where they have already been reported
+		if linkfs.IsLink(info.Mode()) && p.policy == linkfs.Follow {
+			target, err := fs.Stat(p.src, childName)
+			if err != nil || (target.IsDir() && linkfs.IsLoop(p.src, childName, target, followed)) {
+				continue
+			}
+		}
+
+		if err := p.planEntry(childName, info, followed); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// planLink plans recreating the symbolic link at name. A relative target pointing outside
+// the source is rewritten to an absolute path, so that the link keeps pointing to the same file.
+func (p *itemPlanner) planLink(name string) error {
+	target, err := linkfs.ReadLink(p.src, name)
+	if err != nil {
+		return fmt.Errorf("failed to read link: %w", err)
+	}
+	if !filepath.IsAbs(target) && linkfs.Escapes(name, target) {
+		if p.root == "" {
+			return fmt.Errorf("link %s points outside the source: %s", name, target)
+		}
+		target = filepath.Join(p.root, filepath.FromSlash(path.Dir(name)), target)
+	}
+	p.add(name, TypeLink, target)
+	return nil
+}
@@ -0,0 +1,470 @@
+package copier
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/upamune/airule/internal/linkfs"
+	"github.com/upamune/airule/internal/memfs"
+	"github.com/upamune/airule/internal/writefs"
+)
+
+// TestPlanItems tests that a plan describes the copy without touching the destination
+func TestPlanItems(t *testing.T) {
+	org := fstest.MapFS{
+		"go/style.md":   {Data: []byte("org style"), Mode: 0644},
+		"go/testing.md": {Data: []byte("testing"), Mode: 0644},
+	}
+	team := fstest.MapFS{
+		"go/style.md": {Data: []byte("team style"), Mode: 0644},
+		"common.md":   {Data: []byte("common"), Mode: 0644},
+	}
+
+	dst := memfs.New()
+	existing := map[string]string{
+		"go/style.md":        "old style",
+		"go/testing.md":      "testing",
+		"common.md":          "common",
+		"stale/old.md":       "stale",
+		"stale/sub/older.md": "staler",
+		".gitkeep":           "",
+		"config/local.json":  "{}",
+	}
+	for name, content := range existing {
+		if err := dst.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	items := []Item{
+		{Root: "/rules/org", Path: "go", FS: org},
+		{Root: "/rules/team", Path: "go", FS: team},
+		{Root: "/rules/team", Path: "common.md", FS: team},
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{
+			name: "Without cleaning",
+			opts: Options{},
+			want: []string{
+				"unchanged dir go",
+				"overwrite file go/style.md",
+				"unchanged file go/testing.md",
+				"unchanged file common.md",
+			},
+		},
+		{
+			name: "Cleaning",
+			opts: Options{Clean: true, CleanExclude: []string{"config/*.json"}},
+			want: []string{
+				"remove dir stale",
+				"remove file stale/old.md",
+				"remove dir stale/sub",
+				"remove file stale/sub/older.md",
+				"unchanged dir go",
+				"overwrite file go/style.md",
+				"unchanged file go/testing.md",
+				"unchanged file common.md",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := PlanItemsTo(items, dst, tt.opts)
+			if err != nil {
+				t.Fatalf("PlanItemsTo() error = %v", err)
+			}
+
+			var got []string
+			for _, a := range plan.Actions {
+				got = append(got, a.Kind.String()+" "+a.Type+" "+a.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlanItemsTo() = %v, want %v", got, tt.want)
+			}
+
+			// Nothing is written while planning
+			if data, err := dst.ReadFile("go/style.md"); err != nil || string(data) != "old style" {
+				t.Errorf("destination changed while planning: %q, %v", data, err)
+			}
+		})
+	}
+
+	plan, err := PlanItemsTo(items, dst, Options{Clean: true, CleanExclude: []string{"config/*.json"}})
+	if err != nil {
+		t.Fatalf("PlanItemsTo() error = %v", err)
+	}
+	if got, want := plan.Summary(), "0 to create, 1 to overwrite, 2 unchanged, 4 to remove"; got != want {
+		t.Errorf("Summary() = %q, want %q", got, want)
+	}
+
+	var text strings.Builder
+	if err := plan.WriteText(&text); err != nil {
+		t.Fatalf("WriteText() error = %v", err)
+	}
+	wantText := `  remove     stale/
+  remove     stale/old.md
+  remove     stale/sub/
+  remove     stale/sub/older.md
+  overwrite  go/style.md (exists, overwritten)
+  unchanged  go/testing.md
+  unchanged  common.md
+0 to create, 1 to overwrite, 2 unchanged, 4 to remove
+`
+	if text.String() != wantText {
+		t.Errorf("WriteText() =\n%s\nwant\n%s", text.String(), wantText)
+	}
+
+	data, err := json.Marshal(plan)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	for _, want := range []string{
+		`{"action":"overwrite","path":"go/style.md","type":"file","source":"/rules/team/go/style.md","conflict":true,"resolution":"overwrite"}`,
+		`{"action":"remove","path":"stale/sub/older.md","type":"file"}`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("json.Marshal() = %s, want it to contain %s", data, want)
+		}
+	}
+
+	// Executing the plan gives the same result as copying
+	if err := plan.ExecuteTo(dst, memfs.New()); err != nil {
+		t.Fatalf("ExecuteTo() error = %v", err)
+	}
+	got := make(map[string]string)
+	err = fs.WalkDir(dst, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := dst.ReadFile(name)
+		got[name] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk destination: %v", err)
+	}
+	want := map[string]string{
+		"go/style.md":       "team style",
+		"go/testing.md":     "testing",
+		"common.md":         "common",
+		".gitkeep":          "",
+		"config/local.json": "{}",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("destination = %v, want %v", got, want)
+	}
+}
+
+// TestPlanItemsLinks tests how preserved links are compared with the destination
+func TestPlanItemsLinks(t *testing.T) {
+	src := memfs.New()
+	if err := src.WriteFile("rules/go.md", []byte("go"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range map[string]string{"rules/same.md": "go.md", "rules/moved.md": "go.md"} {
+		if err := src.Symlink(target, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst := memfs.New()
+	if err := dst.Symlink("go.md", "rules/same.md"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Symlink("old.md", "rules/moved.md"); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := PlanItemsTo([]Item{{Root: "/rules", Path: "rules", FS: src}}, dst, Options{Symlinks: linkfs.Preserve})
+	if err != nil {
+		t.Fatalf("PlanItemsTo() error = %v", err)
+	}
+	var got []string
+	for _, a := range plan.Actions {
+		got = append(got, a.Kind.String()+" "+a.Type+" "+a.Path+" "+a.Target)
+	}
+	want := []string{
+		"unchanged dir rules ",
+		"create file rules/go.md ",
+		"overwrite link rules/moved.md go.md",
+		"unchanged link rules/same.md go.md",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PlanItemsTo() = %v, want %v", got, want)
+	}
+}
+
+// failingFS is a source whose named file cannot be opened
+type failingFS struct {
+	fstest.MapFS
+	fail string
+}
+
+func (f failingFS) Open(name string) (fs.File, error) {
+	if name == f.fail {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return f.MapFS.Open(name)
+}
+
+// snapshot returns the files and links of a file system with their content or target
+func snapshot(t *testing.T, fsys fs.FS) map[string]string {
+	t.Helper()
+	got := make(map[string]string)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || name == "." {
+			return err
+		}
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := linkfs.ReadLink(fsys, name)
+			got[name] = "-> " + target
+			return err
+		case d.IsDir():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			got[name+"/"] = info.Mode().Perm().String()
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, name)
+		got[name] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk %v: %v", fsys, err)
+	}
+	return got
+}
+
+// TestExecuteRollback tests that a failed copy leaves the destination as it was
+func TestExecuteRollback(t *testing.T) {
+	src := failingFS{
+		MapFS: fstest.MapFS{
+			"go":            {Mode: fs.ModeDir | 0700},
+			"go/style.md":   {Data: []byte("new style"), Mode: 0644},
+			"go/new/a.md":   {Data: []byte("a"), Mode: 0644},
+			"go/testing.md": {Data: []byte("testing"), Mode: 0644},
+			"zz.md":         {Data: []byte("unreadable"), Mode: 0644},
+		},
+		fail: "zz.md",
+	}
+	items := []Item{
+		{Root: "/rules", Path: "go", FS: src},
+		{Root: "/rules", Path: "zz.md", FS: src},
+	}
+
+	t.Run("In memory", func(t *testing.T) {
+		dst := memfs.New()
+		for name, content := range map[string]string{
+			"go/style.md":    "old style",
+			"stale/old.md":   "stale",
+			"notes.md":       "notes",
+			".hidden/keep":   "keep",
+			"zz.md":          "old zz",
+			"links/dir/x.md": "x",
+		} {
+			if err := dst.WriteFile(name, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := dst.Symlink("../notes.md", "links/notes.md"); err != nil {
+			t.Fatal(err)
+		}
+		before := snapshot(t, dst)
+
+		err := CopyItemsTo(items, dst, Options{Clean: true})
+		if err == nil || !strings.Contains(err.Error(), "zz.md") {
+			t.Fatalf("CopyItemsTo() error = %v, want a failure copying zz.md", err)
+		}
+		if after := snapshot(t, dst); !reflect.DeepEqual(after, before) {
+			t.Errorf("destination after failure = %v, want %v", after, before)
+		}
+	})
+
+	t.Run("On disk", func(t *testing.T) {
+		parent := t.TempDir()
+		dstDir := filepath.Join(parent, "rules")
+		dst := writefs.Dir(dstDir)
+		for name, content := range map[string]string{
+			"go/style.md":  "old style",
+			"stale/old.md": "stale",
+			"zz.md":        "old zz",
+		} {
+			if err := dst.MkdirAll(path.Dir(name), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dstDir, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		before := snapshot(t, dst)
+
+		if err := CopyItems(items, dstDir, Options{Clean: true}); err == nil {
+			t.Fatal("CopyItems() should fail")
+		}
+		if after := snapshot(t, dst); !reflect.DeepEqual(after, before) {
+			t.Errorf("destination after failure = %v, want %v", after, before)
+		}
+
+		// The backup next to the destination is gone
+		entries, err := os.ReadDir(parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Name() != "rules" {
+			t.Errorf("entries next to the destination = %v, want only rules", entries)
+		}
+	})
+
+	t.Run("New destination", func(t *testing.T) {
+		parent := t.TempDir()
+		dstDir := filepath.Join(parent, "a", "rules")
+
+		if err := CopyItems(items, dstDir, Options{Clean: true}); err == nil {
+			t.Fatal("CopyItems() should fail")
+		}
+		if _, err := os.Stat(filepath.Join(parent, "a")); !os.IsNotExist(err) {
+			t.Errorf("destination created by a failed copy was not removed: %v", err)
+		}
+	})
+}
+
+// TestIncrementalCopy tests that files already as copied are compared by size and
+// modification time, or by checksum, and left alone
+func TestIncrementalCopy(t *testing.T) {
+	srcTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	dstTime := srcTime.Add(-time.Hour)
+	src := fstest.MapFS{
+		"copied.md":  {Data: []byte("copied"), Mode: 0644, ModTime: srcTime},
+		"touched.md": {Data: []byte("touched"), Mode: 0644, ModTime: srcTime},
+		"same.md":    {Data: []byte("same"), Mode: 0644, ModTime: srcTime},
+		"sneaky.md":  {Data: []byte("sneaky"), Mode: 0644, ModTime: srcTime},
+	}
+	var items []Item
+	for _, name := range []string{"copied.md", "same.md", "sneaky.md", "touched.md"} {
+		items = append(items, Item{Root: "/rules", Path: name, FS: src})
+	}
+
+	tests := []struct {
+		name     string
+		checksum bool
+		want     []string
+	}{
+		{
+			name: "Size and modification time",
+			want: []string{"create copied.md", "unchanged same.md", "unchanged sneaky.md", "unchanged touched.md"},
+		},
+		{
+			name:     "Checksum",
+			checksum: true,
+			want:     []string{"create copied.md", "unchanged same.md", "overwrite sneaky.md", "unchanged touched.md"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := memfs.New()
+			// Same content with another modification time, and other content of the same
+			// size with the same modification time
+			files := []struct {
+				name    string
+				data    string
+				modTime time.Time
+			}{
+				{"touched.md", "touched", dstTime},
+				{"same.md", "same", srcTime},
+				{"sneaky.md", "SNEAKY", srcTime},
+			}
+			for _, f := range files {
+				if err := dst.WriteFileTime(f.name, []byte(f.data), 0644, f.modTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			plan, err := PlanItemsTo(items, dst, Options{Checksum: tt.checksum})
+			if err != nil {
+				t.Fatalf("PlanItemsTo() error = %v", err)
+			}
+			var got []string
+			for _, a := range plan.Actions {
+				got = append(got, a.Kind.String()+" "+a.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlanItemsTo() = %v, want %v", got, tt.want)
+			}
+
+			if err := plan.ExecuteTo(dst, memfs.New()); err != nil {
+				t.Fatalf("ExecuteTo() error = %v", err)
+			}
+			// Copied files take the modification time of their source, unchanged ones keep theirs
+			wantTimes := map[string]time.Time{"copied.md": srcTime, "touched.md": dstTime, "same.md": srcTime, "sneaky.md": srcTime}
+			for name, want := range wantTimes {
+				info, err := dst.Stat(name)
+				if err != nil || !info.ModTime().Equal(want) {
+					t.Errorf("Stat(%s) = %v, %v, want modification time %v", name, info, err, want)
+				}
+			}
+			wantSneaky := "SNEAKY"
+			if tt.checksum {
+				wantSneaky = "sneaky"
+			}
+			if data, err := dst.ReadFile("sneaky.md"); err != nil || string(data) != wantSneaky {
+				t.Errorf("sneaky.md = %q, %v, want %q", data, err, wantSneaky)
+			}
+
+			// A second run finds every file unchanged
+			again, err := PlanItemsTo(items, dst, Options{Checksum: tt.checksum})
+			if err != nil {
+				t.Fatalf("PlanItemsTo() again error = %v", err)
+			}
+			if n := again.CountFiles(ActionUnchanged); n != len(items) {
+				t.Errorf("second run CountFiles(unchanged) = %d, want %d", n, len(items))
+			}
+		})
+	}
+}
+
+// TestIncrementalCopyManifest tests that files recorded in the manifest are compared by checksum
+func TestIncrementalCopyManifest(t *testing.T) {
+	srcTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	src := fstest.MapFS{
+		"same.md":   {Data: []byte("same"), Mode: 0644, ModTime: srcTime},
+		"sneaky.md": {Data: []byte("sneaky"), Mode: 0644, ModTime: srcTime},
+	}
+	items := []Item{{Root: "/rules", Path: "same.md", FS: src}, {Root: "/rules", Path: "sneaky.md", FS: src}}
+
+	dst := memfs.New()
+	if err := CopyItemsTo(items, dst, Options{Manifest: true}); err != nil {
+		t.Fatalf("CopyItemsTo() error = %v", err)
+	}
+	// Other content of the same size with the same modification time
+	src["sneaky.md"] = &fstest.MapFile{Data: []byte("SNEAKY"), Mode: 0644, ModTime: srcTime}
+
+	plan, err := PlanItemsTo(items, dst, Options{})
+	if err != nil {
+		t.Fatalf("PlanItemsTo() error = %v", err)
+	}
+	var got []string
+	for _, a := range plan.Actions {
+		got = append(got, a.Kind.String()+" "+a.Path)
+	}
+	if want := []string{"unchanged same.md", "overwrite sneaky.md"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("PlanItemsTo() = %v, want %v", got, want)
+	}
+}
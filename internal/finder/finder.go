@@ -1,194 +1,272 @@
 package finder
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io/fs"
-	"os"
-	"path/filepath"
-	"sort"
-	"strings"
+	"syscall"
+	"time"
+
+	"github.com/upamune/airule/internal/attr"
+	"github.com/upamune/airule/internal/content"
+	"github.com/upamune/airule/internal/frontmatter"
+	"github.com/upamune/airule/internal/linkfs"
+	"github.com/upamune/airule/internal/pattern"
 )
 
+// Options configures which files are found
+type Options struct {
+	// Includes are patterns a file must match (any of them) to be listed
+	Includes []string
+	// Excludes are patterns of files and directories to skip
+	Excludes []string
+	// Rules are gitignore-style rules evaluated in order after Excludes, so a
+	// "!pattern" rule can re-include something an earlier exclude removed
+	Rules pattern.Rules
+	// IgnoreFiles are the names of per-directory ignore files (e.g. ".gitignore") to honor.
+	// Ignored paths are pruned before any other filter; when empty no ignore files are read.
+	IgnoreFiles []string
+	// Where are front-matter conditions every listed file must satisfy
+	Where []frontmatter.Condition
+	// Content holds regular expressions the body of every listed file must satisfy
+	Content content.Matcher
+	// Attrs holds the size, age and type conditions every listed file must satisfy
+	Attrs attr.Filter
+	// Symlinks decides whether symbolic links are followed, listed as links or skipped
+	Symlinks linkfs.Policy
+	// Entries selects whether files, directories or both are listed
+	Entries EntryMode
+	// Order is the order entries are listed in
+	Order Order
+	// Workers is the number of directories read concurrently. Values below 1 use DefaultWorkers.
+	Workers int
+	// OnWarning is called for every path that is skipped because of a problem, such as an
+	// unreadable directory or a broken symlink. When nil, warnings are discarded.
+	OnWarning func(Warning)
+}
+
+// EntryMode selects the kinds of entries that are listed
+type EntryMode int
+
+const (
+	// EntriesBoth lists files and the directories containing them
+	EntriesBoth EntryMode = iota
+	// EntriesFiles lists files only
+	EntriesFiles
+	// EntriesDirs lists directories only. The files found below them are still known,
+	// so that selecting a directory can copy exactly those files.
+	EntriesDirs
+)
+
+// ParseEntryMode parses "both", "files" or "dirs"
+func ParseEntryMode(s string) (EntryMode, error) {
+	switch s {
+	case "", "both":
+		return EntriesBoth, nil
+	case "files":
+		return EntriesFiles, nil
+	case "dirs":
+		return EntriesDirs, nil
+	}
+	return EntriesBoth, fmt.Errorf("invalid entry mode %q: expected files, dirs or both", s)
+}
+
+// String returns the name of the entry mode
+func (m EntryMode) String() string {
+	switch m {
+	case EntriesFiles:
+		return "files"
+	case EntriesDirs:
+		return "dirs"
+	default:
+		return "both"
+	}
+}
+
+// lists reports whether entries of the given kind are listed
+func (m EntryMode) lists(isDir bool) bool {
+	switch m {
+	case EntriesFiles:
+		return !isDir
+	case EntriesDirs:
+		return isDir
+	default:
+		return true
+	}
+}
+
+// WarningKind classifies problems encountered while walking a source
+type WarningKind int
+
+const (
+	// WarningUnreadable is a file or directory that cannot be read, e.g. because of permissions
+	WarningUnreadable WarningKind = iota
+	// WarningBrokenSymlink is a symbolic link whose target does not exist
+	WarningBrokenSymlink
+	// WarningPathTooLong is a path exceeding the limits of the operating system
+	WarningPathTooLong
+	// WarningInvalidFrontMatter is a file whose front-matter cannot be parsed
+	WarningInvalidFrontMatter
+	// WarningSymlinkLoop is a symbolic link to a directory containing it
+	WarningSymlinkLoop
+	// WarningInvalidIgnoreFile is a .gitignore or .airuleignore file that cannot be parsed
+	WarningInvalidIgnoreFile
+	// WarningOther is any other error
+	WarningOther
+)
+
+// String returns a short description of the warning kind
+func (k WarningKind) String() string {
+	switch k {
+	case WarningUnreadable:
+		return "unreadable"
+	case WarningBrokenSymlink:
+		return "broken symlink"
+	case WarningPathTooLong:
+		return "path too long"
+	case WarningInvalidFrontMatter:
+		return "invalid front-matter"
+	case WarningSymlinkLoop:
+		return "symlink loop"
+	case WarningInvalidIgnoreFile:
+		return "invalid ignore file"
+	default:
+		return "error"
+	}
+}
+
+// Warning describes a path skipped while walking a source
+type Warning struct {
+	// Source is the name of the source containing Path
+	Source string
+	// Path is the slash-separated path relative to the source root
+	Path string
+	Kind WarningKind
+	Err  error
+}
+
+// String formats the warning for display
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s: %s: %v", w.Source, w.Path, w.Kind, w.Err)
+}
+
+// classify returns the warning kind for an error returned while walking
+func classify(err error) WarningKind {
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		return WarningUnreadable
+	case errors.Is(err, syscall.ENAMETOOLONG):
+		return WarningPathTooLong
+	default:
+		return WarningOther
+	}
+}
+
 // FindFiles searches for files in the given root directory
 // and filters them based on include and exclude patterns
 func FindFiles(rootDir string, includes, excludes []string) ([]string, error) {
-	// Check if the root directory exists
-	if _, err := os.Stat(rootDir); os.IsNotExist(err) {
-		return nil, err
+	return Find(rootDir, Options{Includes: includes, Excludes: excludes})
+}
+
+// Source is a root directory that files are found in
+type Source struct {
+	// Name labels the source in the picker and preview
+	Name string
+	// Root is the directory to walk, or where FS was loaded from when FS is set
+	Root string
+	// FS is the file system to walk. When nil, Root is walked on disk.
+	FS fs.FS
+}
+
+// Files returns the file system holding the source tree
+func (s Source) Files() fs.FS {
+	if s.FS != nil {
+		return s.FS
 	}
+	return linkfs.Dir(s.Root)
+}
 
-	foundFiles := make([]string, 0)
-	// Keep track of parent directories of found files (using a map as a set)
-	parentDirs := make(map[string]struct{})
-
-	// Walk through the directory recursively
-	err := filepath.WalkDir(rootDir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			// Handle errors accessing files/dirs, but continue walking if possible
-			if errors.Is(err, fs.ErrPermission) {
-				// Can't read directory or file, skip it
-				if d.IsDir() {
-					return fs.SkipDir
-				}
-				return nil // Skip the file
-			}
-			// For other errors, report them but try to continue
-			// Consider logging the error here: log.Printf("Error accessing %s: %v", path, err)
-			return nil // or return err to stop the walk
-		}
-
-		// Skip the root directory itself
-		if path == rootDir {
-			return nil
-		}
-
-		// Get the relative path from the root directory
-		relPath, err := filepath.Rel(rootDir, path)
-		if err != nil {
-			return err // Stop walk on relative path error
-		}
-
-		// Check if the current directory should be skipped based on exclude patterns
-		if d.IsDir() {
-			isDirExcluded := false
-			for _, pattern := range excludes {
-				// Use filepath.Match for directory exclusion
-				matched, _ := filepath.Match(pattern, relPath)
-				if matched {
-					isDirExcluded = true
-					break
-				}
-				// Handle dir/* exclude patterns
-				if strings.HasSuffix(pattern, "/*") || strings.HasSuffix(pattern, "/**") {
-					dirPattern := strings.TrimSuffix(strings.TrimSuffix(pattern, "*"), "/")
-					if dirPattern != "" && strings.HasPrefix(relPath, dirPattern+string(filepath.Separator)) {
-						isDirExcluded = true
-						break
-					}
-					if relPath == dirPattern { // Match the directory itself
-						isDirExcluded = true
-						break
-					}
-				}
-			}
-			if isDirExcluded {
-				return fs.SkipDir // Skip excluded directory
-			}
-			// Don't record directories during walk, only parents of found files later
-			return nil // Continue walking into the directory
-		}
-
-		// Check if the file should be included
-		if shouldInclude(relPath, includes, excludes) {
-			foundFiles = append(foundFiles, relPath)
-
-			// Add all parent directories to the set
-			dir := filepath.Dir(relPath)
-			for dir != "." && dir != "/" {
-				parentDirs[dir] = struct{}{}
-				dir = filepath.Dir(dir)
-			}
-		}
-
-		return nil
-	})
+// Entry is a file or directory found in one of the sources
+type Entry struct {
+	// Path is the path relative to the source root
+	Path string
+	// IsDir reports whether the entry is a parent directory of found files
+	IsDir bool
+	// Source is the source providing the entry. For colliding paths this is the
+	// source with the highest precedence (the one declared last).
+	Source Source
+	// Shadowed lists the lower-precedence sources that also contain Path, in declaration order
+	Shadowed []Source
+	// Meta is the front-matter of the file in Source, or nil
+	Meta frontmatter.Metadata
+	// Precedence is the position of Source among the searched sources
+	Precedence int
+	// Size and ModTime describe the file in Source, following a symbolic link.
+	// They are zero for directories.
+	Size    int64
+	ModTime time.Time
+}
 
+// Find searches for files in the given root directory and filters them based on opts
+func Find(rootDir string, opts Options) ([]string, error) {
+	entries, err := FindSources([]Source{{Name: rootDir, Root: rootDir}}, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	// Combine found files and parent directories (if not excluded)
-	finalResultsMap := make(map[string]struct{}) // Use map to auto-handle duplicates
-	for _, file := range foundFiles {
-		finalResultsMap[file] = struct{}{}
-	}
-	for dir := range parentDirs {
-		// Add directory only if it's NOT excluded itself
-		// We check exclusion by calling shouldInclude with only exclude patterns
-		if shouldInclude(dir, []string{"*"}, excludes) { // Check if it passes *any* include (placeholder *) against excludes
-			finalResultsMap[dir] = struct{}{}
-		}
+	finalResults := make([]string, len(entries))
+	for i, entry := range entries {
+		finalResults[i] = entry.Path
 	}
+	return finalResults, nil
+}
 
-	// Convert map keys to slice
-	finalResults := make([]string, 0, len(finalResultsMap))
-	for path := range finalResultsMap {
-		finalResults = append(finalResults, path)
-	}
+// FindSources searches every source with the same options and merges the results into one list.
+// When a relative path exists in several sources, the source declared later overrides the earlier ones.
+func FindSources(sources []Source, opts Options) ([]Entry, error) {
+	return StreamSources(context.Background(), sources, opts).Wait()
+}
 
-	// Sort the final results in reverse alphabetical order
-	sort.Sort(sort.Reverse(sort.StringSlice(finalResults)))
+// filter evaluates include patterns and the ordered exclude rules for a walk
+type filter struct {
+	includes []string
+	rules    pattern.Rules
+}
 
-	return finalResults, nil
+// newFilter creates a filter where plain excludes come first, followed by the explicit rules
+func newFilter(opts Options) *filter {
+	rules := pattern.ExcludeRules(opts.Excludes)
+	rules = append(rules, opts.Rules...)
+	return &filter{
+		includes: opts.Includes,
+		rules:    rules,
+	}
 }
 
-// shouldInclude determines if a file should be included based on
-// include and exclude patterns. It performs a basic check against the given path.
-func shouldInclude(path string, includes, excludes []string) bool {
-	// Check exclude patterns first (they take precedence)
-	for _, pattern := range excludes {
-		// Match against the full path or just the basename if the pattern doesn't contain a separator
-		base := filepath.Base(path)
-		matchPath, _ := filepath.Match(pattern, path)
-		matchBase := false
-		if !strings.Contains(pattern, string(filepath.Separator)) {
-			matchBase, _ = filepath.Match(pattern, base)
-		}
-		if matchPath || matchBase {
-			return false
-		}
-
-		// Handle directory exclude patterns specifically (e.g., "dir/*" or "dir/**")
-		// Check if the path is within an excluded directory pattern
-		if strings.HasSuffix(pattern, "/*") || strings.HasSuffix(pattern, "/**") {
-			dirPattern := strings.TrimSuffix(strings.TrimSuffix(pattern, "*"), "/")
-			// Ensure dirPattern is not empty and path actually starts with it + separator
-			if dirPattern != "" && strings.HasPrefix(path, dirPattern+string(filepath.Separator)) {
-				return false
-			}
-			// Also handle case where the excluded pattern *is* the directory path itself
-			if path == dirPattern {
-				return false
-			}
-		}
+// skipDir reports whether the walk can skip the directory entirely.
+// An excluded directory is still walked when a later rule may re-include something inside it.
+func (f *filter) skipDir(relPath string) bool {
+	return f.rules.Excluded(relPath, true) && !f.rules.MayReinclude(relPath)
+}
+
+// includeFile reports whether a file passes the rules and include patterns
+func (f *filter) includeFile(relPath string) bool {
+	// Check exclude rules first (they take precedence)
+	if f.rules.Excluded(relPath, false) {
+		return false
 	}
 
 	// If no include patterns are specified, include everything not excluded
-	if len(includes) == 0 {
+	if len(f.includes) == 0 {
 		return true
 	}
 
-	// Check include patterns
-	isIncluded := false
-	for _, pattern := range includes {
-		// Match against the full path or just the basename if the pattern doesn't contain a separator
-		base := filepath.Base(path)
-		matchPath, _ := filepath.Match(pattern, path)
-		matchBase := false
-		if !strings.Contains(pattern, string(filepath.Separator)) {
-			matchBase, _ = filepath.Match(pattern, base)
-		}
-		if matchPath || matchBase {
-			isIncluded = true
-			break // Found a matching include pattern
-		}
-
-		// Handle directory include patterns specifically (e.g., "dir/*" or "dir/**")
-		// Check if the path is within an included directory pattern
-		if strings.HasSuffix(pattern, "/*") || strings.HasSuffix(pattern, "/**") {
-			dirPattern := strings.TrimSuffix(strings.TrimSuffix(pattern, "*"), "/")
-			// Ensure dirPattern is not empty and path actually starts with it + separator
-			if dirPattern != "" && strings.HasPrefix(path, dirPattern+string(filepath.Separator)) {
-				isIncluded = true
-				break
-			}
-			// Also handle case where the included pattern *is* the directory path itself
-			if path == dirPattern {
-				isIncluded = true
-				break
-			}
-		}
-	}
+	return pattern.MatchAny(relPath, f.includes)
+}
 
-	return isIncluded
+// shouldInclude determines if a file should be included based on
+// include and exclude patterns. It performs a basic check against the given path.
+func shouldInclude(path string, includes, excludes []string) bool {
+	return newFilter(Options{Includes: includes, Excludes: excludes}).includeFile(path)
 }
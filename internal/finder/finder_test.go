@@ -1,11 +1,25 @@
 package finder
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
+	"syscall"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/upamune/airule/internal/attr"
+	"github.com/upamune/airule/internal/content"
+	"github.com/upamune/airule/internal/frontmatter"
+	"github.com/upamune/airule/internal/linkfs"
+	"github.com/upamune/airule/internal/pattern"
 )
 
 // setupTestDir creates a temporary test directory with the specified files
@@ -149,6 +163,27 @@ func TestFindFiles(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:     "Recursive doublestar include",
+			includes: []string{"dir3/**/*.go"},
+			excludes: []string{},
+			want:     []string{"dir3", "dir3/subdir", "dir3/subdir/file9.go"},
+			wantErr:  false,
+		},
+		{
+			name:     "Brace expansion include",
+			includes: []string{"*.{md,yaml}"},
+			excludes: []string{},
+			want:     []string{"dir3", "dir3/file7.yaml", "file3.md"},
+			wantErr:  false,
+		},
+		{
+			name:     "Doublestar exclude",
+			includes: []string{},
+			excludes: []string{"**/subdir/**", "dir[12]"},
+			want:     []string{"dir3", "dir3/file7.yaml", "file1.txt", "file2.go", "file3.md"},
+			wantErr:  false,
+		},
 		{
 			name:     "Non-existent directory",
 			includes: []string{},
@@ -341,3 +376,922 @@ func TestShouldInclude(t *testing.T) {
 		})
 	}
 }
+
+// TestFindWithRules tests ordered gitignore-style rules with negation
+func TestFindWithRules(t *testing.T) {
+	tempDir := t.TempDir()
+	files := []string{
+		"go.md",
+		"drafts/approved.md",
+		"drafts/wip.md",
+		"drafts/old/ancient.md",
+		"scratch/notes.md",
+	}
+	for _, file := range files {
+		filePath := filepath.Join(tempDir, file)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatalf("Failed to create directory for %s: %v", file, err)
+		}
+		if err := os.WriteFile(filePath, []byte("test content"), 0644); err != nil {
+			t.Fatalf("Failed to create file %s: %v", filePath, err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		excludes []string
+		rules    []string
+		want     []string
+	}{
+		{
+			name:  "Exclude directory but keep one file",
+			rules: []string{"drafts/", "!drafts/approved.md"},
+			want:  []string{"drafts/approved.md", "go.md", "scratch", "scratch/notes.md"},
+		},
+		{
+			name:     "Rule re-includes a file removed by --exclude",
+			excludes: []string{"scratch/*"},
+			rules:    []string{"!scratch/notes.md"},
+			want: []string{
+				"drafts", "drafts/approved.md", "drafts/old", "drafts/old/ancient.md",
+				"drafts/wip.md", "go.md", "scratch", "scratch/notes.md",
+			},
+		},
+		{
+			name:  "Last match wins",
+			rules: []string{"!go.md", "*.md", "!drafts/**"},
+			want:  []string{"drafts", "drafts/approved.md", "drafts/old", "drafts/old/ancient.md", "drafts/wip.md"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := pattern.ParseRules(tt.rules)
+			if err != nil {
+				t.Fatalf("ParseRules() error = %v", err)
+			}
+
+			got, err := Find(tempDir, Options{Excludes: tt.excludes, Rules: rules})
+			if err != nil {
+				t.Fatalf("Find() error = %v", err)
+			}
+
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Find() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFindWithIgnoreFiles tests that .gitignore and .airuleignore files prune the walk
+func TestFindWithIgnoreFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	files := map[string]string{
+		".gitignore":              "dist/\n",
+		".airuleignore":           "*.draft.md\n",
+		".git/HEAD":               "ref: refs/heads/main\n",
+		".git/info/exclude":       "local.md\n",
+		"go.md":                   "test content",
+		"local.md":                "test content",
+		"wip.draft.md":            "test content",
+		"dist/bundle.md":          "test content",
+		"rules/.gitignore":        "generated.md\n",
+		"rules/generated.md":      "test content",
+		"rules/typescript.md":     "test content",
+		"rules/nested/extra.md":   "test content",
+		"rules/nested/.gitkeep":   "",
+		"rules/nested/gen/a.md":   "test content",
+		"rules/nested/.gitignore": "gen/\n",
+	}
+	for file, content := range files {
+		filePath := filepath.Join(tempDir, file)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatalf("Failed to create directory for %s: %v", file, err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file %s: %v", filePath, err)
+		}
+	}
+
+	got, err := Find(tempDir, Options{Includes: []string{"*.md"}, IgnoreFiles: []string{".gitignore", ".airuleignore"}})
+	if err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+	sort.Strings(got)
+	want := []string{"go.md", "rules", "rules/nested", "rules/nested/extra.md", "rules/typescript.md"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Find() = %v, want %v", got, want)
+	}
+
+	// Without ignore files everything is listed again
+	got, err = Find(tempDir, Options{Includes: []string{"*.md"}})
+	if err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+	if len(got) <= len(want) {
+		t.Errorf("Find() without ignore files = %v, want more entries than %v", got, want)
+	}
+}
+
+// TestFindWithInvalidIgnoreFile tests that a malformed ignore file is reported without aborting the walk
+func TestFindWithInvalidIgnoreFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		".gitignore":          {Data: []byte("*.log\n")},
+		"go/style.md":         {Data: []byte("style")},
+		"go/debug.log":        {Data: []byte("log")},
+		"go/.airuleignore":    {Data: []byte("*.{md\n")},
+		"typescript/style.md": {Data: []byte("style")},
+	}
+
+	var warnings []Warning
+	entries, err := FindSources([]Source{{Name: "rules", FS: fsys}}, Options{
+		Includes:    []string{"*.md"},
+		IgnoreFiles: []string{".gitignore", ".airuleignore"},
+		OnWarning:   func(w Warning) { warnings = append(warnings, w) },
+	})
+	if err != nil {
+		t.Fatalf("FindSources() error = %v", err)
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		paths = append(paths, entry.Path)
+	}
+	sort.Strings(paths)
+	want := []string{"go", "go/style.md", "typescript", "typescript/style.md"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("FindSources() = %v, want %v", paths, want)
+	}
+
+	if len(warnings) != 1 || warnings[0].Path != "go/.airuleignore" || warnings[0].Kind != WarningInvalidIgnoreFile {
+		t.Errorf("warnings = %v, want one invalid ignore file warning for go/.airuleignore", warnings)
+	}
+}
+
+// TestFindSources tests merging several sources with precedence for colliding paths
+func TestFindSources(t *testing.T) {
+	createFiles := func(t *testing.T, files []string) string {
+		t.Helper()
+		root := t.TempDir()
+		for _, file := range files {
+			filePath := filepath.Join(root, file)
+			if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+				t.Fatalf("Failed to create directory for %s: %v", file, err)
+			}
+			if err := os.WriteFile(filePath, []byte("test content"), 0644); err != nil {
+				t.Fatalf("Failed to create file %s: %v", filePath, err)
+			}
+		}
+		return root
+	}
+
+	org := Source{Name: "org", Root: createFiles(t, []string{"common.md", "go/style.md", "go/testing.md"})}
+	team := Source{Name: "team", Root: createFiles(t, []string{"go/style.md", "team.md"})}
+
+	entries, err := FindSources([]Source{org, team}, Options{})
+	if err != nil {
+		t.Fatalf("FindSources() error = %v", err)
+	}
+
+	type result struct {
+		isDir    bool
+		source   string
+		shadowed []string
+	}
+	got := make(map[string]result)
+	var paths []string
+	for _, entry := range entries {
+		var shadowed []string
+		for _, src := range entry.Shadowed {
+			shadowed = append(shadowed, src.Name)
+		}
+		got[entry.Path] = result{isDir: entry.IsDir, source: entry.Source.Name, shadowed: shadowed}
+		paths = append(paths, entry.Path)
+	}
+
+	want := map[string]result{
+		"common.md":     {source: "org"},
+		"go":            {isDir: true, source: "team", shadowed: []string{"org"}},
+		"go/style.md":   {source: "team", shadowed: []string{"org"}},
+		"go/testing.md": {source: "org"},
+		"team.md":       {source: "team"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FindSources() = %+v, want %+v", got, want)
+	}
+
+	// The merged list keeps the reverse alphabetical order
+	wantOrder := []string{"team.md", "go/testing.md", "go/style.md", "go", "common.md"}
+	if !reflect.DeepEqual(paths, wantOrder) {
+		t.Errorf("FindSources() order = %v, want %v", paths, wantOrder)
+	}
+
+	// A missing source is reported with its name
+	_, err = FindSources([]Source{org, {Name: "missing", Root: filepath.Join(t.TempDir(), "missing")}}, Options{})
+	if err == nil {
+		t.Error("FindSources() should fail for a missing source")
+	}
+}
+
+// TestFindSourcesKindCollision tests a later source with a file where an earlier one has a directory, and the other way round
+func TestFindSourcesKindCollision(t *testing.T) {
+	org := Source{Name: "org", FS: fstest.MapFS{
+		"go/style.md": {Data: []byte("style")},
+		"typescript":  {Data: []byte("file")},
+	}}
+	team := Source{Name: "team", FS: fstest.MapFS{
+		"go":                  {Data: []byte("file")},
+		"typescript/style.md": {Data: []byte("style")},
+	}}
+
+	type result struct {
+		isDir    bool
+		source   string
+		shadowed int
+	}
+	tests := []struct {
+		name string
+		mode EntryMode
+		want map[string]result
+	}{
+		{
+			name: "Both",
+			mode: EntriesBoth,
+			want: map[string]result{
+				"go":                  {source: "team"},
+				"go/style.md":         {source: "org"},
+				"typescript":          {isDir: true, source: "team"},
+				"typescript/style.md": {source: "team"},
+			},
+		},
+		{
+			name: "Files only",
+			mode: EntriesFiles,
+			want: map[string]result{
+				"go":                  {source: "team"},
+				"go/style.md":         {source: "org"},
+				"typescript/style.md": {source: "team"},
+			},
+		},
+		{
+			name: "Directories only",
+			mode: EntriesDirs,
+			want: map[string]result{
+				"typescript": {isDir: true, source: "team"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := StreamSources(context.Background(), []Source{org, team}, Options{Entries: tt.mode})
+			entries, err := stream.Wait()
+			if err != nil {
+				t.Fatalf("Wait() error = %v", err)
+			}
+			got := make(map[string]result)
+			for _, entry := range entries {
+				got[entry.Path] = result{isDir: entry.IsDir, source: entry.Source.Name, shadowed: len(entry.Shadowed)}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entries = %+v, want %+v", got, tt.want)
+			}
+
+			// The file replaced by a directory is not copied with it
+			if files := stream.FilesBelow("typescript"); len(files) != 1 || files[0].Path != "typescript/style.md" {
+				t.Errorf("FilesBelow(typescript) = %v, want only typescript/style.md", files)
+			}
+		})
+	}
+}
+
+// TestFindSourcesFromFS tests walking a source that is not a directory on disk
+func TestFindSourcesFromFS(t *testing.T) {
+	archive := Source{Name: "rules.zip", Root: "/srv/rules.zip", FS: fstest.MapFS{
+		"go/style.md":   {Data: []byte("style")},
+		"go/tmp.log":    {Data: []byte("log")},
+		"ignored.md":    {Data: []byte("ignored")},
+		".airuleignore": {Data: []byte("ignored.md\n")},
+	}}
+
+	entries, err := FindSources([]Source{archive}, Options{Excludes: []string{"*.log"}, IgnoreFiles: []string{".airuleignore"}})
+	if err != nil {
+		t.Fatalf("FindSources() error = %v", err)
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if entry.Source.Name != "rules.zip" {
+			t.Errorf("entry %s source = %q, want rules.zip", entry.Path, entry.Source.Name)
+		}
+		paths = append(paths, entry.Path)
+	}
+	want := []string{"go/style.md", "go", ".airuleignore"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("FindSources() = %v, want %v", paths, want)
+	}
+}
+
+// TestFindWithWhere tests filtering files by their front-matter
+func TestFindWithWhere(t *testing.T) {
+	src := Source{Name: "rules", FS: fstest.MapFS{
+		"go/style.md":      {Data: []byte("---\ndescription: Go style\ntags: [go, style]\nalwaysApply: true\n---\n")},
+		"go/testing.mdc":   {Data: []byte("---\ntags: [go, testing]\n---\n")},
+		"ts/style.md":      {Data: []byte("---\ntags: [typescript]\nalwaysApply: true\n---\n")},
+		"notes.md":         {Data: []byte("no front-matter")},
+		"broken.md":        {Data: []byte("---\ntags: [unclosed\n---\n")},
+		"config/tags.yaml": {Data: []byte("---\ntags: [go]\n---\n")},
+	}}
+
+	tests := []struct {
+		name  string
+		where []string
+		want  []string
+	}{
+		{
+			name: "No conditions",
+			want: []string{"ts/style.md", "ts", "notes.md", "go/testing.mdc", "go/style.md", "go", "config/tags.yaml", "config", "broken.md"},
+		},
+		{
+			name:  "List contains",
+			where: []string{"tags contains go"},
+			want:  []string{"go/testing.mdc", "go/style.md", "go"},
+		},
+		{
+			name:  "Boolean equality",
+			where: []string{"alwaysApply=true"},
+			want:  []string{"ts/style.md", "ts", "go/style.md", "go"},
+		},
+		{
+			name:  "All conditions must match",
+			where: []string{"tags contains go", "alwaysApply=true"},
+			want:  []string{"go/style.md", "go"},
+		},
+		{
+			name:  "Negation includes files without the field",
+			where: []string{"tags !contains go"},
+			want:  []string{"ts/style.md", "ts", "notes.md", "config/tags.yaml", "config", "broken.md"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, err := frontmatter.ParseConditions(tt.where)
+			if err != nil {
+				t.Fatalf("ParseConditions() error = %v", err)
+			}
+			entries, err := FindSources([]Source{src}, Options{Where: where})
+			if err != nil {
+				t.Fatalf("FindSources() error = %v", err)
+			}
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindSources() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The metadata of found files is attached to the entries
+	entries, err := FindSources([]Source{src}, Options{})
+	if err != nil {
+		t.Fatalf("FindSources() error = %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Path == "go/style.md" && entry.Meta.Description() != "Go style" {
+			t.Errorf("entry %s description = %q", entry.Path, entry.Meta.Description())
+		}
+		if entry.IsDir && entry.Meta != nil {
+			t.Errorf("directory %s has metadata %v", entry.Path, entry.Meta)
+		}
+	}
+}
+
+// TestFindWithContent tests filtering files by regular expressions on their content
+func TestFindWithContent(t *testing.T) {
+	src := Source{Name: "rules", FS: fstest.MapFS{
+		"go/lint.md":     {Data: []byte("Run golangci-lint before pushing.")},
+		"go/style.md":    {Data: []byte("Use gofmt.")},
+		"ci/lint.md":     {Data: []byte("GOLANGCI-LINT runs in CI.")},
+		"assets/logo.md": {Data: []byte("golangci-lint\x00")},
+	}}
+
+	compile := func(expr string, ignoreCase, invert bool) content.Predicate {
+		p, err := content.Compile(expr, ignoreCase, invert)
+		if err != nil {
+			t.Fatalf("Compile(%q) error = %v", expr, err)
+		}
+		return p
+	}
+
+	tests := []struct {
+		name       string
+		predicates []content.Predicate
+		want       []string
+	}{
+		{
+			name:       "Contains",
+			predicates: []content.Predicate{compile("golangci-lint", false, false)},
+			want:       []string{"go/lint.md", "go"},
+		},
+		{
+			name:       "Ignore case",
+			predicates: []content.Predicate{compile("golangci-lint", true, false)},
+			want:       []string{"go/lint.md", "go", "ci/lint.md", "ci"},
+		},
+		{
+			name:       "Not contains",
+			predicates: []content.Predicate{compile("golangci-lint", true, true)},
+			want:       []string{"go/style.md", "go"},
+		},
+		{
+			name:       "All predicates must match",
+			predicates: []content.Predicate{compile("lint", true, false), compile("CI", false, true)},
+			want:       []string{"go/lint.md", "go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := FindSources([]Source{src}, Options{Content: content.Matcher{Predicates: tt.predicates}})
+			if err != nil {
+				t.Fatalf("FindSources() error = %v", err)
+			}
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindSources() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindWithAttrs(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	src := Source{Name: "rules", FS: fstest.MapFS{
+		"go/style.md":    {Data: []byte("Use gofmt."), ModTime: now.Add(-day)},
+		"go/drafts.md":   {Data: []byte("TODO"), ModTime: now.Add(-60 * day)},
+		"gen/dump.md":    {Data: []byte(strings.Repeat("rule\n", 1000)), ModTime: now.Add(-day)},
+		"assets/logo.md": {Data: []byte("\x89PNG\x00"), ModTime: now.Add(-day)},
+	}}
+
+	tests := []struct {
+		name  string
+		attrs attr.Filter
+		want  []string
+	}{
+		{name: "Max size", attrs: attr.Filter{MaxSize: 1024}, want: []string{"go/style.md", "go/drafts.md", "go", "assets/logo.md", "assets"}},
+		{name: "Min size", attrs: attr.Filter{MinSize: 1024}, want: []string{"gen/dump.md", "gen"}},
+		{name: "Newer than", attrs: attr.Filter{NewerThan: 7 * day}, want: []string{"go/style.md", "go", "gen/dump.md", "gen", "assets/logo.md", "assets"}},
+		{name: "Older than", attrs: attr.Filter{OlderThan: 7 * day}, want: []string{"go/drafts.md", "go"}},
+		{name: "Text", attrs: attr.Filter{Type: attr.TypeText, MaxSize: 1024}, want: []string{"go/style.md", "go/drafts.md", "go"}},
+		{name: "Binary", attrs: attr.Filter{Type: attr.TypeBinary}, want: []string{"assets/logo.md", "assets"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.attrs.Now = now
+			entries, err := FindSources([]Source{src}, Options{Attrs: tt.attrs})
+			if err != nil {
+				t.Fatalf("FindSources() error = %v", err)
+			}
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindSources() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// errorFS is a MapFS whose ReadDir fails for selected directories
+type errorFS struct {
+	fstest.MapFS
+	errs map[string]error
+}
+
+func (f errorFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if err, ok := f.errs[name]; ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return f.MapFS.ReadDir(name)
+}
+
+// TestFindWarnings tests that every class of walk error is reported and the rest of the tree is still found
+func TestFindWarnings(t *testing.T) {
+	fsys := errorFS{
+		MapFS: fstest.MapFS{
+			"ok.md":            {Data: []byte("ok")},
+			"secret/hidden.md": {Data: []byte("hidden")},
+			"deep/nested.md":   {Data: []byte("nested")},
+			"flaky/file.md":    {Data: []byte("flaky")},
+			"broken-meta.md":   {Data: []byte("---\ntags: [unclosed\n---\n")},
+			"go/style.md":      {Data: []byte("style")},
+			"go/more/extra.md": {Data: []byte("extra")},
+		},
+		errs: map[string]error{
+			"secret": fs.ErrPermission,
+			"deep":   syscall.ENAMETOOLONG,
+			"flaky":  errors.New("input/output error"),
+		},
+	}
+
+	var warnings []Warning
+	entries, err := FindSources([]Source{{Name: "rules", FS: fsys}}, Options{
+		OnWarning: func(w Warning) { warnings = append(warnings, w) },
+	})
+	if err != nil {
+		t.Fatalf("FindSources() error = %v", err)
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		paths = append(paths, entry.Path)
+	}
+	wantPaths := []string{"ok.md", "go/style.md", "go/more/extra.md", "go/more", "go", "broken-meta.md"}
+	if !reflect.DeepEqual(paths, wantPaths) {
+		t.Errorf("FindSources() = %v, want %v", paths, wantPaths)
+	}
+
+	got := make(map[string]WarningKind)
+	for _, w := range warnings {
+		if w.Source != "rules" || w.Err == nil {
+			t.Errorf("warning %+v is missing its source or error", w)
+		}
+		got[w.Path] = w.Kind
+	}
+	want := map[string]WarningKind{
+		"secret":         WarningUnreadable,
+		"deep":           WarningPathTooLong,
+		"flaky":          WarningOther,
+		"broken-meta.md": WarningInvalidFrontMatter,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("warnings = %v, want %v", got, want)
+	}
+
+	// Without a callback warnings are discarded
+	if _, err := FindSources([]Source{{Name: "rules", FS: fsys}}, Options{}); err != nil {
+		t.Errorf("FindSources() without OnWarning error = %v", err)
+	}
+}
+
+// TestFindWarningsOnDisk tests broken symlinks and unreadable directories on a real file system
+func TestFindWarningsOnDisk(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "ok.md"), []byte("ok"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := os.Symlink("ok.md", filepath.Join(root, "link.md")); err != nil {
+		t.Skipf("symlinks are not supported: %v", err)
+	}
+	if err := os.Symlink("missing.md", filepath.Join(root, "broken.md")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	secret := filepath.Join(root, "secret")
+	if err := os.MkdirAll(secret, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(secret, "hidden.md"), []byte("hidden"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := os.Chmod(secret, 0); err != nil {
+		t.Fatalf("Failed to change permissions: %v", err)
+	}
+	t.Cleanup(func() { os.Chmod(secret, 0755) })
+	// Privileged users can read the directory anyway
+	_, readErr := os.ReadDir(secret)
+
+	var warnings []Warning
+	entries, err := FindSources([]Source{{Name: root, Root: root}}, Options{
+		OnWarning: func(w Warning) { warnings = append(warnings, w) },
+	})
+	if err != nil {
+		t.Fatalf("FindSources() error = %v", err)
+	}
+
+	got := make(map[string]WarningKind)
+	for _, w := range warnings {
+		got[w.Path] = w.Kind
+	}
+	want := map[string]WarningKind{"broken.md": WarningBrokenSymlink}
+	if readErr != nil {
+		want["secret"] = WarningUnreadable
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("warnings = %v, want %v", got, want)
+	}
+
+	for _, entry := range entries {
+		if entry.Path == "broken.md" {
+			t.Error("a broken symlink should not be listed")
+		}
+	}
+}
+
+// TestFindSymlinks tests the symbolic link policies, including a link forming a loop
+func TestFindSymlinks(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "shared"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "shared", "go.md"), []byte("go"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "rules"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	links := map[string]string{
+		"rules/go.md":  "../shared/go.md",
+		"rules/shared": "../shared",
+		"rules/loop":   "..",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Skipf("symlinks are not supported: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		policy       linkfs.Policy
+		want         []string
+		wantWarnings map[string]WarningKind
+	}{
+		{
+			name:   "Follow walks linked directories and skips loops",
+			policy: linkfs.Follow,
+			want: []string{
+				"rules", "rules/go.md", "rules/shared", "rules/shared/go.md",
+				"shared", "shared/go.md",
+			},
+			wantWarnings: map[string]WarningKind{"rules/loop": WarningSymlinkLoop},
+		},
+		{
+			name:   "Preserve lists links as files",
+			policy: linkfs.Preserve,
+			want: []string{
+				"rules", "rules/go.md", "rules/loop", "rules/shared",
+				"shared", "shared/go.md",
+			},
+			wantWarnings: map[string]WarningKind{},
+		},
+		{
+			name:         "Skip omits links",
+			policy:       linkfs.Skip,
+			want:         []string{"shared", "shared/go.md"},
+			wantWarnings: map[string]WarningKind{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings := make(map[string]WarningKind)
+			entries, err := FindSources([]Source{{Name: root, Root: root}}, Options{
+				Symlinks:  tt.policy,
+				OnWarning: func(w Warning) { warnings[w.Path] = w.Kind },
+			})
+			if err != nil {
+				t.Fatalf("FindSources() error = %v", err)
+			}
+
+			var got []string
+			for _, entry := range entries {
+				got = append(got, filepath.ToSlash(entry.Path))
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindSources() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(warnings, tt.wantWarnings) {
+				t.Errorf("warnings = %v, want %v", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+// TestStreamSources tests that the concurrent walk finds the same entries in the same final
+// order whatever the number of workers, and that entries keep their index while streaming
+func TestStreamSources(t *testing.T) {
+	tree := fstest.MapFS{
+		".gitignore": {Data: []byte("*.tmp\n")},
+	}
+	for d := 0; d < 20; d++ {
+		for f := 0; f < 10; f++ {
+			tree[fmt.Sprintf("dir%02d/sub%d/rule%d.md", d, f%3, f)] = &fstest.MapFile{Data: []byte("rule")}
+			tree[fmt.Sprintf("dir%02d/scratch%d.tmp", d, f)] = &fstest.MapFile{Data: []byte("tmp")}
+		}
+		tree[fmt.Sprintf("dir%02d/.gitignore", d)] = &fstest.MapFile{Data: []byte("sub2/\n")}
+	}
+	org := Source{Name: "org", FS: tree}
+	team := Source{Name: "team", FS: fstest.MapFS{"dir00/sub0/rule0.md": {Data: []byte("team rule")}}}
+	opts := Options{IgnoreFiles: []string{".gitignore"}}
+
+	baseline, err := FindSources([]Source{org, team}, Options{IgnoreFiles: opts.IgnoreFiles, Workers: 1})
+	if err != nil {
+		t.Fatalf("FindSources() error = %v", err)
+	}
+	// 20 dirs with 7 rules in sub0 and sub1, the two subdirectories, the dir itself and the root .gitignore
+	if want := 20*(7+2+1+1) + 1; len(baseline) != want {
+		t.Fatalf("FindSources() found %d entries, want %d", len(baseline), want)
+	}
+
+	for _, workers := range []int{2, 8, 32} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			opts.Workers = workers
+			stream := StreamSources(context.Background(), []Source{org, team}, opts)
+
+			// Record the entries as they appear; their paths must not move
+			seen := make(map[int]string)
+			for {
+				done := isDone(stream)
+				n := stream.Len()
+				for i := 0; i < n; i++ {
+					path := stream.Entry(i).Path
+					if prev, ok := seen[i]; ok && prev != path {
+						t.Fatalf("entry %d changed from %s to %s", i, prev, path)
+					}
+					seen[i] = path
+				}
+				if done {
+					break
+				}
+			}
+
+			got, err := stream.Wait()
+			if err != nil {
+				t.Fatalf("Wait() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, baseline) {
+				t.Errorf("Wait() with %d workers differs from the sequential walk", workers)
+			}
+		})
+	}
+
+	// The later source overrides the earlier one for the same path
+	for _, entry := range baseline {
+		if entry.Path == filepath.Join("dir00", "sub0", "rule0.md") {
+			if entry.Source.Name != "team" || len(entry.Shadowed) != 1 || entry.Shadowed[0].Name != "org" {
+				t.Errorf("entry %s source = %s, shadowed = %v, want team over org", entry.Path, entry.Source.Name, entry.Shadowed)
+			}
+		}
+	}
+}
+
+// isDone reports whether the stream has finished
+func isDone(s *Stream) bool {
+	select {
+	case <-s.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// TestStreamSourcesCancel tests that cancelling the context stops the walk
+func TestStreamSourcesCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	fsys := cancelFS{FS: fstest.MapFS{
+		"a/rule.md": {Data: []byte("a")},
+		"b/rule.md": {Data: []byte("b")},
+	}, cancel: cancel}
+
+	stream := StreamSources(ctx, []Source{{Name: "rules", FS: fsys}}, Options{Workers: 1})
+	<-stream.Ready()
+	if _, err := stream.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() error = %v, want context.Canceled", err)
+	}
+}
+
+// cancelFS cancels a context when the root directory is read
+type cancelFS struct {
+	fs.FS
+	cancel context.CancelFunc
+}
+
+func (c cancelFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name == "." {
+		c.cancel()
+	}
+	return fs.ReadDir(c.FS, name)
+}
+
+// TestStreamReadyDirsMode tests that files which are not listed do not make the list ready
+func TestStreamReadyDirsMode(t *testing.T) {
+	release := make(chan struct{})
+	fsys := blockFS{FS: fstest.MapFS{
+		"common.md":   {Data: []byte("common")},
+		"go/style.md": {Data: []byte("style")},
+	}, dir: "go", release: release}
+
+	stream := StreamSources(context.Background(), []Source{{Name: "rules", FS: fsys}}, Options{Entries: EntriesDirs})
+	select {
+	case <-stream.Ready():
+		t.Fatalf("Ready() closed with %d entries before any directory was found", stream.Len())
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-stream.Ready()
+	entries, err := stream.Wait()
+	if err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Path != "go" {
+		t.Errorf("Wait() = %v, want only go", entries)
+	}
+}
+
+// blockFS blocks reading the directory dir until release is closed
+type blockFS struct {
+	fs.FS
+	dir     string
+	release <-chan struct{}
+}
+
+func (b blockFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name == b.dir {
+		<-b.release
+	}
+	return fs.ReadDir(b.FS, name)
+}
+
+func TestStreamEntryModes(t *testing.T) {
+	org := Source{Name: "org", FS: fstest.MapFS{
+		"go/style.md":   {Data: []byte("org style")},
+		"go/testing.md": {Data: []byte("org testing")},
+	}}
+	team := Source{Name: "team", FS: fstest.MapFS{
+		"go/style.md":       {Data: []byte("team style")},
+		"go/draft/wip.md":   {Data: []byte("wip")},
+		"go/private/key.md": {Data: []byte("key")},
+		"common.md":         {Data: []byte("common")},
+	}}
+	sources := []Source{org, team}
+
+	tests := []struct {
+		name string
+		mode EntryMode
+		want []string
+	}{
+		{"both", EntriesBoth, []string{"common.md", "go", "go/draft", "go/draft/wip.md", "go/style.md", "go/testing.md"}},
+		{"files", EntriesFiles, []string{"common.md", "go/draft/wip.md", "go/style.md", "go/testing.md"}},
+		{"dirs", EntriesDirs, []string{"go", "go/draft"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := StreamSources(context.Background(), sources, Options{
+				Excludes: []string{"go/private"},
+				Entries:  tt.mode,
+			})
+			entries, err := stream.Wait()
+			if err != nil {
+				t.Fatalf("Wait() error = %v", err)
+			}
+			var got []string
+			for i := len(entries) - 1; i >= 0; i-- {
+				got = append(got, filepath.ToSlash(entries[i].Path))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entries = %v, want %v", got, tt.want)
+			}
+
+			// Files below a directory are known whatever is listed, and never include excluded files
+			below := stream.FilesBelow("go")
+			var paths []string
+			for _, entry := range below {
+				paths = append(paths, filepath.ToSlash(entry.Path))
+			}
+			wantBelow := []string{"go/draft/wip.md", "go/style.md", "go/testing.md"}
+			if !reflect.DeepEqual(paths, wantBelow) {
+				t.Fatalf("FilesBelow(go) = %v, want %v", paths, wantBelow)
+			}
+			if style := below[1]; style.Source.Name != "team" || len(style.Shadowed) != 1 || style.Shadowed[0].Name != "org" {
+				t.Errorf("FilesBelow(go) style.md = %+v, want team overriding org", style)
+			}
+			if got := stream.FilesBelow("go/draft/wip.md"); len(got) != 0 {
+				t.Errorf("FilesBelow(file) = %v, want none", got)
+			}
+		})
+	}
+}
+
+func TestParseEntryMode(t *testing.T) {
+	for _, mode := range []EntryMode{EntriesBoth, EntriesFiles, EntriesDirs} {
+		got, err := ParseEntryMode(mode.String())
+		if err != nil || got != mode {
+			t.Errorf("ParseEntryMode(%q) = %v, %v", mode.String(), got, err)
+		}
+	}
+	if _, err := ParseEntryMode("links"); err == nil {
+		t.Error("ParseEntryMode(links) should fail")
+	}
+}
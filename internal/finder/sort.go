@@ -0,0 +1,216 @@
+package finder
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SortKey is the property entries are sorted by
+type SortKey int
+
+const (
+	// SortPath sorts by relative path, keeping every directory next to its content
+	SortPath SortKey = iota
+	// SortName sorts by base name
+	SortName
+	// SortModTime sorts by modification time
+	SortModTime
+	// SortSize sorts by size
+	SortSize
+	// SortDepth sorts by the number of path elements, shallow entries first
+	SortDepth
+)
+
+// sortKeys maps the names accepted by ParseSortKey to sort keys
+var sortKeys = map[string]SortKey{
+	"path":  SortPath,
+	"name":  SortName,
+	"mtime": SortModTime,
+	"size":  SortSize,
+	"depth": SortDepth,
+}
+
+// ParseSortKey parses "path", "name", "mtime", "size" or "depth"
+func ParseSortKey(s string) (SortKey, error) {
+	if s == "" {
+		return SortPath, nil
+	}
+	if key, ok := sortKeys[s]; ok {
+		return key, nil
+	}
+	return SortPath, fmt.Errorf("invalid sort key %q: expected path, name, mtime, size or depth", s)
+}
+
+// String returns the name of the sort key
+func (k SortKey) String() string {
+	switch k {
+	case SortName:
+		return "name"
+	case SortModTime:
+		return "mtime"
+	case SortSize:
+		return "size"
+	case SortDepth:
+		return "depth"
+	default:
+		return "path"
+	}
+}
+
+// Grouping gathers entries into groups that are listed one after another
+type Grouping int
+
+const (
+	// GroupNone lists all entries in one sequence
+	GroupNone Grouping = iota
+	// GroupFolder groups entries by their top-level folder; files at the root come first
+	GroupFolder
+	// GroupSource groups entries by the source providing them, in declaration order
+	GroupSource
+)
+
+// ParseGrouping parses "none", "folder" or "source"
+func ParseGrouping(s string) (Grouping, error) {
+	switch s {
+	case "", "none":
+		return GroupNone, nil
+	case "folder":
+		return GroupFolder, nil
+	case "source":
+		return GroupSource, nil
+	}
+	return GroupNone, fmt.Errorf("invalid grouping %q: expected none, folder or source", s)
+}
+
+// String returns the name of the grouping
+func (g Grouping) String() string {
+	switch g {
+	case GroupFolder:
+		return "folder"
+	case GroupSource:
+		return "source"
+	default:
+		return "none"
+	}
+}
+
+// Order describes the order entries are listed in, from the top of the picker down.
+// The zero value lists entries alphabetically by path.
+type Order struct {
+	Key SortKey
+	// Descending reverses the order of Key. Groups and DirsFirst are not affected.
+	Descending bool
+	// DirsFirst lists the directories of a group before its files
+	DirsFirst bool
+	Group     Grouping
+}
+
+// dirStats are the total size and newest modification time of the files listed below a directory
+type dirStats struct {
+	size    int64
+	modTime time.Time
+}
+
+// SortEntries sorts entries for the picker. The picker shows the last entry at the top,
+// so the entries end up in the reverse of the order described by order.
+// Directories sort by the total size and the newest modification time of the files listed below them.
+func SortEntries(entries []Entry, order Order) {
+	stats := make(map[string]dirStats)
+	if order.Key == SortSize || order.Key == SortModTime {
+		for _, entry := range entries {
+			if entry.IsDir {
+				continue
+			}
+			for dir := filepath.Dir(entry.Path); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+				s := stats[dir]
+				s.size += entry.Size
+				if entry.ModTime.After(s.modTime) {
+					s.modTime = entry.ModTime
+				}
+				stats[dir] = s
+			}
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return order.before(entries[j], entries[i], stats)
+	})
+}
+
+// before reports whether a is listed above b
+func (o Order) before(a, b Entry, stats map[string]dirStats) bool {
+	switch o.Group {
+	case GroupFolder:
+		if ga, gb := topLevel(a), topLevel(b); ga != gb {
+			return ga < gb
+		}
+	case GroupSource:
+		if a.Precedence != b.Precedence {
+			return a.Precedence < b.Precedence
+		}
+	}
+
+	if o.DirsFirst && a.IsDir != b.IsDir {
+		return a.IsDir
+	}
+
+	if c := o.compare(a, b, stats); c != 0 {
+		if o.Descending {
+			return c > 0
+		}
+		return c < 0
+	}
+	// Paths are unique, which keeps the order deterministic
+	return filepath.ToSlash(a.Path) < filepath.ToSlash(b.Path)
+}
+
+// compare compares two entries by the sort key, returning a negative number when a comes first
+func (o Order) compare(a, b Entry, stats map[string]dirStats) int {
+	switch o.Key {
+	case SortName:
+		return strings.Compare(filepath.Base(a.Path), filepath.Base(b.Path))
+	case SortModTime:
+		return a.stats(stats).modTime.Compare(b.stats(stats).modTime)
+	case SortSize:
+		sa, sb := a.stats(stats).size, b.stats(stats).size
+		switch {
+		case sa < sb:
+			return -1
+		case sa > sb:
+			return 1
+		}
+		return 0
+	case SortDepth:
+		return depth(a.Path) - depth(b.Path)
+	default:
+		return strings.Compare(filepath.ToSlash(a.Path), filepath.ToSlash(b.Path))
+	}
+}
+
+// stats returns the size and modification time an entry is sorted by
+func (e Entry) stats(dirs map[string]dirStats) dirStats {
+	if e.IsDir {
+		return dirs[e.Path]
+	}
+	return dirStats{size: e.Size, modTime: e.ModTime}
+}
+
+// topLevel returns the top-level folder of an entry, or "" for files at the root
+func topLevel(e Entry) string {
+	p := filepath.ToSlash(e.Path)
+	if i := strings.Index(p, "/"); i >= 0 {
+		return p[:i]
+	}
+	if e.IsDir {
+		return p
+	}
+	return ""
+}
+
+// depth returns the number of elements of a relative path
+func depth(relPath string) int {
+	return strings.Count(filepath.ToSlash(relPath), "/") + 1
+}
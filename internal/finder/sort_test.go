@@ -0,0 +1,112 @@
+package finder
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestSortEntries tests every sort key, descending order, directories first and grouping
+func TestSortEntries(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	entries := []Entry{
+		{Path: "README.md", Size: 50, ModTime: day(1)},
+		{Path: "go", IsDir: true},
+		{Path: "go/style.md", Size: 300, ModTime: day(2), Precedence: 1},
+		{Path: "go/testing", IsDir: true, Precedence: 1},
+		{Path: "go/testing/unit.md", Size: 10, ModTime: day(5), Precedence: 1},
+		{Path: "ts", IsDir: true},
+		{Path: "ts/a.md", Size: 100, ModTime: day(3)},
+	}
+
+	tests := []struct {
+		name  string
+		order Order
+		want  []string // top of the picker first
+	}{
+		{
+			name:  "Default is by path",
+			order: Order{},
+			want:  []string{"README.md", "go", "go/style.md", "go/testing", "go/testing/unit.md", "ts", "ts/a.md"},
+		},
+		{
+			name:  "Path descending",
+			order: Order{Descending: true},
+			want:  []string{"ts/a.md", "ts", "go/testing/unit.md", "go/testing", "go/style.md", "go", "README.md"},
+		},
+		{
+			name:  "Name",
+			order: Order{Key: SortName},
+			want:  []string{"README.md", "ts/a.md", "go", "go/style.md", "go/testing", "ts", "go/testing/unit.md"},
+		},
+		{
+			name:  "Size with directory totals",
+			order: Order{Key: SortSize, Descending: true},
+			want:  []string{"go", "go/style.md", "ts", "ts/a.md", "README.md", "go/testing", "go/testing/unit.md"},
+		},
+		{
+			name:  "Modification time with the newest file below directories",
+			order: Order{Key: SortModTime},
+			want:  []string{"README.md", "go/style.md", "ts", "ts/a.md", "go", "go/testing", "go/testing/unit.md"},
+		},
+		{
+			name:  "Depth",
+			order: Order{Key: SortDepth},
+			want:  []string{"README.md", "go", "ts", "go/style.md", "go/testing", "ts/a.md", "go/testing/unit.md"},
+		},
+		{
+			name:  "Directories first",
+			order: Order{DirsFirst: true},
+			want:  []string{"go", "go/testing", "ts", "README.md", "go/style.md", "go/testing/unit.md", "ts/a.md"},
+		},
+		{
+			name:  "Grouped by folder, directories first",
+			order: Order{Group: GroupFolder, DirsFirst: true},
+			want:  []string{"README.md", "go", "go/testing", "go/style.md", "go/testing/unit.md", "ts", "ts/a.md"},
+		},
+		{
+			name:  "Grouped by source",
+			order: Order{Group: GroupSource},
+			want:  []string{"README.md", "go", "ts", "ts/a.md", "go/style.md", "go/testing", "go/testing/unit.md"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorted := append([]Entry(nil), entries...)
+			SortEntries(sorted, tt.order)
+
+			// The picker shows the last entry at the top
+			var got []string
+			for i := len(sorted) - 1; i >= 0; i-- {
+				got = append(got, sorted[i].Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SortEntries() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseOrder tests parsing sort keys and groupings
+func TestParseOrder(t *testing.T) {
+	for _, name := range []string{"path", "name", "mtime", "size", "depth"} {
+		key, err := ParseSortKey(name)
+		if err != nil || key.String() != name {
+			t.Errorf("ParseSortKey(%q) = %v, %v", name, key, err)
+		}
+	}
+	if _, err := ParseSortKey("date"); err == nil {
+		t.Error("ParseSortKey(date) should fail")
+	}
+
+	for _, name := range []string{"none", "folder", "source"} {
+		group, err := ParseGrouping(name)
+		if err != nil || group.String() != name {
+			t.Errorf("ParseGrouping(%q) = %v, %v", name, group, err)
+		}
+	}
+	if _, err := ParseGrouping("tag"); err == nil {
+		t.Error("ParseGrouping(tag) should fail")
+	}
+}
@@ -0,0 +1,220 @@
+package finder
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/upamune/airule/internal/frontmatter"
+)
+
+// Stream is a search over several sources running in the background. Entries are appended
+// to its list as they are found, so that a picker can show them while the search goes on.
+// Appended entries keep their index; an entry found again in a later source is updated in place.
+type Stream struct {
+	mu      sync.RWMutex
+	entries []Entry
+	index   map[string]int
+	// files holds every file found, listed or not, so that a directory can be expanded
+	files map[string]Entry
+	mode  EntryMode
+
+	warnMu sync.Mutex
+
+	order Order
+
+	ready     chan struct{}
+	readyOnce sync.Once
+	done      chan struct{}
+	err       error
+}
+
+// StreamSources starts searching the sources in the background. Sources are walked one after
+// another, each with up to opts.Workers concurrent goroutines, so that a later source overrides
+// an earlier one for the same relative path as in FindSources. Calls to opts.OnWarning are serialized.
+// Cancelling ctx stops the search.
+func StreamSources(ctx context.Context, sources []Source, opts Options) *Stream {
+	s := &Stream{
+		index: make(map[string]int),
+		files: make(map[string]Entry),
+		mode:  opts.Entries,
+		order: opts.Order,
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.done)
+		defer s.markReady()
+		s.err = s.run(ctx, sources, opts)
+	}()
+	return s
+}
+
+// run walks the sources in declaration order
+func (s *Stream) run(ctx context.Context, sources []Source, opts Options) error {
+	f := newFilter(opts)
+	for precedence, src := range sources {
+		warn := func(relPath string, kind WarningKind, err error) {
+			if opts.OnWarning == nil {
+				return
+			}
+			s.warnMu.Lock()
+			defer s.warnMu.Unlock()
+			opts.OnWarning(Warning{Source: src.Name, Path: relPath, Kind: kind, Err: err})
+		}
+
+		// Parent directories of the files found in this source, including excluded ones,
+		// so that each directory is only checked once
+		dirs := make(map[string]struct{})
+		found := func(relPath string, info fs.FileInfo, meta frontmatter.Metadata) {
+			file := Entry{Path: relPath, Source: src, Meta: meta, Precedence: precedence}
+			if info != nil {
+				file.Size, file.ModTime = info.Size(), info.ModTime()
+			}
+
+			s.mu.Lock()
+			listed := s.add(file)
+			// Add the parent directories only if they are NOT excluded themselves
+			for dir := filepath.Dir(relPath); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+				if _, ok := dirs[dir]; ok {
+					break // The parents of a known directory are known too
+				}
+				dirs[dir] = struct{}{}
+				if !f.rules.Excluded(dir, true) {
+					listed = s.add(Entry{Path: dir, IsDir: true, Source: src, Precedence: precedence}) || listed
+				}
+			}
+			s.mu.Unlock()
+			// Files found in directories-only mode are not listed, so they do not make the list ready
+			if listed {
+				s.markReady()
+			}
+		}
+
+		if err := walk(ctx, src.Files(), opts, warn, found); err != nil {
+			if len(sources) > 1 {
+				return fmt.Errorf("source %s: %w", src.Name, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// add records an entry while the write lock is held. An entry already found in an earlier
+// source is overridden. When a later source has a file where an earlier one had a directory,
+// or the other way round, the later entry replaces the earlier one entirely, including its kind.
+// It keeps its index even when the new kind is not listed, so that the list only ever grows;
+// Wait leaves it out. add reports whether the entry is listed.
+func (s *Stream) add(entry Entry) bool {
+	if entry.IsDir {
+		// The files below the directory are recorded separately
+		delete(s.files, entry.Path)
+	} else {
+		if existing, ok := s.files[entry.Path]; ok {
+			entry.Shadowed = append(existing.Shadowed, existing.Source)
+		}
+		s.files[entry.Path] = entry
+		entry.Shadowed = append([]Source(nil), entry.Shadowed...)
+	}
+
+	if i, ok := s.index[entry.Path]; ok {
+		existing := &s.entries[i]
+		if existing.IsDir == entry.IsDir {
+			entry.Shadowed = append(existing.Shadowed, existing.Source)
+		}
+		*existing = entry
+		return s.mode.lists(entry.IsDir)
+	}
+	if !s.mode.lists(entry.IsDir) {
+		return false
+	}
+	s.index[entry.Path] = len(s.entries)
+	s.entries = append(s.entries, entry)
+	return true
+}
+
+// FilesBelow returns every file found below the directory dir, listed or not, sorted by path.
+// It is meant to be called once the search is finished.
+func (s *Stream) FilesBelow(dir string) []Entry {
+	prefix := dir + string(filepath.Separator)
+
+	s.mu.RLock()
+	var files []Entry
+	for p, entry := range s.files {
+		if strings.HasPrefix(p, prefix) {
+			files = append(files, entry)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files
+}
+
+// markReady closes the ready channel once
+func (s *Stream) markReady() {
+	s.readyOnce.Do(func() { close(s.ready) })
+}
+
+// Ready is closed once the first entry is listed or the search is finished
+func (s *Stream) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Done is closed when the search is finished
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Entries returns the list of entries found so far, in the order they were found.
+// The list grows while the search goes on, so it must only be read while holding RLocker.
+func (s *Stream) Entries() *[]Entry {
+	return &s.entries
+}
+
+// RLocker returns the lock protecting the list returned by Entries
+func (s *Stream) RLocker() sync.Locker {
+	return s.mu.RLocker()
+}
+
+// Len returns the number of entries found so far
+func (s *Stream) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.entries)
+}
+
+// Entry returns the entry at index i of the list. It must not be called while holding RLocker.
+func (s *Stream) Entry(i int) Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.entries[i]
+}
+
+// Wait waits for the search to finish and returns the entries sorted with SortEntries
+func (s *Stream) Wait() ([]Entry, error) {
+	<-s.done
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	s.mu.RLock()
+	entries := make([]Entry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		if s.mode.lists(entry.IsDir) {
+			entries = append(entries, entry)
+		}
+	}
+	s.mu.RUnlock()
+
+	SortEntries(entries, s.order)
+	return entries, nil
+}
@@ -0,0 +1,283 @@
+package finder
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/upamune/airule/internal/frontmatter"
+	"github.com/upamune/airule/internal/ignore"
+	"github.com/upamune/airule/internal/linkfs"
+)
+
+// DefaultWorkers is the number of directories read concurrently when Options.Workers is not set
+const DefaultWorkers = 8
+
+// dirTask is a directory waiting to be read. followed counts the symbolic links followed to reach it.
+type dirTask struct {
+	path     string
+	followed int
+}
+
+// walker walks one file system with a bounded pool of goroutines, each reading one directory
+// at a time. Subdirectories are queued instead of walked recursively, so a deep tree never
+// holds more than Workers directories open. Files passing the options are reported to found
+// as soon as they are seen, in no particular order.
+type walker struct {
+	fsys    fs.FS
+	opts    Options
+	filter  *filter
+	ignores *ignore.Matcher
+	warn    func(relPath string, kind WarningKind, err error)
+	found   func(relPath string, info fs.FileInfo, meta frontmatter.Metadata)
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []dirTask
+	pending int // queued or in-progress directories
+	err     error
+}
+
+// walk walks fsys with the given options, calling found for every file that passes them and
+// warn for every path that is skipped because it cannot be read. Both may be called
+// concurrently. The walk stops early when ctx is cancelled.
+func walk(ctx context.Context, fsys fs.FS, opts Options, warn func(relPath string, kind WarningKind, err error), found func(relPath string, info fs.FileInfo, meta frontmatter.Metadata)) error {
+	// Check if the root directory exists
+	if _, err := fs.Stat(fsys, "."); errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	w := &walker{
+		fsys:   fsys,
+		opts:   opts,
+		filter: newFilter(opts),
+		warn:   warn,
+		found:  found,
+	}
+	w.cond = sync.NewCond(&w.mu)
+
+	if len(opts.IgnoreFiles) > 0 {
+		// Ignore files that cannot be used are reported and the walk continues without their rules
+		w.ignores = ignore.New(fsys, opts.IgnoreFiles, func(name string, err error) {
+			kind := classify(err)
+			if errors.Is(err, ignore.ErrInvalid) {
+				kind = WarningInvalidIgnoreFile
+			}
+			warn(name, kind, err)
+		})
+	}
+
+	stop := context.AfterFunc(ctx, func() { w.fail(ctx.Err()) })
+	defer stop()
+
+	workers := opts.Workers
+	if workers < 1 {
+		workers = DefaultWorkers
+	}
+
+	w.push(dirTask{path: "."})
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				task, ok := w.pop()
+				if !ok {
+					return
+				}
+				if err := w.visit(task); err != nil {
+					w.fail(err)
+				}
+				w.done()
+			}
+		}()
+	}
+	wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	// The walk may have completed just before being cancelled
+	return ctx.Err()
+}
+
+// push queues a directory to be read
+func (w *walker) push(task dirTask) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.queue = append(w.queue, task)
+	w.pending++
+	w.cond.Signal()
+}
+
+// pop waits for a queued directory. It returns false once the walk is finished or has failed.
+func (w *walker) pop() (dirTask, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for len(w.queue) == 0 && w.pending > 0 && w.err == nil {
+		w.cond.Wait()
+	}
+	if len(w.queue) == 0 || w.err != nil {
+		return dirTask{}, false
+	}
+	// Take the most recently queued directory, so the walk goes deep before it goes wide
+	// and the queue stays small
+	task := w.queue[len(w.queue)-1]
+	w.queue = w.queue[:len(w.queue)-1]
+	return task, true
+}
+
+// done marks a popped directory as finished
+func (w *walker) done() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending--
+	if w.pending == 0 {
+		w.cond.Broadcast()
+	}
+}
+
+// fail stops the walk with err, keeping the first error
+func (w *walker) fail(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.cond.Broadcast()
+}
+
+// visit reads one directory, reporting the files in it and queueing its subdirectories
+func (w *walker) visit(task dirTask) error {
+	// Load the ignore files of the directory before checking the entries inside it.
+	// Those of the root are loaded when the matcher is created.
+	if w.ignores != nil && task.path != "." {
+		w.ignores.Load(task.path)
+	}
+
+	entries, err := fs.ReadDir(w.fsys, task.path)
+	if err != nil {
+		// Report errors accessing directories and continue walking the rest of the tree.
+		// Whatever could be read of the root directory is still walked.
+		w.warn(task.path, classify(err), err)
+		if task.path != "." {
+			return nil
+		}
+	}
+
+	for _, d := range entries {
+		if err := w.visitEntry(path.Join(task.path, d.Name()), d, task.followed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visitEntry applies the options to one directory entry
+func (w *walker) visitEntry(name string, d fs.DirEntry, followed int) error {
+	// Resolve symbolic links according to the policy. A followed link to a directory is
+	// walked like a directory; any other link is listed like a file.
+	isDir := d.IsDir()
+	followDir, linkToDir := false, false
+	var linkErr error
+	var info fs.FileInfo
+	if linkfs.IsLink(d.Type()) {
+		if w.opts.Symlinks == linkfs.Skip {
+			return nil
+		}
+		target, err := fs.Stat(w.fsys, name)
+		info = target
+		switch {
+		case err != nil:
+			linkErr = err
+		case target.IsDir() && w.opts.Symlinks == linkfs.Follow:
+			if linkfs.IsLoop(w.fsys, name, target, followed) {
+				w.warn(name, WarningSymlinkLoop, errors.New("link points to a directory containing it"))
+				return nil
+			}
+			isDir, followDir = true, true
+		case target.IsDir():
+			linkToDir = true
+		}
+	}
+
+	// Get the relative path from the root directory
+	relPath := filepath.FromSlash(name)
+
+	// Prune paths ignored by .gitignore/.airuleignore before anything else
+	if w.ignores != nil && w.ignores.Ignored(name, isDir) {
+		return nil
+	}
+
+	// Check if the current directory should be skipped based on exclude patterns
+	if isDir {
+		if w.filter.skipDir(relPath) {
+			return nil // Skip excluded directory
+		}
+		if followDir {
+			followed++
+		}
+		// Don't record directories during walk, only parents of found files later
+		w.push(dirTask{path: name, followed: followed})
+		return nil
+	}
+
+	// Check if the file should be included
+	if !w.filter.includeFile(relPath) {
+		return nil
+	}
+
+	// Skip links pointing nowhere
+	if linkErr != nil {
+		kind := classify(linkErr)
+		if errors.Is(linkErr, fs.ErrNotExist) {
+			kind = WarningBrokenSymlink
+		}
+		w.warn(name, kind, linkErr)
+		return nil
+	}
+
+	// The size and modification time are also used for sorting, so a missing info is not a warning
+	if info == nil {
+		info, _ = d.Info()
+	}
+
+	var meta frontmatter.Metadata
+	if linkToDir {
+		// A preserved link to a directory has no content to filter on
+		if w.opts.Content.Active() || w.opts.Attrs.Active() {
+			return nil
+		}
+	} else {
+		// Check the size, age and type before reading anything else
+		if !w.opts.Attrs.Match(w.fsys, name, info) {
+			return nil
+		}
+
+		// Files with malformed front-matter are listed as having none
+		var err error
+		meta, err = frontmatter.Read(w.fsys, name)
+		if err != nil {
+			kind := classify(err)
+			if errors.Is(err, frontmatter.ErrInvalid) {
+				kind = WarningInvalidFrontMatter
+			}
+			w.warn(name, kind, err)
+		}
+		if !w.opts.Content.Match(w.fsys, name) {
+			return nil
+		}
+	}
+	if !frontmatter.MatchAll(meta, w.opts.Where) {
+		return nil
+	}
+
+	w.found(relPath, info, meta)
+	return nil
+}
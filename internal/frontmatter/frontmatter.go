@@ -0,0 +1,121 @@
+package frontmatter
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalid is returned for front-matter that is not valid YAML
+var ErrInvalid = errors.New("invalid front-matter")
+
+// MaxSize is the maximum number of bytes read from the start of a file to find its front-matter
+const MaxSize = 64 * 1024
+
+// extensions are the file types whose front-matter is parsed
+var extensions = []string{".md", ".mdc", ".markdown"}
+
+// Metadata is the parsed YAML front-matter of a rule file
+type Metadata map[string]any
+
+// Supported reports whether front-matter is read for the named file
+func Supported(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	for _, e := range extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// Read returns the front-matter of a file in fsys.
+// Files of unsupported types and files without front-matter have no metadata.
+func Read(fsys fs.FS, name string) (Metadata, error) {
+	if !Supported(name) {
+		return nil, nil
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, MaxSize))
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse extracts the YAML front-matter delimited by "---" lines at the start of data.
+// It returns nil metadata when data has no front-matter.
+func Parse(data []byte) (Metadata, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 4096), MaxSize)
+
+	if !scanner.Scan() || strings.TrimRight(scanner.Text(), " \t\r") != "---" {
+		return nil, nil
+	}
+
+	var body strings.Builder
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if trimmed := strings.TrimRight(line, " \t"); trimmed == "---" || trimmed == "..." {
+			meta := Metadata{}
+			if err := yaml.Unmarshal([]byte(body.String()), &meta); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+			}
+			return meta, nil
+		}
+		body.WriteString(line)
+		body.WriteByte('\n')
+	}
+	// An unterminated block is not front-matter
+	return nil, nil
+}
+
+// Description returns the "description" field, or an empty string
+func (m Metadata) Description() string {
+	value, ok := m["description"]
+	if !ok || value == nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(format(value)), " ")
+}
+
+// format converts a metadata value to text. Lists are joined with commas
+// and maps are rendered as sorted key=value pairs.
+func format(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []any:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			parts[i] = format(item)
+		}
+		return strings.Join(parts, ",")
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		parts := make([]string, len(keys))
+		for i, key := range keys {
+			parts[i] = key + "=" + format(v[key])
+		}
+		return strings.Join(parts, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,90 @@
+package frontmatter
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+// TestParse tests extracting YAML front-matter
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    Metadata
+		wantErr bool
+	}{
+		{
+			name:    "Cursor rule",
+			content: "---\ndescription: Go style guide\nglobs: \"*.go\"\nalwaysApply: true\ntags: [go, style]\n---\n# Go\n",
+			want: Metadata{
+				"description": "Go style guide",
+				"globs":       "*.go",
+				"alwaysApply": true,
+				"tags":        []any{"go", "style"},
+			},
+		},
+		{
+			name:    "CRLF line endings",
+			content: "---\r\ndescription: Windows\r\n---\r\nbody",
+			want:    Metadata{"description": "Windows"},
+		},
+		{
+			name:    "Dots terminator",
+			content: "---\ntags:\n  - go\n...\nbody",
+			want:    Metadata{"tags": []any{"go"}},
+		},
+		{
+			name:    "Empty front-matter",
+			content: "---\n---\nbody",
+			want:    Metadata{},
+		},
+		{name: "No front-matter", content: "# Title\n---\n", want: nil},
+		{name: "Unterminated", content: "---\ndescription: x\n", want: nil},
+		{name: "Empty file", content: "", want: nil},
+		{name: "Invalid YAML", content: "---\ndescription: [unclosed\n---\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRead tests that only supported file types are parsed
+func TestRead(t *testing.T) {
+	content := []byte("---\ndescription: Shared\n---\n")
+	fsys := fstest.MapFS{
+		"rule.mdc":  {Data: content},
+		"rule.md":   {Data: content},
+		"rule.yaml": {Data: content},
+	}
+
+	for name, want := range map[string]string{"rule.mdc": "Shared", "rule.md": "Shared", "rule.yaml": ""} {
+		meta, err := Read(fsys, name)
+		if err != nil {
+			t.Fatalf("Read(%s) error = %v", name, err)
+		}
+		if got := meta.Description(); got != want {
+			t.Errorf("Read(%s).Description() = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// TestDescription tests that multi-line descriptions are flattened
+func TestDescription(t *testing.T) {
+	meta := Metadata{"description": "Go style\n  and testing "}
+	if got := meta.Description(); got != "Go style and testing" {
+		t.Errorf("Description() = %q", got)
+	}
+	if got := Metadata(nil).Description(); got != "" {
+		t.Errorf("Description() of nil metadata = %q", got)
+	}
+}
@@ -0,0 +1,111 @@
+package frontmatter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Operators supported in conditions
+const (
+	OpEqual       = "="
+	OpNotEqual    = "!="
+	OpContains    = "contains"
+	OpNotContains = "!contains"
+)
+
+// Condition is a filter on a front-matter field, e.g. "tags contains go" or "alwaysApply=true"
+type Condition struct {
+	Key   string
+	Op    string
+	Value string
+}
+
+var (
+	// comparisonExpr matches "key=value" and "key!=value"
+	comparisonExpr = regexp.MustCompile(`^\s*([^\s=!]+)\s*(!?=)\s*(.*?)\s*$`)
+	// containsExpr matches "key contains value" and "key !contains value"
+	containsExpr = regexp.MustCompile(`^\s*([^\s=!]+)\s+(!?contains)\s+(.*?)\s*$`)
+)
+
+// ParseCondition parses a --where expression
+func ParseCondition(expr string) (Condition, error) {
+	m := containsExpr.FindStringSubmatch(expr)
+	if m == nil {
+		m = comparisonExpr.FindStringSubmatch(expr)
+	}
+	if m == nil {
+		return Condition{}, fmt.Errorf("invalid condition %q: expected 'key=value', 'key!=value', 'key contains value' or 'key !contains value'", expr)
+	}
+	return Condition{Key: m[1], Op: m[2], Value: unquote(m[3])}, nil
+}
+
+// ParseConditions parses several --where expressions
+func ParseConditions(exprs []string) ([]Condition, error) {
+	conditions := make([]Condition, 0, len(exprs))
+	for _, expr := range exprs {
+		c, err := ParseCondition(expr)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, c)
+	}
+	return conditions, nil
+}
+
+// unquote strips one pair of matching single or double quotes
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// Match reports whether the metadata satisfies the condition. Comparisons are case-insensitive.
+// "=" compares the whole value (lists as comma-separated text); "contains" matches a list
+// element or a substring of a text value. A missing field only satisfies the negated operators.
+func (c Condition) Match(m Metadata) bool {
+	value, ok := m[c.Key]
+	switch c.Op {
+	case OpEqual:
+		return ok && strings.EqualFold(format(value), c.Value)
+	case OpNotEqual:
+		return !ok || !strings.EqualFold(format(value), c.Value)
+	case OpContains:
+		return ok && contains(value, c.Value)
+	case OpNotContains:
+		return !ok || !contains(value, c.Value)
+	}
+	return false
+}
+
+// contains matches list elements exactly and text values by substring
+func contains(value any, want string) bool {
+	if list, ok := value.([]any); ok {
+		for _, item := range list {
+			if strings.EqualFold(format(item), want) {
+				return true
+			}
+		}
+		return false
+	}
+	return strings.Contains(strings.ToLower(format(value)), strings.ToLower(want))
+}
+
+// MatchAll reports whether the metadata satisfies every condition
+func MatchAll(m Metadata, conditions []Condition) bool {
+	for _, c := range conditions {
+		if !c.Match(m) {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the condition in --where syntax
+func (c Condition) String() string {
+	if c.Op == OpEqual || c.Op == OpNotEqual {
+		return c.Key + c.Op + c.Value
+	}
+	return c.Key + " " + c.Op + " " + c.Value
+}
@@ -0,0 +1,93 @@
+package frontmatter
+
+import "testing"
+
+// TestParseCondition tests the --where syntax
+func TestParseCondition(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    Condition
+		wantErr bool
+	}{
+		{expr: "alwaysApply=true", want: Condition{Key: "alwaysApply", Op: OpEqual, Value: "true"}},
+		{expr: " alwaysApply = false ", want: Condition{Key: "alwaysApply", Op: OpEqual, Value: "false"}},
+		{expr: "status!=draft", want: Condition{Key: "status", Op: OpNotEqual, Value: "draft"}},
+		{expr: "tags contains go", want: Condition{Key: "tags", Op: OpContains, Value: "go"}},
+		{expr: "description contains 'error handling'", want: Condition{Key: "description", Op: OpContains, Value: "error handling"}},
+		{expr: "tags !contains legacy", want: Condition{Key: "tags", Op: OpNotContains, Value: "legacy"}},
+		{expr: "title=a contains b", want: Condition{Key: "title", Op: OpEqual, Value: "a contains b"}},
+		{expr: "globs=", want: Condition{Key: "globs", Op: OpEqual, Value: ""}},
+		{expr: "tags", wantErr: true},
+		{expr: "=value", wantErr: true},
+		{expr: "tags contains", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := ParseCondition(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCondition(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseCondition(%q) = %+v, want %+v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestConditionMatch tests matching conditions against metadata
+func TestConditionMatch(t *testing.T) {
+	meta := Metadata{
+		"description": "Error handling in Go",
+		"alwaysApply": true,
+		"tags":        []any{"go", "errors"},
+		"globs":       "*.go,*.mod",
+		"priority":    2,
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{expr: "alwaysApply=true", want: true},
+		{expr: "alwaysApply=TRUE", want: true},
+		{expr: "alwaysApply=false", want: false},
+		{expr: "priority=2", want: true},
+		{expr: "tags contains go", want: true},
+		{expr: "tags contains GO", want: true},
+		{expr: "tags contains g", want: false},
+		{expr: "tags=go,errors", want: true},
+		{expr: "tags !contains legacy", want: true},
+		{expr: "tags !contains errors", want: false},
+		{expr: "globs contains *.mod", want: true},
+		{expr: "description contains handling", want: true},
+		{expr: "status=draft", want: false},
+		{expr: "status!=draft", want: true},
+		{expr: "status contains x", want: false},
+		{expr: "status !contains x", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			c, err := ParseCondition(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCondition(%q) error = %v", tt.expr, err)
+			}
+			if got := c.Match(meta); got != tt.want {
+				t.Errorf("Match(%q) = %v, want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+
+	// Files without front-matter only satisfy negated conditions
+	conditions, err := ParseConditions([]string{"tags contains go", "alwaysApply=true"})
+	if err != nil {
+		t.Fatalf("ParseConditions() error = %v", err)
+	}
+	if !MatchAll(meta, conditions) {
+		t.Error("MatchAll() = false, want true")
+	}
+	if MatchAll(nil, conditions) {
+		t.Error("MatchAll(nil) = true, want false")
+	}
+}
@@ -0,0 +1,144 @@
+package ignore
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/upamune/airule/internal/pattern"
+)
+
+// DefaultFiles are the per-directory ignore files honored while walking a source.
+// Later files take precedence over earlier ones in the same directory.
+var DefaultFiles = []string{".gitignore", ".airuleignore"}
+
+// ErrInvalid is wrapped by the errors reported for ignore files that cannot be parsed
+var ErrInvalid = errors.New("invalid ignore file")
+
+// gitExcludeFile is the repository-wide exclude file, which has the lowest precedence
+const gitExcludeFile = ".git/info/exclude"
+
+// scope is a set of rules loaded from one ignore file, relative to the directory containing it
+type scope struct {
+	base  string
+	rules pattern.Rules
+}
+
+// Matcher collects ignore rules from the directories of a walk and reports ignored paths.
+// Directories must be loaded with Load before the paths inside them are checked,
+// which matches the order in which fs.WalkDir visits entries. It is safe for concurrent use,
+// so sibling directories can be loaded and checked by different goroutines.
+type Matcher struct {
+	fsys    fs.FS
+	files   []string
+	onError func(name string, err error)
+
+	mu     sync.RWMutex
+	scopes []scope
+}
+
+// New creates a Matcher for fsys honoring the given ignore file names.
+// The repository-wide .git/info/exclude and the ignore files of the root directory are loaded immediately.
+// Ignore files that cannot be read or parsed are skipped and passed to onError, which may be nil.
+func New(fsys fs.FS, files []string, onError func(name string, err error)) *Matcher {
+	m := &Matcher{
+		fsys:    fsys,
+		files:   files,
+		onError: onError,
+	}
+
+	m.loadFile(".", gitExcludeFile)
+	m.Load(".")
+	return m
+}
+
+// Load reads the ignore files located in dir, a slash-separated path relative to the root
+func (m *Matcher) Load(dir string) {
+	for _, name := range m.files {
+		m.loadFile(dir, path.Join(dir, name))
+	}
+}
+
+// loadFile loads one ignore file, reporting it to onError when it cannot be used
+func (m *Matcher) loadFile(base, name string) {
+	if err := m.readFile(base, name); err != nil && m.onError != nil {
+		m.onError(name, err)
+	}
+}
+
+// readFile parses one ignore file and registers its rules relative to base
+func (m *Matcher) readFile(base, name string) error {
+	f, err := m.fsys.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to open ignore file %s: %w", name, err)
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		return nil
+	}
+
+	rules, err := pattern.ReadRules(f)
+	if err != nil {
+		return fmt.Errorf("%w %s: %w", ErrInvalid, name, err)
+	}
+	if len(rules) > 0 {
+		m.mu.Lock()
+		m.scopes = append(m.scopes, scope{base: base, rules: rules})
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+// Ignored reports whether relPath is ignored.
+// The .git directory is always ignored. Rules from deeper directories take precedence
+// over rules from their parents, and within a file the last matching rule wins.
+func (m *Matcher) Ignored(relPath string, isDir bool) bool {
+	relPath = path.Clean(relPath)
+	if relPath == "." || relPath == "" {
+		return false
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	segs := strings.Split(relPath, "/")
+	ignored := false
+	for i := range segs {
+		if segs[i] == ".git" {
+			return true
+		}
+
+		current := strings.Join(segs[:i+1], "/")
+		currentIsDir := isDir || i < len(segs)-1
+		for _, s := range m.scopes {
+			rel, ok := relativeTo(s.base, current)
+			if !ok {
+				continue
+			}
+			for _, rule := range s.rules {
+				if rule.Match(rel, currentIsDir) {
+					ignored = !rule.Negate
+				}
+			}
+		}
+	}
+	return ignored
+}
+
+// relativeTo returns p relative to base when p is located below base
+func relativeTo(base, p string) (string, bool) {
+	if base == "." {
+		return p, true
+	}
+	if strings.HasPrefix(p, base+"/") {
+		return strings.TrimPrefix(p, base+"/"), true
+	}
+	return "", false
+}
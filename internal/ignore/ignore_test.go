@@ -0,0 +1,83 @@
+package ignore
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+// TestMatcherIgnored tests nested ignore files, precedence and .git/info/exclude
+func TestMatcherIgnored(t *testing.T) {
+	fsys := fstest.MapFS{
+		".git/info/exclude":       {Data: []byte("*.local\n")},
+		".gitignore":              {Data: []byte("build/\n*.log\n")},
+		".airuleignore":           {Data: []byte("drafts/\n!keep.log\n")},
+		"rules/.gitignore":        {Data: []byte("generated-*.md\n!important.log\n/only-here.md\n")},
+		"rules/nested/.gitignore": {Data: []byte("!generated-ok.md\n")},
+	}
+
+	m := New(fsys, DefaultFiles, func(name string, err error) {
+		t.Errorf("unexpected error for %s: %v", name, err)
+	})
+	for _, dir := range []string{"rules", "rules/nested"} {
+		m.Load(dir)
+	}
+
+	tests := []struct {
+		name  string
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{name: "Regular file", path: "rules/go.md", want: false},
+		{name: "Ignored directory", path: "build", isDir: true, want: true},
+		{name: "File inside ignored directory", path: "build/out.md", want: true},
+		{name: "Ignored by extension", path: "rules/debug.log", want: true},
+		{name: "Re-included by .airuleignore", path: "keep.log", want: false},
+		{name: "Ignored by .airuleignore", path: "drafts/wip.md", want: true},
+		{name: "Ignored by .git/info/exclude", path: "notes.local", want: true},
+		{name: "Nested ignore file applies below its directory", path: "rules/generated-a.md", want: true},
+		{name: "Nested ignore file does not apply elsewhere", path: "generated-a.md", want: false},
+		{name: "Nested negation overrides parent", path: "rules/important.log", want: false},
+		{name: "Deeper ignore file takes precedence", path: "rules/nested/generated-ok.md", want: false},
+		{name: "Anchored to the ignore file directory", path: "rules/only-here.md", want: true},
+		{name: "Anchored rule does not match deeper", path: "rules/nested/only-here.md", want: false},
+		{name: "Git directory", path: ".git/config", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.Ignored(tt.path, tt.isDir); got != tt.want {
+				t.Errorf("Ignored(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMatcherInvalidIgnoreFile tests that malformed ignore files are reported and skipped
+func TestMatcherInvalidIgnoreFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		".gitignore":    {Data: []byte("*.log\n")},
+		".airuleignore": {Data: []byte("*.{md\n")},
+	}
+
+	var failed []string
+	m := New(fsys, DefaultFiles, func(name string, err error) {
+		if !errors.Is(err, ErrInvalid) {
+			t.Errorf("error for %s = %v, want ErrInvalid", name, err)
+		}
+		failed = append(failed, name)
+	})
+	if !reflect.DeepEqual(failed, []string{".airuleignore"}) {
+		t.Errorf("reported ignore files = %v, want [.airuleignore]", failed)
+	}
+
+	// The rules of the valid ignore file still apply
+	if !m.Ignored("debug.log", false) {
+		t.Error("debug.log should be ignored by .gitignore")
+	}
+	if m.Ignored("style.md", false) {
+		t.Error("style.md should not be ignored")
+	}
+}
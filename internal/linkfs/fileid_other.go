@@ -0,0 +1,10 @@
+//go:build !unix
+
+package linkfs
+
+import "io/fs"
+
+// fileID is not available on this platform; loops are bounded by MaxDepth instead
+func fileID(info fs.FileInfo) (FileID, bool) {
+	return FileID{}, false
+}
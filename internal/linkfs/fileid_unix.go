@@ -0,0 +1,17 @@
+//go:build unix
+
+package linkfs
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileID reads the device and inode numbers of a file
+func fileID(info fs.FileInfo) (FileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return FileID{}, false
+	}
+	return FileID{Dev: uint64(st.Dev), Ino: uint64(st.Ino)}, true
+}
@@ -0,0 +1,179 @@
+package linkfs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// MaxDepth bounds how many symbolic links are followed below each other when the
+// file system cannot identify directories (e.g. in-memory file systems)
+const MaxDepth = 40
+
+// ErrUnsupported is returned when a file system cannot read symbolic links
+var ErrUnsupported = errors.New("symbolic links are not supported by this file system")
+
+// Policy decides how symbolic links in a source tree are treated
+type Policy int
+
+const (
+	// Follow resolves links and treats them as their targets, skipping links that form a loop
+	Follow Policy = iota
+	// Preserve lists links as entries of their own and recreates them in the destination
+	Preserve
+	// Skip omits links entirely
+	Skip
+)
+
+// ParsePolicy parses "follow", "preserve" or "skip"
+func ParsePolicy(s string) (Policy, error) {
+	switch s {
+	case "", "follow":
+		return Follow, nil
+	case "preserve":
+		return Preserve, nil
+	case "skip":
+		return Skip, nil
+	}
+	return Follow, fmt.Errorf("invalid symlink policy %q: expected follow, preserve or skip", s)
+}
+
+// String returns the policy name
+func (p Policy) String() string {
+	switch p {
+	case Preserve:
+		return "preserve"
+	case Skip:
+		return "skip"
+	default:
+		return "follow"
+	}
+}
+
+// ReadLinkFS is a file system that can report symbolic links. It has the same shape as
+// fs.ReadLinkFS in newer Go releases.
+type ReadLinkFS interface {
+	fs.FS
+	// ReadLink returns the target of the named symbolic link
+	ReadLink(name string) (string, error)
+	// Lstat returns the file info of name without following a final symbolic link
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+// ReadLink returns the target of a symbolic link in fsys
+func ReadLink(fsys fs.FS, name string) (string, error) {
+	if lfs, ok := fsys.(ReadLinkFS); ok {
+		return lfs.ReadLink(name)
+	}
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: ErrUnsupported}
+}
+
+// Lstat returns the file info of name without following a final symbolic link.
+// File systems without symbolic link support fall back to fs.Stat.
+func Lstat(fsys fs.FS, name string) (fs.FileInfo, error) {
+	if lfs, ok := fsys.(ReadLinkFS); ok {
+		return lfs.Lstat(name)
+	}
+	return fs.Stat(fsys, name)
+}
+
+// IsLink reports whether the mode describes a symbolic link
+func IsLink(mode fs.FileMode) bool {
+	return mode&fs.ModeSymlink != 0
+}
+
+// FileID identifies a file on a device, so that directories reached through different
+// paths can be recognized as the same one
+type FileID struct {
+	Dev uint64
+	Ino uint64
+}
+
+// ID returns the identity of a file, if the file system provides one
+func ID(info fs.FileInfo) (FileID, bool) {
+	return fileID(info)
+}
+
+// IsLoop reports whether the directory dir, reached through the symbolic link at name,
+// is one of the directories containing name, so that following the link would never end.
+// followed is the number of links already followed to reach name; it bounds the recursion
+// when directories cannot be identified.
+func IsLoop(fsys fs.FS, name string, dir fs.FileInfo, followed int) bool {
+	id, ok := ID(dir)
+	if !ok {
+		return followed >= MaxDepth
+	}
+	for ancestor := path.Dir(name); ; ancestor = path.Dir(ancestor) {
+		if info, err := fs.Stat(fsys, ancestor); err == nil {
+			if ancestorID, ok := ID(info); ok && ancestorID == id {
+				return true
+			}
+		}
+		if ancestor == "." {
+			return false
+		}
+	}
+}
+
+// Escapes reports whether a link target, relative to the directory of the link at name,
+// points outside the root of the file system
+func Escapes(name, target string) bool {
+	if filepath.IsAbs(target) {
+		return true
+	}
+	resolved := path.Join(path.Dir(name), filepath.ToSlash(target))
+	return resolved == ".." || strings.HasPrefix(resolved, "../")
+}
+
+// Dir is a directory on disk, like os.DirFS, that also reports symbolic links
+type Dir string
+
+// path converts a file system name to a path on disk
+func (d Dir) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(string(d), filepath.FromSlash(name)), nil
+}
+
+// Open opens the named file for reading
+func (d Dir) Open(name string) (fs.File, error) {
+	return os.DirFS(string(d)).Open(name)
+}
+
+// Stat returns the file info of the named file, following symbolic links
+func (d Dir) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(os.DirFS(string(d)), name)
+}
+
+// ReadDir returns the entries of the named directory sorted by name
+func (d Dir) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(os.DirFS(string(d)), name)
+}
+
+// ReadFile returns the content of the named file
+func (d Dir) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(os.DirFS(string(d)), name)
+}
+
+// Lstat returns the file info of name without following a final symbolic link
+func (d Dir) Lstat(name string) (fs.FileInfo, error) {
+	p, err := d.path("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Lstat(p)
+}
+
+// ReadLink returns the target of the named symbolic link
+func (d Dir) ReadLink(name string) (string, error) {
+	p, err := d.path("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	return os.Readlink(p)
+}
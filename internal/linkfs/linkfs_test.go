@@ -0,0 +1,122 @@
+package linkfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+// TestParsePolicy tests parsing policy names
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Policy
+		wantErr bool
+	}{
+		{input: "", want: Follow},
+		{input: "follow", want: Follow},
+		{input: "preserve", want: Preserve},
+		{input: "skip", want: Skip},
+		{input: "copy", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParsePolicy(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePolicy(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("ParsePolicy(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+			if err == nil && tt.input != "" && got.String() != tt.input {
+				t.Errorf("String() = %q, want %q", got.String(), tt.input)
+			}
+		})
+	}
+}
+
+// TestEscapes tests detecting link targets outside the file system
+func TestEscapes(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		want   bool
+	}{
+		{name: "rules/go.md", target: "style.md", want: false},
+		{name: "rules/go.md", target: "../shared/go.md", want: false},
+		{name: "rules/go.md", target: "../../outside.md", want: true},
+		{name: "go.md", target: "..", want: true},
+		{name: "go.md", target: "/etc/passwd", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := Escapes(tt.name, tt.target); got != tt.want {
+			t.Errorf("Escapes(%q, %q) = %v, want %v", tt.name, tt.target, got, tt.want)
+		}
+	}
+}
+
+// TestDir tests reading links and detecting loops on disk
+func TestDir(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "rules", "go"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.Symlink("..", filepath.Join(root, "rules", "go", "up")); err != nil {
+		t.Skipf("symlinks are not supported: %v", err)
+	}
+	if err := os.Symlink("go", filepath.Join(root, "rules", "alias")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	fsys := Dir(root)
+
+	if target, err := ReadLink(fsys, "rules/go/up"); err != nil || target != ".." {
+		t.Errorf("ReadLink() = %q, %v, want %q", target, err, "..")
+	}
+	info, err := Lstat(fsys, "rules/go/up")
+	if err != nil || !IsLink(info.Mode()) {
+		t.Fatalf("Lstat() = %v, %v, want a link", info, err)
+	}
+
+	if _, ok := ID(info); !ok {
+		t.Skip("file identities are not available on this platform")
+	}
+	up, err := fs.Stat(fsys, "rules/go/up")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if !IsLoop(fsys, "rules/go/up", up, 0) {
+		t.Error("IsLoop() = false for a link to a parent directory")
+	}
+	alias, err := fs.Stat(fsys, "rules/alias")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if IsLoop(fsys, "rules/alias", alias, 0) {
+		t.Error("IsLoop() = true for a link to a sibling directory")
+	}
+}
+
+// TestWithoutLinks tests the helpers on file systems without link support
+func TestWithoutLinks(t *testing.T) {
+	// Hide any link support of the underlying file system
+	fsys := struct{ fs.FS }{fstest.MapFS{"rules/go.md": {Data: []byte("go")}}}
+
+	if _, err := ReadLink(fsys, "rules/go.md"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("ReadLink() error = %v, want ErrUnsupported", err)
+	}
+	info, err := Lstat(fsys, "rules")
+	if err != nil || !info.IsDir() {
+		t.Errorf("Lstat() = %v, %v, want a directory", info, err)
+	}
+	if IsLoop(fsys, "rules/link", info, MaxDepth-1) {
+		t.Error("IsLoop() = true below the depth limit")
+	}
+	if !IsLoop(fsys, "rules/link", info, MaxDepth) {
+		t.Error("IsLoop() = false at the depth limit")
+	}
+}
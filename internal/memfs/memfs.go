@@ -0,0 +1,470 @@
+package memfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FS is an in-memory file system implementing fs.FS, fs.ReadDirFS, fs.ReadFileFS, fs.StatFS
+// and writefs.FS. Relative symbolic links inside the file system are supported; absolute
+// targets and targets outside the root do not resolve. It is safe for concurrent use.
+type FS struct {
+	mu    sync.RWMutex
+	nodes map[string]*node
+}
+
+// maxLinks bounds the symbolic links followed to resolve a single name
+const maxLinks = 40
+
+// errLinkLoop is returned when resolving a name follows too many symbolic links
+var errLinkLoop = errors.New("too many levels of symbolic links")
+
+// node is a file, directory or symbolic link stored in memory. The data of a link is its target.
+type node struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// New creates an empty in-memory file system
+func New() *FS {
+	return &FS{
+		nodes: map[string]*node{
+			".": {mode: fs.ModeDir | 0755},
+		},
+	}
+}
+
+// WriteFile stores a file, creating its parent directories as needed
+func (m *FS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return m.WriteFileTime(name, data, perm, time.Now())
+}
+
+// WriteFileTime stores a file with the given modification time, creating its parent directories as needed
+func (m *FS) WriteFileTime(name string, data []byte, perm fs.FileMode, modTime time.Time) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.mkdirAll(path.Dir(name), 0755, modTime); err != nil {
+		return err
+	}
+	if existing, ok := m.nodes[name]; ok && existing.mode.IsDir() {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrExist}
+	}
+	m.nodes[name] = &node{
+		data:    append([]byte(nil), data...),
+		mode:    perm.Perm(),
+		modTime: modTime,
+	}
+	return nil
+}
+
+// MkdirAll creates a directory and all missing parents
+func (m *FS) MkdirAll(name string, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mkdirAll(name, perm, time.Now())
+}
+
+// mkdirAll creates directories while the write lock is held
+func (m *FS) mkdirAll(name string, perm fs.FileMode, modTime time.Time) error {
+	for dir := name; ; dir = path.Dir(dir) {
+		if existing, ok := m.nodes[dir]; ok {
+			if !existing.mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: dir, Err: fs.ErrExist}
+			}
+		} else {
+			m.nodes[dir] = &node{mode: fs.ModeDir | perm.Perm(), modTime: modTime}
+		}
+		if dir == "." {
+			return nil
+		}
+	}
+}
+
+// Create creates or truncates the named file. The content is stored when the returned writer is closed.
+func (m *FS) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parent, ok := m.nodes[path.Dir(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrNotExist}
+	}
+	if !parent.mode.IsDir() {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrInvalid}
+	}
+	n, ok := m.nodes[name]
+	if ok && n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+	if !ok {
+		// Like os.OpenFile, the permissions of an existing file are kept
+		n = &node{mode: perm.Perm()}
+		m.nodes[name] = n
+	}
+	n.data = nil
+	n.modTime = time.Now()
+	return &writer{fsys: m, name: name}, nil
+}
+
+// Symlink creates newname as a symbolic link to oldname, creating its parent directories as needed
+func (m *FS) Symlink(oldname, newname string) error {
+	if !fs.ValidPath(newname) || newname == "." {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: fs.ErrInvalid}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if err := m.mkdirAll(path.Dir(newname), 0755, now); err != nil {
+		return err
+	}
+	if _, ok := m.nodes[newname]; ok {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: fs.ErrExist}
+	}
+	m.nodes[newname] = &node{data: []byte(oldname), mode: fs.ModeSymlink | 0777, modTime: now}
+	return nil
+}
+
+// ReadLink returns the target of the named symbolic link
+func (m *FS) ReadLink(name string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n, err := m.lookupLink("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	if n.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return string(n.data), nil
+}
+
+// RemoveAll removes name and everything it contains. A missing name is not an error.
+func (m *FS) RemoveAll(name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prefix := name + "/"
+	for p := range m.nodes {
+		if p == name || strings.HasPrefix(p, prefix) {
+			delete(m.nodes, p)
+		}
+	}
+	return nil
+}
+
+// Chmod changes the permission bits of name
+func (m *FS) Chmod(name string, mode fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, err := m.lookup("chmod", name)
+	if err != nil {
+		return err
+	}
+	n.mode = n.mode.Type() | mode.Perm()
+	return nil
+}
+
+// Chtimes changes the modification time of name, following symbolic links. The access
+// time is not stored.
+func (m *FS) Chtimes(name string, atime, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, n, err := m.resolve("chtimes", name)
+	if err != nil {
+		return err
+	}
+	n.modTime = mtime
+	return nil
+}
+
+// Link fails with errors.ErrUnsupported, since files on disk cannot be linked into memory
+func (m *FS) Link(oldname, newname string) error {
+	return &fs.PathError{Op: "link", Path: newname, Err: errors.ErrUnsupported}
+}
+
+// lookup returns the node for name without following symbolic links
+func (m *FS) lookup(op, name string) (*node, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	n, ok := m.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return n, nil
+}
+
+// resolve returns the real name and node for name, following symbolic links in every
+// component of the name
+func (m *FS) resolve(op, name string) (string, *node, error) {
+	if !fs.ValidPath(name) {
+		return "", nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	cur, rest, links := ".", split(name), 0
+	for len(rest) > 0 {
+		next := path.Join(cur, rest[0])
+		rest = rest[1:]
+		n, ok := m.nodes[next]
+		if !ok {
+			return "", nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		if n.mode&fs.ModeSymlink == 0 {
+			cur = next
+			continue
+		}
+
+		links++
+		if links > maxLinks {
+			return "", nil, &fs.PathError{Op: op, Path: name, Err: errLinkLoop}
+		}
+		target := path.Join(path.Dir(next), string(n.data))
+		if path.IsAbs(string(n.data)) || !fs.ValidPath(target) {
+			return "", nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		cur, rest = ".", append(split(target), rest...)
+	}
+	return cur, m.nodes[cur], nil
+}
+
+// lookupLink returns the node for name, following symbolic links in every component but the last
+func (m *FS) lookupLink(op, name string) (*node, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return m.nodes["."], nil
+	}
+	parent, _, err := m.resolve(op, path.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	n, ok := m.nodes[path.Join(parent, path.Base(name))]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return n, nil
+}
+
+// split splits a valid name into its components
+func split(name string) []string {
+	if name == "." {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
+
+// Open opens the named file or directory
+func (m *FS) Open(name string) (fs.File, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	real, n, err := m.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info := fileInfo{name: path.Base(name), node: *n}
+	if n.mode.IsDir() {
+		entries, err := m.readDir(real)
+		if err != nil {
+			return nil, err
+		}
+		return &dir{info: info, entries: entries}, nil
+	}
+	return &file{info: info, data: n.data}, nil
+}
+
+// Stat returns the file info of the named file
+func (m *FS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, n, err := m.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{name: path.Base(name), node: *n}, nil
+}
+
+// Lstat returns the file info of name without following a final symbolic link
+func (m *FS) Lstat(name string) (fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n, err := m.lookupLink("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{name: path.Base(name), node: *n}, nil
+}
+
+// ReadFile returns a copy of the named file's content
+func (m *FS) ReadFile(name string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, n, err := m.resolve("read", name)
+	if err != nil {
+		return nil, err
+	}
+	if n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	return append([]byte(nil), n.data...), nil
+}
+
+// ReadDir returns the entries of the named directory sorted by name
+func (m *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	real, n, err := m.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return m.readDir(real)
+}
+
+// readDir lists the direct children of a directory while the read lock is held
+func (m *FS) readDir(name string) ([]fs.DirEntry, error) {
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+
+	var entries []fs.DirEntry
+	for p, n := range m.nodes {
+		if p == "." || !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(p, prefix)
+		if rest == "" || strings.Contains(rest, "/") {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(fileInfo{name: rest, node: *n}))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// fileInfo implements fs.FileInfo for a node
+type fileInfo struct {
+	name string
+	node node
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return int64(len(fi.node.data)) }
+func (fi fileInfo) Mode() fs.FileMode  { return fi.node.mode }
+func (fi fileInfo) ModTime() time.Time { return fi.node.modTime }
+func (fi fileInfo) IsDir() bool        { return fi.node.mode.IsDir() }
+func (fi fileInfo) Sys() any           { return nil }
+
+// file is an open regular file
+type file struct {
+	info   fileInfo
+	data   []byte
+	offset int64
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *file) Close() error               { return nil }
+
+func (f *file) Read(b []byte) (int, error) {
+	if f.offset >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+// writer appends to a file created with Create
+type writer struct {
+	fsys *FS
+	name string
+	buf  []byte
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	w.buf = append(w.buf, b...)
+	return len(b), nil
+}
+
+// Close stores the written content unless the file was removed in the meantime
+func (w *writer) Close() error {
+	w.fsys.mu.Lock()
+	defer w.fsys.mu.Unlock()
+
+	n, ok := w.fsys.nodes[w.name]
+	if !ok || n.mode.IsDir() {
+		return &fs.PathError{Op: "close", Path: w.name, Err: fs.ErrNotExist}
+	}
+	n.data = w.buf
+	n.modTime = time.Now()
+	return nil
+}
+
+// dir is an open directory
+type dir struct {
+	info    fileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dir) Close() error               { return nil }
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *dir) ReadDir(count int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if count <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	d.offset += count
+	return remaining[:count], nil
+}
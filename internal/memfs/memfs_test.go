@@ -0,0 +1,204 @@
+package memfs
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// TestFSConformance tests the file system against the io/fs conformance checks
+func TestFSConformance(t *testing.T) {
+	m := New()
+	files := map[string]string{
+		"rules/go.md":         "go rules",
+		"rules/nested/ts.md":  "ts rules",
+		"README.md":           "readme",
+		"empty/.gitkeep":      "",
+		"rules/nested/b.mdc":  "b",
+		"rules/nested/a.mdc":  "a",
+		"rules/.hidden/x.txt": "x",
+	}
+	for name, content := range files {
+		if err := m.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile(%q) error = %v", name, err)
+		}
+	}
+
+	if err := fstest.TestFS(m, "rules/go.md", "rules/nested/ts.md", "README.md", "empty/.gitkeep"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestWriteFile tests writing, overwriting and error cases
+func TestWriteFile(t *testing.T) {
+	m := New()
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	if err := m.WriteFileTime("dir/file.md", []byte("first"), 0600, modTime); err != nil {
+		t.Fatalf("WriteFileTime() error = %v", err)
+	}
+	if err := m.WriteFile("dir/file.md", []byte("second"), 0644); err != nil {
+		t.Fatalf("WriteFile() overwrite error = %v", err)
+	}
+
+	data, err := m.ReadFile("dir/file.md")
+	if err != nil || string(data) != "second" {
+		t.Errorf("ReadFile() = %q, %v", data, err)
+	}
+
+	info, err := m.Stat("dir")
+	if err != nil || !info.IsDir() {
+		t.Errorf("Stat(dir) = %v, %v, want a directory", info, err)
+	}
+
+	if err := m.WriteFile("dir", []byte("x"), 0644); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("WriteFile over a directory error = %v, want ErrExist", err)
+	}
+	if err := m.WriteFile("dir/file.md/child", []byte("x"), 0644); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("WriteFile below a file error = %v, want ErrExist", err)
+	}
+	if err := m.WriteFile("../escape", []byte("x"), 0644); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("WriteFile with invalid path error = %v, want ErrInvalid", err)
+	}
+	if _, err := m.Open("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open(missing) error = %v, want ErrNotExist", err)
+	}
+}
+
+// TestWritableFS tests the writefs.FS methods
+func TestWritableFS(t *testing.T) {
+	m := New()
+
+	if _, err := m.Create("missing/file.md", 0644); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Create() without parent error = %v, want ErrNotExist", err)
+	}
+	if err := m.MkdirAll("rules/go", 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+
+	w, err := m.Create("rules/go/style.md", 0600)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := w.Write([]byte("sty")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := w.Write([]byte("le")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if data, err := m.ReadFile("rules/go/style.md"); err != nil || string(data) != "style" {
+		t.Errorf("ReadFile() = %q, %v", data, err)
+	}
+
+	// Truncating keeps the permissions of an existing file
+	w, err = m.Create("rules/go/style.md", 0644)
+	if err != nil {
+		t.Fatalf("Create() truncate error = %v", err)
+	}
+	w.Close()
+	info, err := m.Lstat("rules/go/style.md")
+	if err != nil || info.Size() != 0 || info.Mode().Perm() != 0600 {
+		t.Errorf("Lstat() after truncate = %v, %v", info, err)
+	}
+
+	if err := m.Chmod("rules", 0700); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+	if info, err := m.Stat("rules"); err != nil || info.Mode() != fs.ModeDir|0700 {
+		t.Errorf("Stat(rules) after Chmod = %v, %v", info, err)
+	}
+	if err := m.Chmod("missing", 0700); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Chmod(missing) error = %v, want ErrNotExist", err)
+	}
+
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := m.Chtimes("rules/go/style.md", mtime, mtime); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+	if info, err := m.Stat("rules/go/style.md"); err != nil || !info.ModTime().Equal(mtime) {
+		t.Errorf("Stat() after Chtimes = %v, %v, want modification time %v", info, err, mtime)
+	}
+
+	if err := m.WriteFile("rulesets/keep.md", []byte("keep"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := m.RemoveAll("rules"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if _, err := m.Stat("rules/go/style.md"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() after RemoveAll error = %v, want ErrNotExist", err)
+	}
+	if _, err := m.Stat("rulesets/keep.md"); err != nil {
+		t.Errorf("RemoveAll(rules) removed a sibling with the same prefix: %v", err)
+	}
+	if err := m.RemoveAll("missing"); err != nil {
+		t.Errorf("RemoveAll(missing) error = %v", err)
+	}
+}
+
+// TestSymlink tests creating, reading and resolving symbolic links
+func TestSymlink(t *testing.T) {
+	m := New()
+	if err := m.WriteFile("shared/go.md", []byte("go rules"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"rules/go.md":   "../shared/go.md",
+		"rules/shared":  "../shared",
+		"rules/broken":  "missing.md",
+		"rules/outside": "../../etc/passwd",
+		"rules/loop":    "loop",
+	}
+	for name, target := range links {
+		if err := m.Symlink(target, name); err != nil {
+			t.Fatalf("Symlink(%q) error = %v", name, err)
+		}
+	}
+	if err := m.Symlink("x", "rules/go.md"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Symlink over an existing link error = %v, want ErrExist", err)
+	}
+
+	if target, err := m.ReadLink("rules/go.md"); err != nil || target != "../shared/go.md" {
+		t.Errorf("ReadLink() = %q, %v", target, err)
+	}
+	if _, err := m.ReadLink("shared/go.md"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("ReadLink(regular file) error = %v, want ErrInvalid", err)
+	}
+
+	info, err := m.Lstat("rules/shared")
+	if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		t.Errorf("Lstat(link) = %v, %v, want a symbolic link", info, err)
+	}
+	info, err = m.Stat("rules/shared")
+	if err != nil || !info.IsDir() {
+		t.Errorf("Stat(link) = %v, %v, want a directory", info, err)
+	}
+
+	// Links are followed in every component of a name
+	for _, name := range []string{"rules/go.md", "rules/shared/go.md"} {
+		data, err := m.ReadFile(name)
+		if err != nil || string(data) != "go rules" {
+			t.Errorf("ReadFile(%q) = %q, %v", name, data, err)
+		}
+	}
+	if entries, err := m.ReadDir("rules/shared"); err != nil || len(entries) != 1 {
+		t.Errorf("ReadDir(link) = %v, %v, want one entry", entries, err)
+	}
+	if _, err := m.Lstat("rules/shared/go.md"); err != nil {
+		t.Errorf("Lstat() below a link error = %v", err)
+	}
+
+	for _, name := range []string{"rules/broken", "rules/outside"} {
+		if _, err := m.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat(%q) error = %v, want ErrNotExist", name, err)
+		}
+	}
+	if _, err := m.Stat("rules/loop"); !errors.Is(err, errLinkLoop) {
+		t.Errorf("Stat(loop) error = %v, want errLinkLoop", err)
+	}
+}
@@ -0,0 +1,300 @@
+package merge
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Conflict markers surrounding the two sides of a conflicting change
+const (
+	MarkerLocal = "<<<<<<< local"
+	MarkerSep   = "======="
+	MarkerOther = ">>>>>>> airule"
+)
+
+// Merge merges the changes made to base in local and in other, line by line.
+// A region changed on one side only takes that side; a region changed the same way on both
+// sides is taken once. Regions changed differently on both sides are written with conflict
+// markers, local first, and counted in the returned number of conflicts.
+func Merge(base, local, other []byte) ([]byte, int) {
+	b, l, o := splitLines(base), splitLines(local), splitLines(other)
+	ml, mo := match(b, l), match(b, o)
+
+	var out bytes.Buffer
+	conflicts := 0
+	i, x, y := 0, 0, 0
+	for {
+		// Find the next base line kept on both sides
+		k := i
+		for k < len(b) && (ml[k] < 0 || mo[k] < 0) {
+			k++
+		}
+		endL, endO := len(l), len(o)
+		if k < len(b) {
+			endL, endO = ml[k], mo[k]
+		}
+
+		// Resolve the region changed since the previous kept line
+		baseChunk, localChunk, otherChunk := b[i:k], l[x:endL], o[y:endO]
+		switch {
+		case equal(localChunk, baseChunk):
+			writeLines(&out, otherChunk)
+		case equal(otherChunk, baseChunk), equal(localChunk, otherChunk):
+			writeLines(&out, localChunk)
+		default:
+			conflicts++
+			writeLine(&out, MarkerLocal)
+			writeLines(&out, localChunk)
+			writeLine(&out, MarkerSep)
+			writeLines(&out, otherChunk)
+			writeLine(&out, MarkerOther)
+		}
+
+		if k == len(b) {
+			break
+		}
+		out.WriteString(b[k])
+		i, x, y = k+1, endL+1, endO+1
+	}
+	return out.Bytes(), conflicts
+}
+
+// diffContext is the number of unchanged lines shown around changes by Diff
+const diffContext = 3
+
+// Diff returns the changes from old to new as unified diff hunks, without file headers.
+// It returns nothing when both have the same lines.
+func Diff(old, new []byte) []byte {
+	a, b := splitLines(old), splitLines(new)
+	m := match(a, b)
+
+	// ops lists every line: ' ' kept, '-' removed from old, '+' added in new
+	type op struct {
+		kind byte
+		line string
+	}
+	var ops []op
+	j := 0
+	for i, line := range a {
+		if m[i] < 0 {
+			ops = append(ops, op{'-', line})
+			continue
+		}
+		for ; j < m[i]; j++ {
+			ops = append(ops, op{'+', b[j]})
+		}
+		ops = append(ops, op{' ', line})
+		j++
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, op{'+', b[j]})
+	}
+
+	var out bytes.Buffer
+	for start := 0; start < len(ops); {
+		// Find the next change and the end of the hunk around it
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		end, kept := first, 0
+		for end < len(ops) && kept <= 2*diffContext {
+			if ops[end].kind == ' ' {
+				kept++
+			} else {
+				kept = 0
+			}
+			end++
+		}
+		end -= max(kept-diffContext, 0)
+		from := max(first-diffContext, start)
+
+		// Line numbers of the hunk in old and new
+		oldLine, newLine := 1, 1
+		for _, o := range ops[:from] {
+			if o.kind != '+' {
+				oldLine++
+			}
+			if o.kind != '-' {
+				newLine++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, o := range ops[from:end] {
+			if o.kind != '+' {
+				oldCount++
+			}
+			if o.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", oldLine, oldCount, newLine, newCount)
+		for _, o := range ops[from:end] {
+			out.WriteByte(o.kind)
+			out.WriteString(o.line)
+			if o.line[len(o.line)-1] != '\n' {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = end
+	}
+	return out.Bytes()
+}
+
+// splitLines splits data after every newline, keeping the newlines
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		n := bytes.IndexByte(data, '\n') + 1
+		if n == 0 {
+			n = len(data)
+		}
+		lines = append(lines, string(data[:n]))
+		data = data[n:]
+	}
+	return lines
+}
+
+// match returns for every line of a the index of the line of b it is matched with in
+// a longest common subsequence of a and b, or -1 when it is not part of it.
+// It uses Myers' linear space algorithm, so that memory grows with the number of lines
+// rather than with their product.
+func match(a, b []string) []int {
+	// Compare small integers rather than lines
+	ids := make(map[string]int)
+	intern := func(lines []string) []int {
+		out := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+
+	m := make([]int, len(a))
+	for i := range m {
+		m[i] = -1
+	}
+	matchRange(intern(a), intern(b), 0, len(a), 0, len(b), m)
+	return m
+}
+
+// matchRange records in m the matches of a longest common subsequence of a[aLo:aHi] and b[bLo:bHi]
+func matchRange(a, b []int, aLo, aHi, bLo, bHi int, m []int) {
+	// Lines shared at both ends are always part of it
+	for aLo < aHi && bLo < bHi && a[aLo] == b[bLo] {
+		m[aLo] = bLo
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && a[aHi-1] == b[bHi-1] {
+		aHi--
+		bHi--
+		m[aHi] = bHi
+	}
+	if aLo == aHi || bLo == bHi {
+		return
+	}
+
+	// Split around the middle snake of the shortest edit script and solve both halves
+	x, y, u, v := middleSnake(a, b, aLo, aHi, bLo, bHi)
+	matchRange(a, b, aLo, x, bLo, y, m)
+	for ; x < u; x, y = x+1, y+1 {
+		m[x] = y
+	}
+	matchRange(a, b, u, aHi, v, bHi, m)
+}
+
+// middleSnake returns the start (x, y) and end (u, v) of the middle snake of a shortest edit
+// script from a[aLo:aHi] to b[bLo:bHi], searching forward from the start and backward from the
+// end at the same time until the two searches overlap
+func middleSnake(a, b []int, aLo, aHi, bLo, bHi int) (x, y, u, v int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	maxD := (n + m + 1) / 2
+	offset := maxD + 1
+	// forward[offset+k] is the furthest x reached on diagonal k = x-y from the start;
+	// backward[offset+k] is the same from the end, with both sequences reversed
+	forward := make([]int, 2*maxD+3)
+	backward := make([]int, 2*maxD+3)
+
+	for d := 0; d <= maxD; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && a[aLo+x] == b[bLo+y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			// The backward diagonal delta-k has reached d-1 at this point
+			if rk := delta - k; odd && rk >= -(d-1) && rk <= d-1 && x+backward[offset+rk] >= n {
+				return aLo + startX, bLo + startY, aLo + x, bLo + y
+			}
+		}
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && a[aHi-1-x] == b[bHi-1-y] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+			if fk := delta - k; !odd && fk >= -d && fk <= d && x+forward[offset+fk] >= n {
+				return aHi - x, bHi - y, aHi - startX, bHi - startY
+			}
+		}
+	}
+	// Not reached: the searches overlap by d = maxD at the latest
+	return aLo, bLo, aLo, bLo
+}
+
+// equal reports whether two regions have the same lines
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeLines writes lines as they are
+func writeLines(out *bytes.Buffer, lines []string) {
+	for _, line := range lines {
+		out.WriteString(line)
+	}
+}
+
+// writeLine writes a conflict marker on a line of its own, ending the previous line first
+func writeLine(out *bytes.Buffer, line string) {
+	if out.Len() > 0 && out.Bytes()[out.Len()-1] != '\n' {
+		out.WriteByte('\n')
+	}
+	out.WriteString(line)
+	out.WriteByte('\n')
+}
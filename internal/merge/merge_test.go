@@ -0,0 +1,224 @@
+package merge
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestMerge tests merging changes made on both sides of a base
+func TestMerge(t *testing.T) {
+	base := "# Style\n\nUse gofmt.\nWrap at 100.\nNo globals.\n"
+
+	tests := []struct {
+		name          string
+		base          string
+		local         string
+		other         string
+		want          string
+		wantConflicts int
+	}{
+		{
+			name:  "Unchanged",
+			base:  base,
+			local: base,
+			other: base,
+			want:  base,
+		},
+		{
+			name:  "Changed locally",
+			base:  base,
+			local: "# Style\n\nUse gofmt.\nWrap at 120.\nNo globals.\n",
+			other: base,
+			want:  "# Style\n\nUse gofmt.\nWrap at 120.\nNo globals.\n",
+		},
+		{
+			name:  "Changed in the source",
+			base:  base,
+			local: base,
+			other: "# Go Style\n\nUse gofmt.\nWrap at 100.\nNo globals.\n",
+			want:  "# Go Style\n\nUse gofmt.\nWrap at 100.\nNo globals.\n",
+		},
+		{
+			name:  "Different regions",
+			base:  base,
+			local: "# Style\n\nUse gofmt.\nWrap at 120.\nNo globals.\nMy note.\n",
+			other: "# Go Style\n\nUse gofmt.\nWrap at 100.\nNo globals.\n",
+			want:  "# Go Style\n\nUse gofmt.\nWrap at 120.\nNo globals.\nMy note.\n",
+		},
+		{
+			name:  "Same change on both sides",
+			base:  base,
+			local: "# Style\n\nUse gofumpt.\nWrap at 100.\nNo globals.\n",
+			other: "# Style\n\nUse gofumpt.\nWrap at 100.\nNo globals.\n",
+			want:  "# Style\n\nUse gofumpt.\nWrap at 100.\nNo globals.\n",
+		},
+		{
+			name:          "Conflict",
+			base:          base,
+			local:         "# Style\n\nUse gofmt.\nWrap at 120.\nNo globals.\n",
+			other:         "# Style\n\nUse gofmt.\nWrap at 80.\nNo globals.\n",
+			want:          "# Style\n\nUse gofmt.\n<<<<<<< local\nWrap at 120.\n=======\nWrap at 80.\n>>>>>>> airule\nNo globals.\n",
+			wantConflicts: 1,
+		},
+		{
+			name:          "Without a base",
+			base:          "",
+			local:         "local\n",
+			other:         "other",
+			want:          "<<<<<<< local\nlocal\n=======\nother\n>>>>>>> airule\n",
+			wantConflicts: 1,
+		},
+		{
+			name:  "Lines deleted on one side",
+			base:  base,
+			local: "# Style\n\nUse gofmt.\nNo globals.\n",
+			other: "# Style\n\nUse gofmt.\nWrap at 100.\nNo globals.\nTest everything.\n",
+			want:  "# Style\n\nUse gofmt.\nNo globals.\nTest everything.\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, conflicts := Merge([]byte(tt.base), []byte(tt.local), []byte(tt.other))
+			if string(got) != tt.want || conflicts != tt.wantConflicts {
+				t.Errorf("Merge() = %q, %d conflict(s), want %q, %d", got, conflicts, tt.want, tt.wantConflicts)
+			}
+		})
+	}
+}
+
+// TestDiff tests unified diff hunks
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want string
+	}{
+		{
+			name: "Same lines",
+			old:  "a\nb\n",
+			new:  "a\nb\n",
+			want: "",
+		},
+		{
+			name: "Changed line",
+			old:  "a\nb\nc\n",
+			new:  "a\nB\nc\n",
+			want: "@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name: "Separate hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			new:  "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n",
+			want: "@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -10,3 +10,4 @@\n 10\n 11\n 12\n+13\n",
+		},
+		{
+			name: "Without a final newline",
+			old:  "a",
+			new:  "a\n",
+			want: "@@ -1,1 +1,1 @@\n-a\n\\ No newline at end of file\n+a\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(Diff([]byte(tt.old), []byte(tt.new))); got != tt.want {
+				t.Errorf("Diff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMatch tests that match finds a longest common subsequence, comparing its length with
+// the quadratic dynamic programming solution on random inputs
+func TestMatch(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	randomLines := func() []string {
+		lines := make([]string, rng.IntN(30))
+		for i := range lines {
+			lines[i] = string(rune('a' + rng.IntN(4)))
+		}
+		return lines
+	}
+
+	for n := 0; n < 500; n++ {
+		a, b := randomLines(), randomLines()
+		m := match(a, b)
+
+		length, prev := 0, -1
+		for i, j := range m {
+			if j < 0 {
+				continue
+			}
+			if j <= prev || a[i] != b[j] {
+				t.Fatalf("match(%q, %q) = %v is not a common subsequence", a, b, m)
+			}
+			prev = j
+			length++
+		}
+		if want := lcsLength(a, b); length != want {
+			t.Fatalf("match(%q, %q) has %d lines, want %d", a, b, length, want)
+		}
+	}
+}
+
+// lcsLength returns the length of a longest common subsequence of a and b
+func lcsLength(a, b []string) int {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	return lcs[0][0]
+}
+
+// TestMergeLargeFiles tests that files with many lines merge without quadratic memory
+func TestMergeLargeFiles(t *testing.T) {
+	var base, local, other strings.Builder
+	for i := 0; i < 20000; i++ {
+		line := fmt.Sprintf("line %d\n", i)
+		base.WriteString(line)
+		switch {
+		case i%1000 == 0:
+			local.WriteString("local " + line)
+			other.WriteString(line)
+		case i%1000 == 500:
+			local.WriteString(line)
+			other.WriteString("other " + line)
+		default:
+			local.WriteString(line)
+			other.WriteString(line)
+		}
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	merged, conflicts := Merge([]byte(base.String()), []byte(local.String()), []byte(other.String()))
+	runtime.ReadMemStats(&after)
+
+	if conflicts != 0 {
+		t.Errorf("Merge() conflicts = %d, want 0", conflicts)
+	}
+	if got := strings.Count(string(merged), "local "); got != 20 {
+		t.Errorf("Merge() kept %d local changes, want 20", got)
+	}
+	if got := strings.Count(string(merged), "other "); got != 20 {
+		t.Errorf("Merge() kept %d changes from airule, want 20", got)
+	}
+	// A table of 20000×20000 lines would take gigabytes
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+		t.Errorf("Merge() allocated %d MB", allocated>>20)
+	}
+}
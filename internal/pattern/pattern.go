@@ -0,0 +1,331 @@
+package pattern
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Match reports whether name matches the shell pattern.
+// Patterns and names are slash-separated. In addition to the syntax supported by
+// path.Match (*, ?, [classes] and escapes), it supports:
+//   - "**" as a whole path segment, matching zero or more directories
+//   - "[!...]" as a negated character class
+//   - brace expansion such as "*.{md,mdc}"
+//
+// The only possible returned error is path.ErrBadPattern.
+func Match(pattern, name string) (bool, error) {
+	alternatives, err := expandBraces(pattern)
+	if err != nil {
+		return false, err
+	}
+
+	nameSegs := splitSegments(name)
+	for _, alt := range alternatives {
+		matched, err := matchSegments(splitSegments(alt), nameSegs)
+		if err != nil {
+			return false, err
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Validate checks that the pattern is syntactically valid
+func Validate(pattern string) error {
+	_, err := Match(pattern, "")
+	return err
+}
+
+// MatchPath reports whether relPath is selected by pattern using the rules shared by
+// --include, --exclude, --pre-select and --clean-exclude:
+//   - a pattern without a slash is matched against the base name
+//   - a pattern with a slash is matched against the whole path relative to the root
+//   - a pattern matching a directory also matches everything below it
+//   - "dir/*" and "dir/**" also match the directory "dir" itself
+//
+// Invalid patterns never match.
+func MatchPath(pattern, relPath string) bool {
+	pattern = normalizePattern(pattern)
+	relPath = normalizePath(relPath)
+	if pattern == "" || relPath == "" {
+		return false
+	}
+
+	// Handle directory patterns specifically (e.g., "dir/*" or "dir/**")
+	if dirPattern, ok := directoryPrefix(pattern); ok {
+		if matched, _ := Match(dirPattern, relPath); matched {
+			return true
+		}
+	}
+
+	// Check the path itself and then every parent directory
+	for p := relPath; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+		if matchSelf(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchAny checks if a path matches any of the provided patterns
+func MatchAny(relPath string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if MatchPath(pattern, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesUnder reports whether pattern could match a path located below the directory dir.
+// It is used to keep directories whose contents are targeted by a pattern such as "config/*.json".
+func MatchesUnder(pattern, dir string) bool {
+	pattern = normalizePattern(pattern)
+	dir = normalizePath(dir)
+	if pattern == "" || dir == "" || !strings.Contains(pattern, "/") {
+		return false
+	}
+
+	alternatives, err := expandBraces(pattern)
+	if err != nil {
+		return false
+	}
+	dirSegs := splitSegments(dir)
+	for _, alt := range alternatives {
+		if matchPrefix(splitSegments(alt), dirSegs) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSelf matches a single path (without considering its parents) against pattern
+func matchSelf(pattern, relPath string) bool {
+	if !strings.Contains(pattern, "/") {
+		// Match just the basename if the pattern doesn't contain a separator
+		matched, _ := Match(pattern, path.Base(relPath))
+		return matched
+	}
+	matched, _ := Match(pattern, relPath)
+	return matched
+}
+
+// directoryPrefix returns "dir" for patterns of the form "dir/*" or "dir/**"
+func directoryPrefix(pattern string) (string, bool) {
+	for _, suffix := range []string{"/**", "/*"} {
+		if strings.HasSuffix(pattern, suffix) {
+			dir := strings.TrimSuffix(pattern, suffix)
+			return dir, dir != ""
+		}
+	}
+	return "", false
+}
+
+// normalizePattern converts a user supplied pattern into the slash-separated form used for matching.
+// A leading "/" or "./" only anchors the pattern to the root, which is already implied for patterns with a slash.
+func normalizePattern(pattern string) string {
+	pattern = filepath.ToSlash(pattern)
+	pattern = strings.TrimPrefix(pattern, "./")
+	if strings.HasPrefix(pattern, "/") {
+		pattern = strings.TrimLeft(pattern, "/")
+		if !strings.Contains(pattern, "/") && pattern != "" {
+			// Keep anchored single-segment patterns from matching nested base names
+			pattern = "./" + pattern
+		}
+	}
+	return pattern
+}
+
+// normalizePath converts a relative path into the clean, slash-separated form used for matching
+func normalizePath(relPath string) string {
+	if relPath == "" {
+		return ""
+	}
+	return strings.TrimPrefix(path.Clean(filepath.ToSlash(relPath)), "/")
+}
+
+// splitSegments splits a slash-separated string into its segments
+func splitSegments(s string) []string {
+	s = strings.TrimPrefix(s, "./")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "/")
+}
+
+// matchSegments matches pattern segments against name segments, expanding "**"
+func matchSegments(patSegs, nameSegs []string) (bool, error) {
+	for len(patSegs) > 0 {
+		seg := patSegs[0]
+		if seg == "**" {
+			// Collapse consecutive "**" segments
+			rest := patSegs[1:]
+			for len(rest) > 0 && rest[0] == "**" {
+				rest = rest[1:]
+			}
+			if len(rest) == 0 {
+				return true, nil
+			}
+			for i := 0; i <= len(nameSegs); i++ {
+				matched, err := matchSegments(rest, nameSegs[i:])
+				if err != nil || matched {
+					return matched, err
+				}
+			}
+			return false, nil
+		}
+
+		if len(nameSegs) == 0 {
+			// Still validate the remaining pattern so that bad patterns are always reported
+			if _, err := matchSegment(seg, ""); err != nil {
+				return false, err
+			}
+			return false, nil
+		}
+		matched, err := matchSegment(seg, nameSegs[0])
+		if err != nil || !matched {
+			return false, err
+		}
+		patSegs = patSegs[1:]
+		nameSegs = nameSegs[1:]
+	}
+	return len(nameSegs) == 0, nil
+}
+
+// matchPrefix reports whether the pattern segments can match some path below dirSegs
+func matchPrefix(patSegs, dirSegs []string) bool {
+	for len(dirSegs) > 0 {
+		if len(patSegs) == 0 {
+			return false
+		}
+		if patSegs[0] == "**" {
+			return true
+		}
+		matched, err := matchSegment(patSegs[0], dirSegs[0])
+		if err != nil || !matched {
+			return false
+		}
+		patSegs = patSegs[1:]
+		dirSegs = dirSegs[1:]
+	}
+	return len(patSegs) > 0
+}
+
+// matchSegment matches a single path segment, translating gitignore style "[!...]" classes
+func matchSegment(pattern, name string) (bool, error) {
+	// A "**" that is not a whole segment behaves like "*"
+	for strings.Contains(pattern, "**") {
+		pattern = strings.ReplaceAll(pattern, "**", "*")
+	}
+	return path.Match(translateClasses(pattern), name)
+}
+
+// translateClasses rewrites "[!" into "[^" which is what path.Match understands
+func translateClasses(pattern string) string {
+	if !strings.Contains(pattern, "[!") {
+		return pattern
+	}
+	var buf strings.Builder
+	escaped := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '[' && i+1 < len(pattern) && pattern[i+1] == '!':
+			buf.WriteString("[^")
+			i++
+			continue
+		}
+		buf.WriteByte(c)
+	}
+	return buf.String()
+}
+
+// expandBraces expands "{a,b}" alternatives, including nested ones, into the list of plain patterns
+func expandBraces(pattern string) ([]string, error) {
+	open := -1
+	depth := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			if depth == 0 {
+				open = i
+			}
+			depth++
+		case '}':
+			if depth == 0 {
+				return nil, path.ErrBadPattern
+			}
+			depth--
+			if depth > 0 {
+				continue
+			}
+
+			prefix, body, suffix := pattern[:open], pattern[open+1:i], pattern[i+1:]
+			suffixes, err := expandBraces(suffix)
+			if err != nil {
+				return nil, err
+			}
+			var result []string
+			for _, option := range splitAlternatives(body) {
+				expanded, err := expandBraces(option)
+				if err != nil {
+					return nil, err
+				}
+				for _, e := range expanded {
+					for _, s := range suffixes {
+						result = append(result, prefix+e+s)
+					}
+				}
+			}
+			return result, nil
+		}
+	}
+	if depth != 0 {
+		return nil, path.ErrBadPattern
+	}
+	return []string{pattern}, nil
+}
+
+// SplitList splits a comma-separated list of patterns, leaving the commas of "{a,b}"
+// alternatives alone: "*.md,*.{yml,yaml}" holds "*.md" and "*.{yml,yaml}"
+func SplitList(s string) []string {
+	var patterns []string
+	for _, p := range splitAlternatives(s) {
+		if p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// splitAlternatives splits the body of a brace expression on top-level commas
+func splitAlternatives(body string) []string {
+	var parts []string
+	depth := 0
+	start := 0
+	for i := 0; i < len(body); i++ {
+		switch body[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, body[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, body[start:])
+}
@@ -0,0 +1,139 @@
+package pattern
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestMatch tests the Match function with doublestar, classes and braces
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		path    string
+		want    bool
+		wantErr bool
+	}{
+		{name: "Simple wildcard", pattern: "*.md", path: "file.md", want: true},
+		{name: "Wildcard does not cross separators", pattern: "*.md", path: "dir/file.md", want: false},
+		{name: "Question mark", pattern: "file?.txt", path: "file1.txt", want: true},
+		{name: "Doublestar prefix matches root file", pattern: "**/*.md", path: "file.md", want: true},
+		{name: "Doublestar prefix matches nested file", pattern: "**/*.md", path: "a/b/c/file.md", want: true},
+		{name: "Doublestar in the middle", pattern: "rules/**/go-*.mdc", path: "rules/lang/go/go-style.mdc", want: true},
+		{name: "Doublestar in the middle matches zero dirs", pattern: "rules/**/go-*.mdc", path: "rules/go-style.mdc", want: true},
+		{name: "Doublestar in the middle no match", pattern: "rules/**/go-*.mdc", path: "other/go-style.mdc", want: false},
+		{name: "Trailing doublestar matches directory itself", pattern: "rules/**", path: "rules", want: true},
+		{name: "Trailing doublestar matches everything below", pattern: "rules/**", path: "rules/a/b.md", want: true},
+		{name: "Doublestar inside a segment behaves like star", pattern: "go-**.md", path: "go-style.md", want: true},
+		{name: "Character class", pattern: "file[0-9].txt", path: "file5.txt", want: true},
+		{name: "Negated character class with caret", pattern: "file[^0-9].txt", path: "file5.txt", want: false},
+		{name: "Negated character class with bang", pattern: "file[!0-9].txt", path: "filea.txt", want: true},
+		{name: "Negated character class with bang no match", pattern: "file[!0-9].txt", path: "file5.txt", want: false},
+		{name: "Brace expansion first alternative", pattern: "*.{md,mdc}", path: "rule.md", want: true},
+		{name: "Brace expansion second alternative", pattern: "*.{md,mdc}", path: "rule.mdc", want: true},
+		{name: "Brace expansion no match", pattern: "*.{md,mdc}", path: "rule.txt", want: false},
+		{name: "Nested brace expansion", pattern: "{docs,rules/{go,ts}}/*.md", path: "rules/ts/a.md", want: true},
+		{name: "Brace expansion with doublestar", pattern: "**/*.{md,mdc}", path: "a/b/rule.mdc", want: true},
+		{name: "Escaped brace is literal", pattern: `\{a,b\}`, path: "{a,b}", want: true},
+		{name: "Unclosed brace", pattern: "*.{md,mdc", path: "rule.md", wantErr: true},
+		{name: "Unclosed character class", pattern: "file[0-9.txt", path: "file1.txt", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Match(tt.pattern, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Match(%q, %q) error = %v, wantErr %v", tt.pattern, tt.path, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMatchPath tests the path semantics shared by include, exclude, pre-select and clean-exclude
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		path    string
+		want    bool
+	}{
+		{name: "Basename match at root", pattern: "*.txt", path: "file.txt", want: true},
+		{name: "Basename match in directory", pattern: "*.txt", path: "dir/file.txt", want: true},
+		{name: "Exact file", pattern: "file.txt", path: "file.txt", want: true},
+		{name: "Path pattern", pattern: "dir/*.txt", path: "dir/file.txt", want: true},
+		{name: "Path pattern does not match nested base names", pattern: "dir/*.txt", path: "other/dir/file.txt", want: false},
+		{name: "Directory pattern matches contents", pattern: "dir/*", path: "dir/file.txt", want: true},
+		{name: "Directory pattern matches directory itself", pattern: "dir/*", path: "dir", want: true},
+		{name: "Directory pattern matches nested contents", pattern: "dir/*", path: "dir/subdir/file.txt", want: true},
+		{name: "Doublestar directory pattern matches directory itself", pattern: "dir/**", path: "dir", want: true},
+		{name: "Matching directory name covers its contents", pattern: "drafts", path: "rules/drafts/a.md", want: true},
+		{name: "Doublestar with extension", pattern: "**/*.md", path: "a/b/c.md", want: true},
+		{name: "Doublestar in the middle", pattern: "rules/**/go-*.mdc", path: "rules/x/y/go-style.mdc", want: true},
+		{name: "Brace expansion", pattern: "*.{md,mdc}", path: "rules/style.mdc", want: true},
+		{name: "Leading slash anchors to root", pattern: "/file.txt", path: "file.txt", want: true},
+		{name: "Leading slash does not match nested", pattern: "/file.txt", path: "dir/file.txt", want: false},
+		{name: "Leading dot slash", pattern: "./dir/*.txt", path: "dir/file.txt", want: true},
+		{name: "Invalid pattern never matches", pattern: "[", path: "[", want: false},
+		{name: "Empty path", pattern: "*.txt", path: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchPath(tt.pattern, tt.path); got != tt.want {
+				t.Errorf("MatchPath(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMatchesUnder tests whether patterns are detected as targeting a directory's contents
+func TestMatchesUnder(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		dir     string
+		want    bool
+	}{
+		{name: "Files in directory", pattern: "config/*.json", dir: "config", want: true},
+		{name: "Directory wildcard", pattern: "dir/*", dir: "dir", want: true},
+		{name: "Doublestar below directory", pattern: "rules/**/*.md", dir: "rules/go", want: true},
+		{name: "Leading doublestar", pattern: "**/keep.md", dir: "any/dir", want: true},
+		{name: "Different directory", pattern: "config/*.json", dir: "other", want: false},
+		{name: "Pattern shallower than directory", pattern: "config/*.json", dir: "config/nested", want: false},
+		{name: "Basename pattern", pattern: "*.json", dir: "config", want: false},
+		{name: "Brace alternatives", pattern: "{config,settings}/*.json", dir: "settings", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchesUnder(tt.pattern, tt.dir); got != tt.want {
+				t.Errorf("MatchesUnder(%q, %q) = %v, want %v", tt.pattern, tt.dir, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSplitList tests splitting pattern lists on commas outside braces
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+	}{
+		{list: "*.md", want: []string{"*.md"}},
+		{list: "*.md,*.mdc", want: []string{"*.md", "*.mdc"}},
+		{list: "*.{md,mdc},rules/**/{go,py}/*", want: []string{"*.{md,mdc}", "rules/**/{go,py}/*"}},
+		{list: "a\\,b,c", want: []string{"a\\,b", "c"}},
+		{list: "*.md,", want: []string{"*.md"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.list, func(t *testing.T) {
+			if got := SplitList(tt.list); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitList(%q) = %v, want %v", tt.list, got, tt.want)
+			}
+		})
+	}
+}
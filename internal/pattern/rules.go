@@ -0,0 +1,167 @@
+package pattern
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Rule is a single gitignore-style rule
+type Rule struct {
+	// Pattern is the glob pattern without the "!" prefix and trailing "/"
+	Pattern string
+	// Negate re-includes paths matched by the pattern ("!pattern")
+	Negate bool
+	// DirOnly restricts the rule to directories ("pattern/")
+	DirOnly bool
+}
+
+// Rules is an ordered list of gitignore-style rules where the last matching rule wins
+type Rules []Rule
+
+// ParseRule parses a single gitignore-style line.
+// It returns false for blank lines and comments.
+func ParseRule(line string) (Rule, bool, error) {
+	line = strings.TrimRight(line, "\r")
+	// Trailing spaces are ignored unless they are escaped with a backslash
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = strings.TrimSuffix(line, " ")
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return Rule{}, false, nil
+	}
+
+	var rule Rule
+	switch {
+	case strings.HasPrefix(line, "!"):
+		rule.Negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\!`), strings.HasPrefix(line, `\#`):
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.DirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return Rule{}, false, fmt.Errorf("invalid rule: empty pattern")
+	}
+	if err := Validate(line); err != nil {
+		return Rule{}, false, fmt.Errorf("invalid rule %q: %w", line, err)
+	}
+
+	rule.Pattern = normalizePattern(line)
+	return rule, true, nil
+}
+
+// ParseRules parses gitignore-style lines into an ordered rule list
+func ParseRules(lines []string) (Rules, error) {
+	var rules Rules
+	for _, line := range lines {
+		rule, ok, err := ParseRule(line)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// LoadRules reads a gitignore-style rules file
+func LoadRules(filename string) (Rules, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open rules file: %w", err)
+	}
+	defer f.Close()
+
+	rules, err := ReadRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return rules, nil
+}
+
+// ReadRules parses gitignore-style rules from r, one rule per line
+func ReadRules(r io.Reader) (Rules, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+	return ParseRules(lines)
+}
+
+// ExcludeRules converts plain exclude patterns into rules
+func ExcludeRules(patterns []string) Rules {
+	rules := make(Rules, 0, len(patterns))
+	for _, p := range patterns {
+		rule := Rule{Pattern: normalizePattern(p)}
+		if strings.HasSuffix(rule.Pattern, "/") {
+			rule.DirOnly = true
+			rule.Pattern = strings.TrimRight(rule.Pattern, "/")
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// Excluded reports whether relPath is excluded by the rules.
+// Like .gitignore, each path inherits the state of its parent directory unless a rule
+// matches the path itself, in which case the last matching rule wins.
+// Unlike git, a negated rule can re-include a file inside an excluded directory.
+func (r Rules) Excluded(relPath string, isDir bool) bool {
+	relPath = normalizePath(relPath)
+	if relPath == "" || relPath == "." || len(r) == 0 {
+		return false
+	}
+
+	segs := strings.Split(relPath, "/")
+	excluded := false
+	for i := range segs {
+		current := strings.Join(segs[:i+1], "/")
+		currentIsDir := isDir || i < len(segs)-1
+		for _, rule := range r {
+			if rule.Match(current, currentIsDir) {
+				excluded = !rule.Negate
+			}
+		}
+	}
+	return excluded
+}
+
+// MayReinclude reports whether a negated rule could re-include something below dir.
+// Excluded directories can only be pruned from a walk when this returns false.
+func (r Rules) MayReinclude(dir string) bool {
+	for _, rule := range r {
+		if !rule.Negate {
+			continue
+		}
+		if !strings.Contains(rule.Pattern, "/") || MatchesUnder(rule.Pattern, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether the rule pattern matches relPath itself, without considering
+// its parent directories. Negation does not affect the result.
+func (rule Rule) Match(relPath string, isDir bool) bool {
+	if rule.DirOnly && !isDir {
+		return false
+	}
+	if !strings.Contains(rule.Pattern, "/") {
+		matched, _ := Match(rule.Pattern, path.Base(relPath))
+		return matched
+	}
+	matched, _ := Match(rule.Pattern, relPath)
+	return matched
+}
@@ -0,0 +1,138 @@
+package pattern
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestParseRule tests parsing of gitignore-style lines
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    Rule
+		wantOK  bool
+		wantErr bool
+	}{
+		{name: "Blank line", line: "", wantOK: false},
+		{name: "Comment", line: "# drafts", wantOK: false},
+		{name: "Plain pattern", line: "*.tmp", want: Rule{Pattern: "*.tmp"}, wantOK: true},
+		{name: "Negated pattern", line: "!keep.md", want: Rule{Pattern: "keep.md", Negate: true}, wantOK: true},
+		{name: "Directory only", line: "drafts/", want: Rule{Pattern: "drafts", DirOnly: true}, wantOK: true},
+		{name: "Negated directory", line: "!drafts/", want: Rule{Pattern: "drafts", Negate: true, DirOnly: true}, wantOK: true},
+		{name: "Escaped bang", line: `\!important.md`, want: Rule{Pattern: "!important.md"}, wantOK: true},
+		{name: "Escaped hash", line: `\#notes.md`, want: Rule{Pattern: "#notes.md"}, wantOK: true},
+		{name: "Trailing spaces are trimmed", line: "*.log   ", want: Rule{Pattern: "*.log"}, wantOK: true},
+		{name: "Anchored pattern", line: "/build", want: Rule{Pattern: "./build"}, wantOK: true},
+		{name: "Invalid pattern", line: "*.{md", wantErr: true},
+		{name: "Empty negation", line: "!", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := ParseRule(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRule(%q) error = %v, wantErr %v", tt.line, err, tt.wantErr)
+			}
+			if ok != tt.wantOK {
+				t.Errorf("ParseRule(%q) ok = %v, want %v", tt.line, ok, tt.wantOK)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRule(%q) = %+v, want %+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRulesExcluded tests ordered evaluation with negation and directory-only rules
+func TestRulesExcluded(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{name: "No rules", lines: nil, path: "a.md", want: false},
+		{name: "Simple exclude", lines: []string{"*.tmp"}, path: "dir/a.tmp", want: true},
+		{name: "Last match wins", lines: []string{"*.md", "!keep.md"}, path: "docs/keep.md", want: false},
+		{name: "Last match wins reversed", lines: []string{"!keep.md", "*.md"}, path: "docs/keep.md", want: true},
+		{name: "Excluded directory excludes its contents", lines: []string{"drafts/"}, path: "drafts/wip.md", want: true},
+		{name: "Excluded directory itself", lines: []string{"drafts/"}, path: "drafts", isDir: true, want: true},
+		{name: "Re-include file inside excluded directory", lines: []string{"drafts/", "!drafts/approved.md"}, path: "drafts/approved.md", want: false},
+		{name: "Other files stay excluded", lines: []string{"drafts/", "!drafts/approved.md"}, path: "drafts/wip.md", want: true},
+		{name: "Directory only does not match files", lines: []string{"drafts/"}, path: "drafts", isDir: false, want: false},
+		{name: "Nested directory name", lines: []string{"drafts/"}, path: "rules/drafts/wip.md", want: true},
+		{name: "Anchored rule matches at root", lines: []string{"/build"}, path: "build/out.md", want: true},
+		{name: "Anchored rule does not match nested", lines: []string{"/build"}, path: "src/build/out.md", want: false},
+		{name: "Doublestar rule", lines: []string{"rules/**/*.tmp"}, path: "rules/a/b/c.tmp", want: true},
+		{name: "Re-included directory", lines: []string{"*", "!rules/", "!rules/**"}, path: "rules/go.md", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseRules(tt.lines)
+			if err != nil {
+				t.Fatalf("ParseRules() error = %v", err)
+			}
+			if got := rules.Excluded(tt.path, tt.isDir); got != tt.want {
+				t.Errorf("Excluded(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRulesMayReinclude tests detection of negations that reach into a directory
+func TestRulesMayReinclude(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		dir   string
+		want  bool
+	}{
+		{name: "No negation", lines: []string{"drafts/"}, dir: "drafts", want: false},
+		{name: "Negation inside directory", lines: []string{"drafts/", "!drafts/approved.md"}, dir: "drafts", want: true},
+		{name: "Negation in another directory", lines: []string{"drafts/", "!docs/approved.md"}, dir: "drafts", want: false},
+		{name: "Basename negation can match anywhere", lines: []string{"drafts/", "!approved.md"}, dir: "drafts", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseRules(tt.lines)
+			if err != nil {
+				t.Fatalf("ParseRules() error = %v", err)
+			}
+			if got := rules.MayReinclude(tt.dir); got != tt.want {
+				t.Errorf("MayReinclude(%q) = %v, want %v", tt.dir, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestLoadRules tests reading rules from a file
+func TestLoadRules(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules")
+	content := "# team rules\n\ndrafts/\n!drafts/approved.md\n"
+	if err := os.WriteFile(rulesFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write rules file: %v", err)
+	}
+
+	rules, err := LoadRules(rulesFile)
+	if err != nil {
+		t.Fatalf("LoadRules() error = %v", err)
+	}
+
+	want := Rules{
+		{Pattern: "drafts", DirOnly: true},
+		{Pattern: "drafts/approved.md", Negate: true},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("LoadRules() = %+v, want %+v", rules, want)
+	}
+
+	if _, err := LoadRules(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("LoadRules() should fail for a missing file")
+	}
+}
@@ -2,59 +2,121 @@ package preview
 
 import (
 	"fmt"
-	"os"
+	"io/fs"
+	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
+
+	"github.com/upamune/airule/internal/content"
+	"github.com/upamune/airule/internal/linkfs"
 )
 
 // MaxPreviewSize is the maximum size of a file to preview (100KB)
 const MaxPreviewSize = 100 * 1024
 
+// ANSI sequences used to highlight matches in the preview window
+const (
+	highlightStart = "\x1b[1;33m"
+	highlightEnd   = "\x1b[0m"
+)
+
 // GeneratePreview generates a preview of the file at the given path
 // This function is designed to work with go-fuzzyfinder's preview window
 func GeneratePreview(baseDir, relPath string, width, height int) (string, error) {
-	fullPath := filepath.Join(baseDir, relPath)
+	return generatePreview(linkfs.Dir(baseDir), relPath, filepath.Join(baseDir, relPath), width, height, Options{})
+}
+
+// GeneratePreviewFS generates a preview of the file at relPath inside fsys,
+// which may be a directory on disk, an archive or any other fs.FS
+func GeneratePreviewFS(fsys fs.FS, relPath string, width, height int) (string, error) {
+	return generatePreview(fsys, relPath, relPath, width, height, Options{})
+}
+
+// Options configures GeneratePreviewWithOptions
+type Options struct {
+	// Highlights are patterns whose matches are highlighted. When the first matching line
+	// would not be visible, the preview starts just above it.
+	Highlights []*regexp.Regexp
+	// Symlinks decides whether symbolic links are shown as their targets, as links or not at all
+	Symlinks linkfs.Policy
+}
+
+// GeneratePreviewWithOptions is GeneratePreviewFS with highlighting and symbolic link handling
+func GeneratePreviewWithOptions(fsys fs.FS, relPath string, width, height int, opts Options) (string, error) {
+	return generatePreview(fsys, relPath, relPath, width, height, opts)
+}
+
+// generatePreview generates the preview of relPath, using displayPath in messages
+func generatePreview(fsys fs.FS, relPath, displayPath string, width, height int, opts Options) (string, error) {
+	name := filepath.ToSlash(relPath)
+
+	// A preserved link is shown as a link, followed by a preview of its target if there is one
+	var header string
+	if opts.Symlinks == linkfs.Preserve {
+		if info, err := linkfs.Lstat(fsys, name); err == nil && linkfs.IsLink(info.Mode()) {
+			target, err := linkfs.ReadLink(fsys, name)
+			if err != nil {
+				return "", fmt.Errorf("failed to read link: %w", err)
+			}
+			header = fmt.Sprintf("Symbolic link to %s\n\n", target)
+			height -= 2
+		}
+	}
 
 	// Get file info
-	info, err := os.Stat(fullPath)
+	info, err := fs.Stat(fsys, name)
 	if err != nil {
+		if header != "" {
+			return header + "(target does not exist)", nil
+		}
 		return "", fmt.Errorf("failed to get file info: %w", err)
 	}
 
 	// Handle directory
 	if info.IsDir() {
-		return generateDirectoryPreview(fullPath, width, height)
+		preview, err := generateDirectoryPreview(fsys, name, displayPath, width, height, opts.Symlinks)
+		return header + preview, err
 	}
 
+	preview, err := generateFilePreview(fsys, name, info, width, height, opts.Highlights)
+	return header + preview, err
+}
+
+// generateFilePreview generates the preview of a regular file
+func generateFilePreview(fsys fs.FS, name string, info fs.FileInfo, width, height int, highlights []*regexp.Regexp) (string, error) {
 	// Check file size
 	if info.Size() > MaxPreviewSize {
 		return fmt.Sprintf("File too large to preview (%.2f MB)", float64(info.Size())/1024/1024), nil
 	}
 
 	// Read file content
-	content, err := os.ReadFile(fullPath)
+	data, err := fs.ReadFile(fsys, name)
 	if err != nil {
 		return "", fmt.Errorf("failed to read file: %w", err)
 	}
 
 	// Check if it's a binary file
-	if isBinaryFilename(fullPath) {
-		return fmt.Sprintf("Binary file (%s, %.2f KB)", filepath.Base(fullPath), float64(info.Size())/1024), nil
+	if isBinaryFilename(name) || content.IsBinary(data) {
+		return fmt.Sprintf("Binary file (%s, %.2f KB)", path.Base(name), float64(info.Size())/1024), nil
 	}
 
 	// Format the content for display
-	return formatContentForDisplay(string(content), width, height), nil
+	if len(highlights) > 0 {
+		return formatHighlightedContent(string(data), width, height, highlights), nil
+	}
+	return formatContentForDisplay(string(data), width, height), nil
 }
 
 // generateDirectoryPreview generates a preview of the directory contents
-func generateDirectoryPreview(dirPath string, width, height int) (string, error) {
-	entries, err := os.ReadDir(dirPath)
+func generateDirectoryPreview(fsys fs.FS, name, displayPath string, width, height int, symlinks linkfs.Policy) (string, error) {
+	entries, err := fs.ReadDir(fsys, name)
 	if err != nil {
 		return "", fmt.Errorf("failed to read directory: %w", err)
 	}
 
 	var buf strings.Builder
-	buf.WriteString(fmt.Sprintf("Directory: %s\n\n", dirPath))
+	buf.WriteString(fmt.Sprintf("Directory: %s\n\n", displayPath))
 	buf.WriteString("Contents:\n")
 
 	for _, entry := range entries {
@@ -63,8 +125,25 @@ func generateDirectoryPreview(dirPath string, width, height int) (string, error)
 			continue
 		}
 
+		// Links are listed by their target unless they are kept as links
+		if linkfs.IsLink(entry.Type()) {
+			entryPath := path.Join(name, entry.Name())
+			switch symlinks {
+			case linkfs.Skip:
+				continue
+			case linkfs.Preserve:
+				target, _ := linkfs.ReadLink(fsys, entryPath)
+				buf.WriteString(fmt.Sprintf("[L] %s -> %s\n", entry.Name(), target))
+				continue
+			}
+			if info, err = fs.Stat(fsys, entryPath); err != nil {
+				buf.WriteString(fmt.Sprintf("[L] %s (broken link)\n", entry.Name()))
+				continue
+			}
+		}
+
 		// Format: [D] dirname/ or [F] filename (size)
-		if entry.IsDir() {
+		if info.IsDir() {
 			buf.WriteString(fmt.Sprintf("[D] %s/\n", entry.Name()))
 		} else {
 			buf.WriteString(fmt.Sprintf("[F] %s (%.2f KB)\n", entry.Name(), float64(info.Size())/1024))
@@ -80,21 +159,102 @@ func formatContentForDisplay(content string, width, height int) string {
 	lines := strings.Split(content, "\n")
 
 	// Limit the number of lines to display based on height
-	if len(lines) > height-2 { // Leave some space for borders
-		lines = lines[:height-2]
-		lines = append(lines, "... (truncated)")
+	if limit := max(height-2, 1); len(lines) > limit { // Leave some space for borders
+		lines = append(lines[:limit:limit], "... (truncated)")
 	}
 
 	// Truncate long lines based on width
 	for i, line := range lines {
-		if len(line) > width-4 { // Leave some space for borders
-			lines[i] = line[:width-7] + "..."
-		}
+		lines[i] = truncateLine(line, width)
 	}
 
 	return strings.Join(lines, "\n")
 }
 
+// truncateLine shortens a line that does not fit in the preview window
+func truncateLine(line string, width int) string {
+	if len(line) > width-4 { // Leave some space for borders
+		return line[:max(width-7, 0)] + "..."
+	}
+	return line
+}
+
+// formatHighlightedContent formats the content like formatContentForDisplay and highlights
+// the matches of the patterns. If the first matching line is below the visible area, the
+// display starts two lines above it, or fewer when the window is too small to show them.
+func formatHighlightedContent(text string, width, height int, highlights []*regexp.Regexp) string {
+	lines := strings.Split(text, "\n")
+
+	first := -1
+	for i, line := range lines {
+		if matchesAny(line, highlights) {
+			first = i
+			break
+		}
+	}
+
+	var header []string
+	if first >= 0 && first >= height-2 {
+		// One line is taken by the header, one more is needed for the match itself
+		context := min(2, max(height-4, 0))
+		if start := max(first-context, 0); start > 0 {
+			header = append(header, fmt.Sprintf("... (%d lines above)", start))
+			lines = lines[start:]
+		}
+	}
+
+	// Limit the number of lines to display based on height, keeping space for the header
+	if limit := max(height-2-len(header), 1); len(lines) > limit {
+		lines = append(lines[:limit:limit], "... (truncated)")
+	}
+
+	for i, line := range lines {
+		lines[i] = highlight(truncateLine(line, width), highlights)
+	}
+
+	return strings.Join(append(header, lines...), "\n")
+}
+
+// matchesAny reports whether any pattern matches the line
+func matchesAny(line string, patterns []*regexp.Regexp) bool {
+	for _, re := range patterns {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// highlight wraps every match of the patterns in the line with ANSI highlighting.
+// Overlapping matches are merged.
+func highlight(line string, patterns []*regexp.Regexp) string {
+	marked := make([]bool, len(line))
+	found := false
+	for _, re := range patterns {
+		for _, loc := range re.FindAllStringIndex(line, -1) {
+			for i := loc[0]; i < loc[1]; i++ {
+				marked[i] = true
+				found = true
+			}
+		}
+	}
+	if !found {
+		return line
+	}
+
+	var buf strings.Builder
+	for i := 0; i < len(line); i++ {
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			buf.WriteString(highlightStart)
+		}
+		buf.WriteByte(line[i])
+		if marked[i] && (i == len(line)-1 || !marked[i+1]) {
+			buf.WriteString(highlightEnd)
+		}
+	}
+	return buf.String()
+}
+
 // isBinaryFilename checks if the filename suggests a binary file
 func isBinaryFilename(filename string) bool {
 	ext := strings.ToLower(filepath.Ext(filename))
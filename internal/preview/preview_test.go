@@ -0,0 +1,277 @@
+package preview
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/upamune/airule/internal/linkfs"
+	"github.com/upamune/airule/internal/memfs"
+)
+
+// TestGeneratePreviewFS tests previews of files and directories from an in-memory file system
+func TestGeneratePreviewFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"rules/go.md":       {Data: []byte("line1\nline2\nline3\nline4\nline5")},
+		"rules/long.md":     {Data: []byte(strings.Repeat("x", 50))},
+		"rules/nested/a.md": {Data: []byte("a")},
+		"rules/logo.png":    {Data: []byte{0x89, 'P', 'N', 'G'}},
+		"large.md":          {Data: make([]byte, MaxPreviewSize+1)},
+	}
+
+	tests := []struct {
+		name     string
+		relPath  string
+		width    int
+		height   int
+		want     []string
+		wantNone []string
+	}{
+		{
+			name:    "Small file",
+			relPath: "rules/go.md",
+			width:   80, height: 20,
+			want: []string{"line1\nline2\nline3\nline4\nline5"},
+		},
+		{
+			name:    "Truncated lines",
+			relPath: "rules/go.md",
+			width:   80, height: 4,
+			want:     []string{"line1\nline2\n... (truncated)"},
+			wantNone: []string{"line3"},
+		},
+		{
+			name:    "Truncated width",
+			relPath: "rules/long.md",
+			width:   20, height: 10,
+			want: []string{strings.Repeat("x", 13) + "..."},
+		},
+		{
+			name:    "Directory",
+			relPath: "rules",
+			width:   80, height: 20,
+			want: []string{"Directory: rules", "[D] nested/", "[F] go.md"},
+		},
+		{
+			name:    "Binary file",
+			relPath: "rules/logo.png",
+			width:   80, height: 20,
+			want: []string{"Binary file (logo.png"},
+		},
+		{
+			name:    "Large file",
+			relPath: "large.md",
+			width:   80, height: 20,
+			want: []string{"File too large to preview"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GeneratePreviewFS(fsys, tt.relPath, tt.width, tt.height)
+			if err != nil {
+				t.Fatalf("GeneratePreviewFS() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("GeneratePreviewFS() = %q, want it to contain %q", got, want)
+				}
+			}
+			for _, unwanted := range tt.wantNone {
+				if strings.Contains(got, unwanted) {
+					t.Errorf("GeneratePreviewFS() = %q, should not contain %q", got, unwanted)
+				}
+			}
+		})
+	}
+
+	if _, err := GeneratePreviewFS(fsys, "missing.md", 80, 20); err == nil {
+		t.Error("GeneratePreviewFS() should fail for a missing file")
+	}
+}
+
+// TestGeneratePreviewHighlight tests highlighting matches and scrolling to the first matching line
+func TestGeneratePreviewHighlight(t *testing.T) {
+	var lines []string
+	for i := 1; i <= 30; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	lines[24] = "run golangci-lint and golangci-lint again"
+	fsys := fstest.MapFS{
+		"lint.md":  {Data: []byte(strings.Join(lines, "\n"))},
+		"top.md":   {Data: []byte("golangci-lint\nsecond")},
+		"data.txt": {Data: []byte("golangci-lint\x00")},
+	}
+	re := regexp.MustCompile(`golangci-lint`)
+	marked := highlightStart + "golangci-lint" + highlightEnd
+
+	got, err := GeneratePreviewWithOptions(fsys, "lint.md", 80, 10, Options{Highlights: []*regexp.Regexp{re}})
+	if err != nil {
+		t.Fatalf("GeneratePreviewWithOptions() error = %v", err)
+	}
+	want := strings.Join([]string{
+		"... (22 lines above)",
+		"line 23",
+		"line 24",
+		"run " + marked + " and " + marked + " again",
+		"line 26",
+		"line 27",
+		"line 28",
+		"line 29",
+		"... (truncated)",
+	}, "\n")
+	if got != want {
+		t.Errorf("GeneratePreviewWithOptions() =\n%q\nwant\n%q", got, want)
+	}
+
+	// A visible match does not scroll
+	got, err = GeneratePreviewWithOptions(fsys, "top.md", 80, 10, Options{Highlights: []*regexp.Regexp{re}})
+	if err != nil {
+		t.Fatalf("GeneratePreviewWithOptions() error = %v", err)
+	}
+	if want := marked + "\nsecond"; got != want {
+		t.Errorf("GeneratePreviewWithOptions() = %q, want %q", got, want)
+	}
+
+	// Without patterns the preview is unchanged
+	plain, _ := GeneratePreviewFS(fsys, "top.md", 80, 10)
+	got, _ = GeneratePreviewWithOptions(fsys, "top.md", 80, 10, Options{})
+	if got != plain {
+		t.Errorf("GeneratePreviewWithOptions() without patterns = %q, want %q", got, plain)
+	}
+
+	// Binary content is detected regardless of the extension
+	got, _ = GeneratePreviewWithOptions(fsys, "data.txt", 80, 10, Options{Highlights: []*regexp.Regexp{re}})
+	if !strings.HasPrefix(got, "Binary file (data.txt") {
+		t.Errorf("GeneratePreviewWithOptions() for binary content = %q", got)
+	}
+}
+
+// TestGeneratePreviewSmallWindow tests that tiny preview windows do not fail, with and without matches
+func TestGeneratePreviewSmallWindow(t *testing.T) {
+	fsys := fstest.MapFS{
+		"lint.md":  {Data: []byte("first\nsecond\nrun golangci-lint\nfourth")},
+		"plain.md": {Data: []byte("first\nsecond\nthird")},
+	}
+	highlights := Options{Highlights: []*regexp.Regexp{regexp.MustCompile(`golangci-lint|missing`)}}
+
+	for _, name := range []string{"lint.md", "plain.md"} {
+		for height := -1; height <= 4; height++ {
+			for _, width := range []int{0, 3, 80} {
+				for _, opts := range []Options{{}, highlights} {
+					got, err := GeneratePreviewWithOptions(fsys, name, width, height, opts)
+					if err != nil {
+						t.Errorf("GeneratePreviewWithOptions(%s, %d, %d) error = %v", name, width, height, err)
+					}
+					if got == "" {
+						t.Errorf("GeneratePreviewWithOptions(%s, %d, %d) is empty", name, width, height)
+					}
+				}
+			}
+		}
+	}
+
+	// The match is kept in view in a three line window
+	got, err := GeneratePreviewWithOptions(fsys, "lint.md", 80, 3, highlights)
+	if err != nil {
+		t.Fatalf("GeneratePreviewWithOptions() error = %v", err)
+	}
+	if want := "... (2 lines above)\nrun " + highlightStart + "golangci-lint" + highlightEnd + "\n... (truncated)"; got != want {
+		t.Errorf("GeneratePreviewWithOptions() = %q, want %q", got, want)
+	}
+}
+
+// TestHighlight tests that overlapping matches of several patterns are merged
+func TestHighlight(t *testing.T) {
+	patterns := []*regexp.Regexp{regexp.MustCompile(`abc`), regexp.MustCompile(`cde`)}
+	if got, want := highlight("xabcdex", patterns), "x"+highlightStart+"abcde"+highlightEnd+"x"; got != want {
+		t.Errorf("highlight() = %q, want %q", got, want)
+	}
+	if got := highlight("nothing", patterns); got != "nothing" {
+		t.Errorf("highlight() without matches = %q", got)
+	}
+}
+
+// TestGeneratePreviewSymlinks tests previews of links and of directories containing links
+func TestGeneratePreviewSymlinks(t *testing.T) {
+	fsys := memfs.New()
+	if err := fsys.WriteFile("shared/go.md", []byte("go rules"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range map[string]string{
+		"rules/go.md":     "../shared/go.md",
+		"rules/broken.md": "missing.md",
+	} {
+		if err := fsys.Symlink(target, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		relPath  string
+		policy   linkfs.Policy
+		want     []string
+		wantNone []string
+	}{
+		{
+			name:     "Follow shows the target",
+			relPath:  "rules/go.md",
+			policy:   linkfs.Follow,
+			want:     []string{"go rules"},
+			wantNone: []string{"Symbolic link"},
+		},
+		{
+			name:    "Preserve shows the link and the target",
+			relPath: "rules/go.md",
+			policy:  linkfs.Preserve,
+			want:    []string{"Symbolic link to ../shared/go.md\n\ngo rules"},
+		},
+		{
+			name:    "Preserve shows a broken link",
+			relPath: "rules/broken.md",
+			policy:  linkfs.Preserve,
+			want:    []string{"Symbolic link to missing.md", "(target does not exist)"},
+		},
+		{
+			name:     "Follow lists links by their target",
+			relPath:  "rules",
+			policy:   linkfs.Follow,
+			want:     []string{"[F] go.md", "[L] broken.md (broken link)"},
+			wantNone: []string{"->"},
+		},
+		{
+			name:    "Preserve lists links",
+			relPath: "rules",
+			policy:  linkfs.Preserve,
+			want:    []string{"[L] go.md -> ../shared/go.md", "[L] broken.md -> missing.md"},
+		},
+		{
+			name:     "Skip omits links",
+			relPath:  "rules",
+			policy:   linkfs.Skip,
+			wantNone: []string{"go.md", "broken.md"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GeneratePreviewWithOptions(fsys, tt.relPath, 80, 20, Options{Symlinks: tt.policy})
+			if err != nil {
+				t.Fatalf("GeneratePreviewWithOptions() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("GeneratePreviewWithOptions() = %q, want it to contain %q", got, want)
+				}
+			}
+			for _, none := range tt.wantNone {
+				if strings.Contains(got, none) {
+					t.Errorf("GeneratePreviewWithOptions() = %q, want it not to contain %q", got, none)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,338 @@
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/upamune/airule/internal/memfs"
+)
+
+// maxSymlinkDepth bounds how many links are followed when resolving an archive symlink
+const maxSymlinkDepth = 40
+
+// Limits on the data read from an archive, so that a hostile or very large archive cannot
+// exhaust memory. Links resolved to copies of their targets count towards the total.
+var (
+	// maxArchiveEntrySize is the largest file an archive may contain
+	maxArchiveEntrySize int64 = 32 << 20
+	// maxArchiveSize is the largest total size of the files of an archive
+	maxArchiveSize int64 = 256 << 20
+)
+
+// archiveFormat returns the archive format of a --from argument based on its extension
+func archiveFormat(spec string) (string, bool) {
+	lower := strings.ToLower(spec)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz", true
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar", true
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip", true
+	}
+	return "", false
+}
+
+// resolveArchive loads an archive into memory so that it can be browsed, previewed and
+// copied without extracting it to disk
+func resolveArchive(spec, format string) (*Source, error) {
+	archivePath, err := filepath.Abs(expandHome(spec))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve archive path %s: %w", spec, err)
+	}
+
+	loader := newArchiveLoader()
+	switch format {
+	case "zip":
+		err = loader.loadZip(archivePath)
+	default:
+		err = loader.loadTar(archivePath, format == "tar.gz")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive %s: %w", spec, err)
+	}
+	if err := loader.resolveLinks(); err != nil {
+		return nil, fmt.Errorf("failed to read archive %s: %w", spec, err)
+	}
+
+	return &Source{
+		Spec:     spec,
+		Name:     spec,
+		Root:     archivePath,
+		FS:       loader.fsys,
+		Warnings: loader.warnings,
+	}, nil
+}
+
+// archiveLoader collects archive entries into an in-memory file system
+type archiveLoader struct {
+	fsys *memfs.FS
+	// links maps link paths to their (unresolved) targets
+	links    map[string]string
+	warnings []string
+	// size is the total size of the files loaded so far
+	size int64
+}
+
+// newArchiveLoader creates an empty loader
+func newArchiveLoader() *archiveLoader {
+	return &archiveLoader{
+		fsys:  memfs.New(),
+		links: make(map[string]string),
+	}
+}
+
+// loadTar reads a tar archive, optionally gzip compressed
+func (l *archiveLoader) loadTar(archivePath string, gzipped bool) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if gzipped {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name, ok := l.safeName(hdr.Name)
+		if !ok {
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := l.fsys.MkdirAll(name, fs.FileMode(hdr.Mode).Perm()); err != nil {
+				l.warn("%s: %v", hdr.Name, err)
+			}
+		case tar.TypeReg:
+			data, err := l.read(hdr.Name, hdr.Size, tr)
+			if err != nil {
+				return err
+			}
+			l.writeFile(hdr.Name, name, data, fs.FileMode(hdr.Mode), hdr.ModTime)
+		case tar.TypeSymlink:
+			l.links[name] = path.Join(path.Dir(name), hdr.Linkname)
+			if path.IsAbs(hdr.Linkname) {
+				l.links[name] = hdr.Linkname
+			}
+		case tar.TypeLink:
+			// Hard links refer to another entry of the archive by its name
+			target, ok := l.safeName(hdr.Linkname)
+			if !ok {
+				continue
+			}
+			l.links[name] = target
+		default:
+			l.warn("%s: unsupported entry type, skipped", hdr.Name)
+		}
+	}
+}
+
+// loadZip reads a zip archive
+func (l *archiveLoader) loadZip(archivePath string) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		name, ok := l.safeName(zf.Name)
+		if !ok {
+			continue
+		}
+
+		mode := zf.Mode()
+		switch {
+		case mode.IsDir():
+			if err := l.fsys.MkdirAll(name, mode.Perm()); err != nil {
+				l.warn("%s: %v", zf.Name, err)
+			}
+		case mode&fs.ModeSymlink != 0:
+			target, err := l.readZipFile(zf)
+			if err != nil {
+				return err
+			}
+			l.links[name] = path.Join(path.Dir(name), string(target))
+			if path.IsAbs(string(target)) {
+				l.links[name] = string(target)
+			}
+		case mode.IsRegular():
+			data, err := l.readZipFile(zf)
+			if err != nil {
+				return err
+			}
+			l.writeFile(zf.Name, name, data, mode, zf.Modified)
+		default:
+			l.warn("%s: unsupported entry type, skipped", zf.Name)
+		}
+	}
+	return nil
+}
+
+// readZipFile reads the content of a zip entry
+func (l *archiveLoader) readZipFile(zf *zip.File) ([]byte, error) {
+	size := int64(zf.UncompressedSize64)
+	if zf.UncompressedSize64 > uint64(maxArchiveEntrySize) {
+		size = maxArchiveEntrySize + 1
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return l.read(zf.Name, size, rc)
+}
+
+// read reads an entry of the given declared size, failing when it exceeds the size limits.
+// The declared size is checked before reading, and the data actually read is checked again,
+// since a compressed stream may hold more than its header claims.
+func (l *archiveLoader) read(name string, size int64, r io.Reader) ([]byte, error) {
+	if err := l.reserve(name, size); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxArchiveEntrySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if n := int64(len(data)); n > size {
+		if n > maxArchiveEntrySize {
+			return nil, entryTooLarge(name)
+		}
+		if err := l.reserve(name, n-size); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// reserve counts n more bytes of the entry name towards the size limits
+func (l *archiveLoader) reserve(name string, n int64) error {
+	if n > maxArchiveEntrySize {
+		return entryTooLarge(name)
+	}
+	if l.size+n > maxArchiveSize {
+		return fmt.Errorf("%s: archive content is larger than the limit of %d bytes", name, maxArchiveSize)
+	}
+	l.size += n
+	return nil
+}
+
+// entryTooLarge returns the error for an entry exceeding maxArchiveEntrySize
+func entryTooLarge(name string) error {
+	return fmt.Errorf("%s: entry is larger than the limit of %d bytes", name, maxArchiveEntrySize)
+}
+
+// writeFile stores a regular file entry
+func (l *archiveLoader) writeFile(rawName, name string, data []byte, mode fs.FileMode, modTime time.Time) {
+	if err := l.fsys.WriteFileTime(name, data, mode.Perm(), modTime); err != nil {
+		l.warn("%s: %v", rawName, err)
+	}
+}
+
+// safeName validates an archive entry name. Absolute names and names escaping the
+// archive root via ".." are rejected so nothing outside the archive tree can be addressed.
+func (l *archiveLoader) safeName(raw string) (string, bool) {
+	name := strings.ReplaceAll(raw, "\\", "/")
+	if path.IsAbs(name) || (len(name) >= 2 && name[1] == ':') {
+		l.warn("%s: absolute path, skipped", raw)
+		return "", false
+	}
+	name = path.Clean(name)
+	if name == ".." || strings.HasPrefix(name, "../") {
+		l.warn("%s: path escapes the archive, skipped", raw)
+		return "", false
+	}
+	if name == "." {
+		return "", false
+	}
+	return name, true
+}
+
+// resolveLinks replaces links to regular files inside the archive with copies of their targets.
+// Links pointing outside the archive, to directories or to missing entries are skipped.
+// The copies count towards the size limit of the archive.
+func (l *archiveLoader) resolveLinks() error {
+	names := make([]string, 0, len(l.links))
+	for name := range l.links {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		target := l.links[name]
+		resolved, ok := l.followLink(target)
+		if !ok {
+			l.warn("%s: link target %s is outside the archive or missing, skipped", name, target)
+			continue
+		}
+		info, err := l.fsys.Stat(resolved)
+		if err != nil || info.IsDir() {
+			l.warn("%s: links to directories are not supported, skipped", name)
+			continue
+		}
+		if err := l.reserve(name, info.Size()); err != nil {
+			return err
+		}
+		data, err := l.fsys.ReadFile(resolved)
+		if err != nil {
+			l.warn("%s: %v", name, err)
+			continue
+		}
+		if err := l.fsys.WriteFileTime(name, data, info.Mode().Perm(), info.ModTime()); err != nil {
+			l.warn("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// followLink resolves a chain of links to an entry of the archive
+func (l *archiveLoader) followLink(target string) (string, bool) {
+	for i := 0; i < maxSymlinkDepth; i++ {
+		if path.IsAbs(target) {
+			return "", false
+		}
+		target = path.Clean(target)
+		if target == ".." || strings.HasPrefix(target, "../") {
+			return "", false
+		}
+		next, isLink := l.links[target]
+		if !isLink {
+			_, err := l.fsys.Stat(target)
+			return target, err == nil
+		}
+		target = next
+	}
+	return "", false
+}
+
+// warn records a problem with an archive entry
+func (l *archiveLoader) warn(format string, args ...any) {
+	l.warnings = append(l.warnings, fmt.Sprintf(format, args...))
+}
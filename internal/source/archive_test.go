@@ -0,0 +1,282 @@
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// archiveEntry describes an entry written into a test archive
+type archiveEntry struct {
+	name     string
+	content  string
+	dir      bool
+	symlink  string
+	hardlink string
+}
+
+// maliciousEntries are entries shared by the tar and zip tests
+var maliciousEntries = []archiveEntry{
+	{name: "rules/", dir: true},
+	{name: "rules/go.md", content: "go rules"},
+	{name: "./rules/nested/ts.md", content: "ts rules"},
+	{name: "../escape.md", content: "outside"},
+	{name: "rules/../../escape2.md", content: "outside"},
+	{name: "/etc/passwd.md", content: "absolute"},
+	{name: "rules/shared.md", symlink: "go.md"},
+	{name: "rules/chain.md", symlink: "shared.md"},
+	{name: "rules/outside.md", symlink: "../../etc/passwd"},
+	{name: "rules/absolute.md", symlink: "/etc/passwd"},
+	{name: "rules/dangling.md", symlink: "missing.md"},
+	{name: "rules/dirlink", symlink: "nested"},
+}
+
+// writeTarGz writes a gzip compressed tar archive
+func writeTarGz(t *testing.T, archivePath string, entries []archiveEntry) {
+	t.Helper()
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content)), Typeflag: tar.TypeReg}
+		switch {
+		case e.dir:
+			hdr = &tar.Header{Name: e.name, Mode: 0755, Typeflag: tar.TypeDir}
+		case e.symlink != "":
+			hdr = &tar.Header{Name: e.name, Linkname: e.symlink, Typeflag: tar.TypeSymlink}
+		case e.hardlink != "":
+			hdr = &tar.Header{Name: e.name, Linkname: e.hardlink, Typeflag: tar.TypeLink}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("Failed to write header %s: %v", e.name, err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.content)); err != nil {
+				t.Fatalf("Failed to write %s: %v", e.name, err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close tar writer: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Failed to close gzip writer: %v", err)
+	}
+}
+
+// writeZip writes a zip archive
+func writeZip(t *testing.T, archivePath string, entries []archiveEntry) {
+	t.Helper()
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+
+	for _, e := range entries {
+		if e.hardlink != "" {
+			continue
+		}
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		content := e.content
+		switch {
+		case e.dir:
+			hdr.SetMode(fs.ModeDir | 0755)
+		case e.symlink != "":
+			hdr.SetMode(fs.ModeSymlink | 0777)
+			content = e.symlink
+		default:
+			hdr.SetMode(0644)
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatalf("Failed to create zip entry %s: %v", e.name, err)
+		}
+		if !e.dir {
+			if _, err := w.Write([]byte(content)); err != nil {
+				t.Fatalf("Failed to write %s: %v", e.name, err)
+			}
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to close zip writer: %v", err)
+	}
+}
+
+// listArchiveFiles returns the regular files of fsys with their content
+func listArchiveFiles(t *testing.T, fsys fs.FS) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		files[p] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk archive: %v", err)
+	}
+	return files
+}
+
+// TestResolveArchive tests reading tar.gz and zip sources with unsafe entries and symlinks
+func TestResolveArchive(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		write func(*testing.T, string, []archiveEntry)
+	}{
+		{name: "tar.gz", file: "rules.tar.gz", write: writeTarGz},
+		{name: "tgz", file: "rules.tgz", write: writeTarGz},
+		{name: "zip", file: "rules.zip", write: writeZip},
+	}
+
+	want := map[string]string{
+		"rules/go.md":        "go rules",
+		"rules/nested/ts.md": "ts rules",
+		"rules/shared.md":    "go rules",
+		"rules/chain.md":     "go rules",
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archivePath := filepath.Join(dir, tt.file)
+			tt.write(t, archivePath, maliciousEntries)
+
+			src, err := Resolve(archivePath, Options{})
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if src.FS == nil || src.Root != archivePath {
+				t.Fatalf("Resolve() = %+v, want an in-memory source rooted at the archive", src)
+			}
+
+			if got := listArchiveFiles(t, src.FS); !reflect.DeepEqual(got, want) {
+				t.Errorf("archive files = %v, want %v", got, want)
+			}
+
+			// Nothing may be written next to the archive
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("Failed to read directory: %v", err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("archive directory contains %d entries, want only the archive", len(entries))
+			}
+
+			// Every skipped entry is reported
+			if len(src.Warnings) != 7 {
+				sort.Strings(src.Warnings)
+				t.Errorf("Warnings = %v, want 7 entries", src.Warnings)
+			}
+		})
+	}
+}
+
+// TestResolveArchiveHardlink tests that tar hard links are resolved inside the archive
+func TestResolveArchiveHardlink(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "rules.tar.gz")
+	writeTarGz(t, archivePath, []archiveEntry{
+		{name: "go.md", content: "go rules"},
+		{name: "copy.md", hardlink: "go.md"},
+		{name: "evil.md", hardlink: "../go.md"},
+	})
+
+	src, err := Resolve(archivePath, Options{})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	want := map[string]string{"go.md": "go rules", "copy.md": "go rules"}
+	if got := listArchiveFiles(t, src.FS); !reflect.DeepEqual(got, want) {
+		t.Errorf("archive files = %v, want %v", got, want)
+	}
+}
+
+// TestResolveArchiveCorrupt tests that unreadable archives are reported
+func TestResolveArchiveCorrupt(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "rules.zip")
+	if err := os.WriteFile(archivePath, []byte("not a zip"), 0644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	if _, err := Resolve(archivePath, Options{}); err == nil {
+		t.Error("Resolve() should fail for a corrupt archive")
+	}
+}
+
+// TestResolveArchiveSizeLimits tests that archives exceeding the size limits are rejected
+func TestResolveArchiveSizeLimits(t *testing.T) {
+	entrySize, totalSize := maxArchiveEntrySize, maxArchiveSize
+	maxArchiveEntrySize, maxArchiveSize = 8, 20
+	t.Cleanup(func() { maxArchiveEntrySize, maxArchiveSize = entrySize, totalSize })
+
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		wantErr string
+	}{
+		{
+			name:    "Within the limits",
+			entries: []archiveEntry{{name: "a.md", content: "12345678"}, {name: "b.md", content: "12345678"}},
+		},
+		{
+			name:    "Entry too large",
+			entries: []archiveEntry{{name: "big.md", content: "123456789"}},
+			wantErr: "entry is larger than the limit",
+		},
+		{
+			name:    "Archive too large",
+			entries: []archiveEntry{{name: "a.md", content: "12345678"}, {name: "b.md", content: "12345678"}, {name: "c.md", content: "12345678"}},
+			wantErr: "archive content is larger than the limit",
+		},
+		{
+			name:    "Links count towards the total",
+			entries: []archiveEntry{{name: "a.md", content: "12345678"}, {name: "b.md", symlink: "a.md"}, {name: "c.md", symlink: "a.md"}},
+			wantErr: "archive content is larger than the limit",
+		},
+	}
+	for _, tt := range tests {
+		for _, format := range []string{"tar.gz", "zip"} {
+			t.Run(tt.name+" "+format, func(t *testing.T) {
+				archivePath := filepath.Join(t.TempDir(), "rules."+format)
+				if format == "zip" {
+					writeZip(t, archivePath, tt.entries)
+				} else {
+					writeTarGz(t, archivePath, tt.entries)
+				}
+
+				_, err := Resolve(archivePath, Options{})
+				if tt.wantErr == "" {
+					if err != nil {
+						t.Errorf("Resolve() error = %v", err)
+					}
+					return
+				}
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Resolve() error = %v, want %q", err, tt.wantErr)
+				}
+			})
+		}
+	}
+}
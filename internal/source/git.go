@@ -0,0 +1,173 @@
+package source
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// gitRepo is a git URL and the ref to check out
+type gitRepo struct {
+	URL string
+	Ref string
+}
+
+// scpLikeURL matches git URLs of the form user@host:path/repo.git
+var scpLikeURL = regexp.MustCompile(`^[\w.-]+@[\w.-]+:[^/]`)
+
+// parseGitSpec recognizes git sources:
+//   - "git+<url>" for any URL understood by git (e.g. git+file:///srv/rules.git, git+https://host/rules)
+//   - "git://", "ssh://" and scp-like "user@host:path" URLs
+//   - "http://" and "https://" URLs ending in ".git"
+//
+// A "#ref" suffix selects a branch, tag or commit. URLs and refs starting with "-" are rejected,
+// so that they cannot be passed to git as options.
+func parseGitSpec(spec string) (gitRepo, bool, error) {
+	url, ref := spec, ""
+	if i := strings.LastIndex(spec, "#"); i >= 0 {
+		url, ref = spec[:i], spec[i+1:]
+	}
+
+	switch {
+	case strings.HasPrefix(url, "git+"):
+		url = strings.TrimPrefix(url, "git+")
+	case strings.HasPrefix(url, "git://"), strings.HasPrefix(url, "ssh://"), scpLikeURL.MatchString(url):
+	case (strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://")) && strings.HasSuffix(url, ".git"):
+	default:
+		return gitRepo{}, false, nil
+	}
+	if url == "" {
+		return gitRepo{}, false, nil
+	}
+	if strings.HasPrefix(url, "-") {
+		return gitRepo{}, true, fmt.Errorf("invalid git URL %q: must not start with '-'", url)
+	}
+	if strings.HasPrefix(ref, "-") {
+		return gitRepo{}, true, fmt.Errorf("invalid git ref %q: must not start with '-'", ref)
+	}
+	return gitRepo{URL: url, Ref: ref}, true, nil
+}
+
+// resolveGit mirrors the repository into the cache, resolves the ref to a commit
+// and exports that commit into a cached working tree, which is reused by every later
+// run resolving the same commit and is never checked for changes
+func resolveGit(spec string, repo gitRepo, opts Options) (*Source, error) {
+	cacheDir, err := opts.cacheDir("git")
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(repo.URL))
+	repoCache := filepath.Join(cacheDir, hex.EncodeToString(sum[:])[:16])
+	gitDir := filepath.Join(repoCache, "repo.git")
+
+	// Clone on first use, fetch afterwards so that moving refs (branches) are up to date
+	if _, err := os.Stat(gitDir); os.IsNotExist(err) {
+		if err := os.MkdirAll(repoCache, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create git cache directory: %w", err)
+		}
+		if _, err := runGit("", "clone", "--quiet", "--mirror", "--", repo.URL, gitDir); err != nil {
+			os.RemoveAll(gitDir)
+			return nil, fmt.Errorf("failed to clone %s: %w", repo.URL, err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to check git cache: %w", err)
+	} else {
+		if _, err := runGit(gitDir, "fetch", "--quiet", "--prune", "--force", "--tags", "origin"); err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %w", repo.URL, err)
+		}
+	}
+
+	ref := repo.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	commit, err := runGit(gitDir, "rev-parse", "--verify", "--quiet", "--end-of-options", ref+"^{commit}")
+	if err != nil {
+		return nil, fmt.Errorf("unknown ref %q in %s", ref, repo.URL)
+	}
+
+	tree, err := checkoutTree(gitDir, filepath.Join(repoCache, "trees"), commit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check out %s at %s: %w", repo.URL, ref, err)
+	}
+
+	return &Source{Spec: spec, Name: spec, Root: tree, Commit: commit}, nil
+}
+
+// checkoutTree exports commit into treesDir/<commit>. Trees are immutable, so an existing
+// tree is reused; new trees are populated in a temporary directory and renamed into place.
+func checkoutTree(gitDir, treesDir, commit string) (string, error) {
+	tree := filepath.Join(treesDir, commit)
+	if _, err := os.Stat(tree); err == nil {
+		return tree, nil
+	}
+
+	if err := os.MkdirAll(treesDir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(treesDir, commit+".tmp-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	// Use a throwaway index so the mirror itself is never modified
+	index := filepath.Join(tmp, ".airule-index")
+	cmd := gitCommand(gitDir, "--work-tree", tmp, "checkout", "--force", commit, "--", ".")
+	cmd.Env = append(cmd.Env, "GIT_INDEX_FILE="+index)
+	if _, err := run(cmd); err != nil {
+		return "", err
+	}
+	if err := os.Remove(index); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	if err := os.Rename(tmp, tree); err != nil {
+		// Another process may have populated the same tree concurrently
+		if _, statErr := os.Stat(tree); statErr == nil {
+			return tree, nil
+		}
+		return "", err
+	}
+	return tree, nil
+}
+
+// runGit runs git with the given git directory (if any) and returns its trimmed output
+func runGit(gitDir string, args ...string) (string, error) {
+	return run(gitCommand(gitDir, args...))
+}
+
+// gitCommand builds a non-interactive git command
+func gitCommand(gitDir string, args ...string) *exec.Cmd {
+	if gitDir != "" {
+		args = append([]string{"--git-dir", gitDir}, args...)
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	return cmd
+}
+
+// run executes cmd and includes git's error output in the returned error
+func run(cmd *exec.Cmd) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var execErr *exec.Error
+		if errors.As(err, &execErr) {
+			return "", fmt.Errorf("git is required for git sources: %w", err)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
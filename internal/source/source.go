@@ -0,0 +1,113 @@
+package source
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/upamune/airule/internal/builtin"
+	"github.com/upamune/airule/internal/finder"
+)
+
+// Source is a resolved --from argument
+type Source struct {
+	// Spec is the argument as given on the command line
+	Spec string
+	// Name labels the source in the picker and summaries
+	Name string
+	// Root is the local directory holding the source tree
+	Root string
+	// Commit is the resolved commit SHA for git sources
+	Commit string
+	// Version is the version of the rule library for built-in sources
+	Version string
+	// FS holds the source tree when it is not a directory on disk (e.g. an archive)
+	FS fs.FS
+	// Warnings describe entries that were skipped while loading the source
+	Warnings []string
+}
+
+// Options configures how sources are resolved
+type Options struct {
+	// CacheDir is where remote sources are cached. Defaults to the user cache directory.
+	CacheDir string
+}
+
+// Resolve turns a --from argument into a source tree.
+// "builtin:" specs select the rule library embedded in the binary,
+// git specs are cloned or fetched into the cache directory and checked out at the requested ref,
+// .tar.gz, .tgz, .tar and .zip files are read into memory, and anything else is treated as a local directory.
+func Resolve(spec string, opts Options) (*Source, error) {
+	if set, ok := strings.CutPrefix(spec, builtin.Scheme); ok {
+		return resolveBuiltin(spec, set)
+	}
+	if repo, ok, err := parseGitSpec(spec); ok {
+		if err != nil {
+			return nil, err
+		}
+		return resolveGit(spec, repo, opts)
+	}
+	if format, ok := archiveFormat(spec); ok {
+		return resolveArchive(spec, format)
+	}
+
+	root, err := filepath.Abs(expandHome(spec))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve source path %s: %w", spec, err)
+	}
+	return &Source{Spec: spec, Name: spec, Root: root}, nil
+}
+
+// resolveBuiltin selects the embedded rule library or one of its sets
+func resolveBuiltin(spec, set string) (*Source, error) {
+	fsys, err := builtin.Open(set)
+	if err != nil {
+		return nil, err
+	}
+	return &Source{Spec: spec, Name: spec, Root: spec, FS: fsys, Version: builtin.Version()}, nil
+}
+
+// ResolveAll resolves every --from argument in declaration order
+func ResolveAll(specs []string, opts Options) ([]*Source, error) {
+	sources := make([]*Source, 0, len(specs))
+	for _, spec := range specs {
+		src, err := Resolve(spec, opts)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
+
+// Finder returns the source as seen by the finder
+func (s *Source) Finder() finder.Source {
+	return finder.Source{Name: s.Name, Root: s.Root, FS: s.FS}
+}
+
+// cacheDir returns the directory used to cache remote sources of the given kind
+func (o Options) cacheDir(kind string) (string, error) {
+	base := o.CacheDir
+	if base == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to determine cache directory: %w", err)
+		}
+		base = filepath.Join(userCache, "airule")
+	}
+	return filepath.Join(base, kind), nil
+}
+
+// expandHome expands a leading "~" to the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
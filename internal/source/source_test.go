@@ -0,0 +1,241 @@
+package source
+
+import (
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/upamune/airule/internal/builtin"
+)
+
+// TestParseGitSpec tests recognition of git sources and their refs
+func TestParseGitSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    gitRepo
+		wantOK  bool
+		wantErr bool
+	}{
+		{name: "Local directory", spec: "./rules", wantOK: false},
+		{name: "Absolute directory", spec: "/srv/rules", wantOK: false},
+		{name: "Directory with hash", spec: "./rules#v1", wantOK: false},
+		{name: "git+file URL", spec: "git+file:///srv/rules.git", want: gitRepo{URL: "file:///srv/rules.git"}, wantOK: true},
+		{name: "git+file URL with tag", spec: "git+file:///srv/rules.git#v1.2.0", want: gitRepo{URL: "file:///srv/rules.git", Ref: "v1.2.0"}, wantOK: true},
+		{name: "git+https URL without .git", spec: "git+https://example.com/org/rules#main", want: gitRepo{URL: "https://example.com/org/rules", Ref: "main"}, wantOK: true},
+		{name: "https URL ending in .git", spec: "https://example.com/org/rules.git", want: gitRepo{URL: "https://example.com/org/rules.git"}, wantOK: true},
+		{name: "https URL without .git", spec: "https://example.com/org/rules", wantOK: false},
+		{name: "ssh URL", spec: "ssh://git@example.com/org/rules.git#abc123", want: gitRepo{URL: "ssh://git@example.com/org/rules.git", Ref: "abc123"}, wantOK: true},
+		{name: "scp-like URL", spec: "git@example.com:org/rules.git", want: gitRepo{URL: "git@example.com:org/rules.git"}, wantOK: true},
+		{name: "git protocol", spec: "git://example.com/rules.git#v1", want: gitRepo{URL: "git://example.com/rules.git", Ref: "v1"}, wantOK: true},
+		{name: "Empty git+ URL", spec: "git+", wantOK: false},
+		{name: "URL starting with a dash", spec: "git+--upload-pack=touch /tmp/pwned", wantOK: true, wantErr: true},
+		{name: "Ref starting with a dash", spec: "git+file:///srv/rules.git#--output=/tmp/pwned", wantOK: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := parseGitSpec(tt.spec)
+			if ok != tt.wantOK {
+				t.Fatalf("parseGitSpec(%q) ok = %v, want %v", tt.spec, ok, tt.wantOK)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseGitSpec(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseGitSpec(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestResolveLocalDirectory tests that local sources resolve to absolute paths
+func TestResolveLocalDirectory(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	src, err := Resolve("rules", Options{})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if want := filepath.Join(dir, "rules"); src.Root != want {
+		t.Errorf("Resolve() root = %q, want %q", src.Root, want)
+	}
+	if src.Name != "rules" || src.Commit != "" {
+		t.Errorf("Resolve() = %+v", src)
+	}
+}
+
+// TestResolveBuiltin tests selecting the embedded rule library and its sets
+func TestResolveBuiltin(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		wantFile string
+		wantErr  bool
+	}{
+		{name: "Whole library", spec: "builtin:", wantFile: "go/style.md"},
+		{name: "Single set", spec: "builtin:go", wantFile: "style.md"},
+		{name: "Unknown set", spec: "builtin:cobol", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := Resolve(tt.spec, Options{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if src.FS == nil || src.Version != builtin.Version() || src.Name != tt.spec {
+				t.Errorf("Resolve(%q) = %+v", tt.spec, src)
+			}
+			if _, err := fs.Stat(src.FS, tt.wantFile); err != nil {
+				t.Errorf("Resolve(%q) should contain %s: %v", tt.spec, tt.wantFile, err)
+			}
+		})
+	}
+}
+
+// git runs a git command in dir for test setup
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=airule", "GIT_AUTHOR_EMAIL=airule@example.com",
+		"GIT_COMMITTER_NAME=airule", "GIT_COMMITTER_EMAIL=airule@example.com",
+		"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v failed: %v\n%s", args, err, out)
+	}
+	return string(out)
+}
+
+// setupGitRepo creates a bare repository with a tagged first commit and a second commit on main
+func setupGitRepo(t *testing.T) (bare, work string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	tempDir := t.TempDir()
+	work = filepath.Join(tempDir, "work")
+	bare = filepath.Join(tempDir, "rules.git")
+
+	git(t, tempDir, "init", "--quiet", "--initial-branch=main", work)
+	writeFile(t, filepath.Join(work, "go.md"), "v1 go rules")
+	writeFile(t, filepath.Join(work, "old.md"), "removed later")
+	git(t, work, "add", "-A")
+	git(t, work, "commit", "--quiet", "-m", "first")
+	git(t, work, "tag", "v1.0.0")
+
+	writeFile(t, filepath.Join(work, "go.md"), "v2 go rules")
+	git(t, work, "rm", "--quiet", "old.md")
+	git(t, work, "commit", "--quiet", "-am", "second")
+
+	git(t, tempDir, "clone", "--quiet", "--bare", work, bare)
+	return bare, work
+}
+
+// writeFile writes content to path, creating parent directories
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory for %s: %v", path, err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+// readFile reads path or fails the test
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return string(content)
+}
+
+// TestResolveGit tests cloning, checking out refs and refreshing a cached repository
+func TestResolveGit(t *testing.T) {
+	bare, work := setupGitRepo(t)
+	opts := Options{CacheDir: t.TempDir()}
+	url := "git+file://" + bare
+
+	// A tag checks out the tagged tree
+	tagged, err := Resolve(url+"#v1.0.0", opts)
+	if err != nil {
+		t.Fatalf("Resolve(tag) error = %v", err)
+	}
+	wantCommit := git(t, work, "rev-parse", "v1.0.0^{commit}")
+	if tagged.Commit+"\n" != wantCommit {
+		t.Errorf("Resolve(tag) commit = %q, want %q", tagged.Commit, wantCommit)
+	}
+	if got := readFile(t, filepath.Join(tagged.Root, "go.md")); got != "v1 go rules" {
+		t.Errorf("Resolve(tag) go.md = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(tagged.Root, "old.md")); err != nil {
+		t.Errorf("Resolve(tag) should contain old.md: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tagged.Root, ".git")); !os.IsNotExist(err) {
+		t.Errorf("checked out tree should not contain a .git directory")
+	}
+
+	// The default ref is HEAD of the remote
+	head, err := Resolve(url, opts)
+	if err != nil {
+		t.Fatalf("Resolve(HEAD) error = %v", err)
+	}
+	if got := readFile(t, filepath.Join(head.Root, "go.md")); got != "v2 go rules" {
+		t.Errorf("Resolve(HEAD) go.md = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(head.Root, "old.md")); !os.IsNotExist(err) {
+		t.Errorf("Resolve(HEAD) should not contain old.md")
+	}
+
+	// A commit SHA can be used as ref
+	byCommit, err := Resolve(url+"#"+tagged.Commit, opts)
+	if err != nil {
+		t.Fatalf("Resolve(commit) error = %v", err)
+	}
+	if byCommit.Root != tagged.Root {
+		t.Errorf("Resolve(commit) root = %q, want cached tree %q", byCommit.Root, tagged.Root)
+	}
+
+	// New commits on a branch are fetched on the next run
+	writeFile(t, filepath.Join(work, "go.md"), "v3 go rules")
+	git(t, work, "commit", "--quiet", "-am", "third")
+	git(t, work, "push", "--quiet", bare, "main")
+
+	branch, err := Resolve(url+"#main", opts)
+	if err != nil {
+		t.Fatalf("Resolve(branch) error = %v", err)
+	}
+	if got := readFile(t, filepath.Join(branch.Root, "go.md")); got != "v3 go rules" {
+		t.Errorf("Resolve(branch) go.md = %q", got)
+	}
+
+	// Unknown refs are reported
+	if _, err := Resolve(url+"#does-not-exist", opts); err == nil {
+		t.Error("Resolve() should fail for an unknown ref")
+	}
+}
+
+// TestResolveGitMissingRepository tests that clone failures are reported
+func TestResolveGitMissingRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	missing := filepath.Join(t.TempDir(), "missing.git")
+	if _, err := Resolve("git+file://"+missing, Options{CacheDir: t.TempDir()}); err == nil {
+		t.Error("Resolve() should fail for a missing repository")
+	}
+}
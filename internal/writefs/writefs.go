@@ -0,0 +1,149 @@
+package writefs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FS is a file system that can be written to. Names are slash-separated paths
+// relative to the root of the file system, as for fs.FS.
+type FS interface {
+	fs.StatFS
+	fs.ReadDirFS
+	// Lstat returns the file info of name without following a final symbolic link
+	Lstat(name string) (fs.FileInfo, error)
+	// ReadLink returns the target of the named symbolic link
+	ReadLink(name string) (string, error)
+	// Create creates or truncates the named file. Parent directories must exist.
+	Create(name string, perm fs.FileMode) (io.WriteCloser, error)
+	// MkdirAll creates a directory and all missing parents
+	MkdirAll(name string, perm fs.FileMode) error
+	// RemoveAll removes name and everything it contains. A missing name is not an error.
+	RemoveAll(name string) error
+	// Chmod changes the permission bits of name
+	Chmod(name string, mode fs.FileMode) error
+	// Chtimes changes the access and modification times of name
+	Chtimes(name string, atime, mtime time.Time) error
+	// Symlink creates newname as a symbolic link to oldname
+	Symlink(oldname, newname string) error
+	// Link creates newname as a hard link to the file at the path oldname on disk
+	Link(oldname, newname string) error
+}
+
+// Dir is a writable file system rooted at a directory on disk
+type Dir string
+
+// path converts a file system name to a path on disk
+func (d Dir) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(string(d), filepath.FromSlash(name)), nil
+}
+
+// Open opens the named file for reading
+func (d Dir) Open(name string) (fs.File, error) {
+	return os.DirFS(string(d)).Open(name)
+}
+
+// Stat returns the file info of the named file
+func (d Dir) Stat(name string) (fs.FileInfo, error) {
+	p, err := d.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
+
+// Lstat returns the file info of name without following a final symbolic link
+func (d Dir) Lstat(name string) (fs.FileInfo, error) {
+	p, err := d.path("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Lstat(p)
+}
+
+// ReadLink returns the target of the named symbolic link
+func (d Dir) ReadLink(name string) (string, error) {
+	p, err := d.path("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	return os.Readlink(p)
+}
+
+// ReadDir returns the entries of the named directory sorted by name
+func (d Dir) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := d.path("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadDir(p)
+}
+
+// Create creates or truncates the named file
+func (d Dir) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	p, err := d.path("create", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+}
+
+// MkdirAll creates a directory and all missing parents
+func (d Dir) MkdirAll(name string, perm fs.FileMode) error {
+	p, err := d.path("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(p, perm)
+}
+
+// RemoveAll removes name and everything it contains
+func (d Dir) RemoveAll(name string) error {
+	p, err := d.path("remove", name)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(p)
+}
+
+// Chmod changes the permission bits of name
+func (d Dir) Chmod(name string, mode fs.FileMode) error {
+	p, err := d.path("chmod", name)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(p, mode)
+}
+
+// Chtimes changes the access and modification times of name
+func (d Dir) Chtimes(name string, atime, mtime time.Time) error {
+	p, err := d.path("chtimes", name)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(p, atime, mtime)
+}
+
+// Symlink creates newname as a symbolic link to oldname. The target is stored as given.
+func (d Dir) Symlink(oldname, newname string) error {
+	p, err := d.path("symlink", newname)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(oldname, p)
+}
+
+// Link creates newname as a hard link to the file at the path oldname on disk
+func (d Dir) Link(oldname, newname string) error {
+	p, err := d.path("link", newname)
+	if err != nil {
+		return err
+	}
+	return os.Link(oldname, p)
+}
@@ -0,0 +1,85 @@
+package writefs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestDir tests the disk-backed writable file system
+func TestDir(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "dst")
+	d := Dir(root)
+
+	if err := d.MkdirAll(".", 0755); err != nil {
+		t.Fatalf("MkdirAll(.) error = %v", err)
+	}
+	if err := d.MkdirAll("rules/go", 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+
+	w, err := d.Create("rules/go/style.md", 0600)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := w.Write([]byte("style")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "rules", "go", "style.md"))
+	if err != nil || string(data) != "style" {
+		t.Errorf("written file = %q, %v", data, err)
+	}
+	if data, err := fs.ReadFile(d, "rules/go/style.md"); err != nil || string(data) != "style" {
+		t.Errorf("fs.ReadFile() = %q, %v", data, err)
+	}
+
+	if err := d.Chmod("rules/go/style.md", 0644); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+	info, err := d.Lstat("rules/go/style.md")
+	if err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("Lstat() = %v, %v, want mode 0644", info, err)
+	}
+
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := d.Chtimes("rules/go/style.md", mtime, mtime); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+	if info, err := d.Stat("rules/go/style.md"); err != nil || !info.ModTime().Equal(mtime) {
+		t.Errorf("Stat() after Chtimes = %v, %v, want modification time %v", info, err, mtime)
+	}
+
+	entries, err := d.ReadDir("rules")
+	if err != nil || len(entries) != 1 || entries[0].Name() != "go" {
+		t.Errorf("ReadDir(rules) = %v, %v", entries, err)
+	}
+
+	if err := d.Symlink("go/style.md", "rules/style.md"); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+	if target, err := d.ReadLink("rules/style.md"); err != nil || target != "go/style.md" {
+		t.Errorf("ReadLink() = %q, %v, want go/style.md", target, err)
+	}
+
+	if err := d.RemoveAll("rules"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if _, err := d.Stat("rules"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() after RemoveAll error = %v, want ErrNotExist", err)
+	}
+
+	// Names must stay inside the root
+	if _, err := d.Create("../escape.md", 0644); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Create(../escape.md) error = %v, want ErrInvalid", err)
+	}
+	if err := d.RemoveAll("/tmp"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("RemoveAll(/tmp) error = %v, want ErrInvalid", err)
+	}
+}